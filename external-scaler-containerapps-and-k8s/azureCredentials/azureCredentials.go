@@ -0,0 +1,156 @@
+package azureCredentials
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+const (
+	CREDENTIAL_TYPE_DEFAULT           = "default"
+	CREDENTIAL_TYPE_MANAGED_IDENTITY  = "managedIdentity"
+	CREDENTIAL_TYPE_WORKLOAD_IDENTITY = "workloadIdentity"
+	CREDENTIAL_TYPE_CLIENT_SECRET     = "clientSecret"
+
+	// cached tokens are refreshed this long before they expire
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// Options selects the credential used to get Azure tokens. Readers created with the same
+// Options share one credential and its token cache
+type Options struct {
+	// CredentialType is one of the CREDENTIAL_TYPE_ constants, defaults to CREDENTIAL_TYPE_DEFAULT,
+	// which is DefaultAzureCredential configured through the scaler's environment variables
+	CredentialType string
+	ClientID       string
+	TenantID       string
+	ClientSecret   string
+	// AuthorityHost is the Microsoft Entra endpoint of the cloud, defaults to the public cloud
+	AuthorityHost string
+}
+
+// CachedTokenCredential wraps a credential, caching its tokens by scope until near expiry
+type CachedTokenCredential struct {
+	credential azcore.TokenCredential
+
+	mu     sync.Mutex
+	tokens map[string]azcore.AccessToken
+}
+
+func NewCachedTokenCredential(credential azcore.TokenCredential) *CachedTokenCredential {
+	return &CachedTokenCredential{
+		credential: credential,
+		tokens:     map[string]azcore.AccessToken{},
+	}
+}
+
+func (c *CachedTokenCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	key := strings.Join(opts.Scopes, " ")
+	if opts.TenantID != "" || opts.Claims != "" {
+		// tokens for claims challenges or other tenants are not cached
+		return c.credential.GetToken(ctx, opts)
+	}
+
+	// holding the lock while getting a token stops concurrent readers all requesting one
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token, ok := c.tokens[key]; ok && time.Until(token.ExpiresOn) > tokenRefreshMargin {
+		return token, nil
+	}
+
+	slog.Debug(fmt.Sprintf("Getting token for scopes %s", key))
+	token, err := c.credential.GetToken(ctx, opts)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	c.tokens[key] = token
+
+	return token, nil
+}
+
+var (
+	credentialsMu sync.Mutex
+	credentials   = map[Options]*CachedTokenCredential{}
+)
+
+// GetCredential returns the shared cached credential for options, creating it on first use
+func GetCredential(options Options) (*CachedTokenCredential, error) {
+	if options.CredentialType == "" {
+		options.CredentialType = CREDENTIAL_TYPE_DEFAULT
+	}
+
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+
+	if credential, ok := credentials[options]; ok {
+		return credential, nil
+	}
+
+	credential, err := newCredential(options)
+	if err != nil {
+		return nil, err
+	}
+	cachedCredential := NewCachedTokenCredential(credential)
+	credentials[options] = cachedCredential
+
+	return cachedCredential, nil
+}
+
+func newCredential(options Options) (azcore.TokenCredential, error) {
+	clientOptions := azcore.ClientOptions{}
+	if options.AuthorityHost != "" {
+		clientOptions.Cloud = cloud.Configuration{ActiveDirectoryAuthorityHost: options.AuthorityHost + "/"}
+	}
+
+	switch options.CredentialType {
+	case CREDENTIAL_TYPE_DEFAULT:
+		credential, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions, TenantID: options.TenantID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create default Azure credential: %w", err)
+		}
+		return credential, nil
+
+	case CREDENTIAL_TYPE_MANAGED_IDENTITY:
+		managedIdentityOptions := &azidentity.ManagedIdentityCredentialOptions{ClientOptions: clientOptions}
+		if options.ClientID != "" {
+			managedIdentityOptions.ID = azidentity.ClientID(options.ClientID)
+		}
+		credential, err := azidentity.NewManagedIdentityCredential(managedIdentityOptions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create managed identity credential: %w", err)
+		}
+		return credential, nil
+
+	case CREDENTIAL_TYPE_WORKLOAD_IDENTITY:
+		credential, err := azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: clientOptions,
+			ClientID:      options.ClientID,
+			TenantID:      options.TenantID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create workload identity credential: %w", err)
+		}
+		return credential, nil
+
+	case CREDENTIAL_TYPE_CLIENT_SECRET:
+		if options.ClientID == "" || options.TenantID == "" || options.ClientSecret == "" {
+			return nil, fmt.Errorf("client ID, tenant ID and client secret are required for the %s credential type", CREDENTIAL_TYPE_CLIENT_SECRET)
+		}
+		credential, err := azidentity.NewClientSecretCredential(options.TenantID, options.ClientID, options.ClientSecret, &azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create client secret credential: %w", err)
+		}
+		return credential, nil
+	}
+
+	return nil, fmt.Errorf("unsupported Azure credential type %q, supported types are %s, %s, %s and %s", options.CredentialType, CREDENTIAL_TYPE_DEFAULT, CREDENTIAL_TYPE_MANAGED_IDENTITY, CREDENTIAL_TYPE_WORKLOAD_IDENTITY, CREDENTIAL_TYPE_CLIENT_SECRET)
+}
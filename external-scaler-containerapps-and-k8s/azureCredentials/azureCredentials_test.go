@@ -0,0 +1,97 @@
+package azureCredentials
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+type fakeCredential struct {
+	calls     map[string]int
+	expiresIn time.Duration
+}
+
+func (f *fakeCredential) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	f.calls[opts.Scopes[0]]++
+	return azcore.AccessToken{Token: opts.Scopes[0], ExpiresOn: time.Now().Add(f.expiresIn)}, nil
+}
+
+func TestCachedTokenCredentialCachesByScope(t *testing.T) {
+	fake := &fakeCredential{calls: map[string]int{}, expiresIn: time.Hour}
+	credential := NewCachedTokenCredential(fake)
+
+	scopes := []string{"https://management.azure.com/.default", "https://api.loganalytics.io/.default"}
+	for i := 0; i < 3; i++ {
+		for _, scope := range scopes {
+			token, err := credential.GetToken(context.Background(), policy.TokenRequestOptions{Scopes: []string{scope}})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if token.Token != scope {
+				t.Errorf("Expected token for %s, but got token for %s", scope, token.Token)
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		if fake.calls[scope] != 1 {
+			t.Errorf("Expected 1 token request for %s, but got %d", scope, fake.calls[scope])
+		}
+	}
+}
+
+func TestCachedTokenCredentialRefreshesNearExpiry(t *testing.T) {
+	fake := &fakeCredential{calls: map[string]int{}, expiresIn: tokenRefreshMargin - time.Second}
+	credential := NewCachedTokenCredential(fake)
+
+	opts := policy.TokenRequestOptions{Scopes: []string{"https://management.azure.com/.default"}}
+	for i := 0; i < 2; i++ {
+		if _, err := credential.GetToken(context.Background(), opts); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if fake.calls[opts.Scopes[0]] != 2 {
+		t.Errorf("Expected 2 token requests, but got %d", fake.calls[opts.Scopes[0]])
+	}
+}
+
+func TestGetCredentialSharesCredentials(t *testing.T) {
+	options := Options{CredentialType: CREDENTIAL_TYPE_CLIENT_SECRET, ClientID: "client", TenantID: "tenant", ClientSecret: "secret"}
+	first, err := GetCredential(options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := GetCredential(options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	options.ClientID = "other-client"
+	other, err := GetCredential(options)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if first != second {
+		t.Errorf("Expected the same credential for the same options")
+	}
+	if first == other {
+		t.Errorf("Expected a different credential for different options")
+	}
+}
+
+func TestGetCredentialValidatesOptions(t *testing.T) {
+	testCases := []Options{
+		{CredentialType: "certificate"},
+		{CredentialType: CREDENTIAL_TYPE_CLIENT_SECRET, ClientID: "client", TenantID: "tenant"},
+	}
+
+	for _, options := range testCases {
+		if _, err := GetCredential(options); err == nil {
+			t.Errorf("Expected an error for %+v", options)
+		}
+	}
+}
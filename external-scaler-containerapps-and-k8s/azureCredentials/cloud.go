@@ -0,0 +1,138 @@
+package azureCredentials
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+const (
+	AZURE_PUBLIC_CLOUD        = "AzurePublicCloud"
+	AZURE_US_GOVERNMENT_CLOUD = "AzureUSGovernmentCloud"
+	AZURE_CHINA_CLOUD         = "AzureChinaCloud"
+	// AZURE_PRIVATE_CLOUD has no defaults, all endpoints have to be set explicitly
+	AZURE_PRIVATE_CLOUD = "Private"
+)
+
+// Cloud holds the endpoints and token audiences of an Azure cloud. Endpoints have no trailing slash
+type Cloud struct {
+	Name                         string
+	ActiveDirectoryAuthorityHost string
+	ResourceManagerEndpoint      string
+	ResourceManagerAudience      string
+	LogAnalyticsEndpoint         string
+	LogAnalyticsAudience         string
+	// StorageEndpointSuffix builds storage account endpoints, e.g. <account>.queue.<suffix>
+	StorageEndpointSuffix string
+	StorageAudience       string
+}
+
+// storage accepts the same token audience in every cloud
+const defaultStorageAudience = "https://storage.azure.com"
+
+var clouds = map[string]Cloud{
+	AZURE_PUBLIC_CLOUD: {
+		Name:                         AZURE_PUBLIC_CLOUD,
+		ActiveDirectoryAuthorityHost: "https://login.microsoftonline.com",
+		ResourceManagerEndpoint:      "https://management.azure.com",
+		ResourceManagerAudience:      "https://management.azure.com",
+		LogAnalyticsEndpoint:         "https://api.loganalytics.io",
+		LogAnalyticsAudience:         "https://api.loganalytics.io",
+		StorageEndpointSuffix:        "core.windows.net",
+		StorageAudience:              defaultStorageAudience,
+	},
+	AZURE_US_GOVERNMENT_CLOUD: {
+		Name:                         AZURE_US_GOVERNMENT_CLOUD,
+		ActiveDirectoryAuthorityHost: "https://login.microsoftonline.us",
+		ResourceManagerEndpoint:      "https://management.usgovcloudapi.net",
+		ResourceManagerAudience:      "https://management.usgovcloudapi.net",
+		LogAnalyticsEndpoint:         "https://api.loganalytics.us",
+		LogAnalyticsAudience:         "https://api.loganalytics.us",
+		StorageEndpointSuffix:        "core.usgovcloudapi.net",
+		StorageAudience:              defaultStorageAudience,
+	},
+	AZURE_CHINA_CLOUD: {
+		Name:                         AZURE_CHINA_CLOUD,
+		ActiveDirectoryAuthorityHost: "https://login.chinacloudapi.cn",
+		ResourceManagerEndpoint:      "https://management.chinacloudapi.cn",
+		ResourceManagerAudience:      "https://management.chinacloudapi.cn",
+		LogAnalyticsEndpoint:         "https://api.loganalytics.azure.cn",
+		LogAnalyticsAudience:         "https://api.loganalytics.azure.cn",
+		StorageEndpointSuffix:        "core.chinacloudapi.cn",
+		StorageAudience:              defaultStorageAudience,
+	},
+	AZURE_PRIVATE_CLOUD: {
+		Name: AZURE_PRIVATE_CLOUD,
+	},
+}
+
+// GetCloud returns the named cloud, defaulting to AZURE_PUBLIC_CLOUD, with any non empty
+// field of overrides replacing the cloud's value
+func GetCloud(name string, overrides Cloud) (Cloud, error) {
+	if name == "" {
+		name = AZURE_PUBLIC_CLOUD
+	}
+	c, ok := clouds[name]
+	if !ok {
+		return Cloud{}, fmt.Errorf("unsupported Azure cloud %q, supported clouds are %s, %s, %s and %s", name, AZURE_PUBLIC_CLOUD, AZURE_US_GOVERNMENT_CLOUD, AZURE_CHINA_CLOUD, AZURE_PRIVATE_CLOUD)
+	}
+
+	override := func(value *string, overrideValue string) {
+		if overrideValue != "" {
+			*value = strings.TrimSuffix(overrideValue, "/")
+		}
+	}
+	override(&c.ActiveDirectoryAuthorityHost, overrides.ActiveDirectoryAuthorityHost)
+	override(&c.ResourceManagerEndpoint, overrides.ResourceManagerEndpoint)
+	override(&c.ResourceManagerAudience, overrides.ResourceManagerAudience)
+	override(&c.LogAnalyticsEndpoint, overrides.LogAnalyticsEndpoint)
+	override(&c.LogAnalyticsAudience, overrides.LogAnalyticsAudience)
+	override(&c.StorageEndpointSuffix, strings.TrimPrefix(overrides.StorageEndpointSuffix, "."))
+	override(&c.StorageAudience, overrides.StorageAudience)
+
+	// audiences default to the endpoints they are for
+	if c.ResourceManagerAudience == "" {
+		c.ResourceManagerAudience = c.ResourceManagerEndpoint
+	}
+	if c.LogAnalyticsAudience == "" {
+		c.LogAnalyticsAudience = c.LogAnalyticsEndpoint
+	}
+	if c.StorageAudience == "" {
+		c.StorageAudience = defaultStorageAudience
+	}
+
+	if c.ActiveDirectoryAuthorityHost == "" || c.ResourceManagerEndpoint == "" || c.LogAnalyticsEndpoint == "" {
+		return Cloud{}, fmt.Errorf("the authority host, resource manager endpoint and log analytics endpoint are required for the %s cloud", name)
+	}
+
+	return c, nil
+}
+
+// ResourceManagerScope is the token scope for ARM requests
+func (c Cloud) ResourceManagerScope() string {
+	return c.ResourceManagerAudience + "/.default"
+}
+
+// LogAnalyticsScope is the token scope for Log Analytics query requests
+func (c Cloud) LogAnalyticsScope() string {
+	return c.LogAnalyticsAudience + "/.default"
+}
+
+// StorageScope is the token scope for storage data plane requests
+func (c Cloud) StorageScope() string {
+	return c.StorageAudience + "/.default"
+}
+
+// Configuration returns the cloud as an Azure SDK cloud configuration, for SDK clients
+func (c Cloud) Configuration() cloud.Configuration {
+	return cloud.Configuration{
+		ActiveDirectoryAuthorityHost: c.ActiveDirectoryAuthorityHost + "/",
+		Services: map[cloud.ServiceName]cloud.ServiceConfiguration{
+			cloud.ResourceManager: {
+				Audience: c.ResourceManagerAudience,
+				Endpoint: c.ResourceManagerEndpoint,
+			},
+		},
+	}
+}
@@ -0,0 +1,97 @@
+package azureCredentials
+
+import "testing"
+
+func TestGetCloud(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cloudName   string
+		overrides   Cloud
+		expected    Cloud
+		expectError bool
+	}{
+		{
+			name:     "defaults to the public cloud",
+			expected: clouds[AZURE_PUBLIC_CLOUD],
+		},
+		{
+			name:      "us government",
+			cloudName: AZURE_US_GOVERNMENT_CLOUD,
+			expected:  clouds[AZURE_US_GOVERNMENT_CLOUD],
+		},
+		{
+			name:      "override endpoints",
+			cloudName: AZURE_PUBLIC_CLOUD,
+			overrides: Cloud{ResourceManagerEndpoint: "http://127.0.0.1:8080/", LogAnalyticsEndpoint: "http://127.0.0.1:8081"},
+			expected: Cloud{
+				Name:                         AZURE_PUBLIC_CLOUD,
+				ActiveDirectoryAuthorityHost: "https://login.microsoftonline.com",
+				ResourceManagerEndpoint:      "http://127.0.0.1:8080",
+				ResourceManagerAudience:      "https://management.azure.com",
+				LogAnalyticsEndpoint:         "http://127.0.0.1:8081",
+				LogAnalyticsAudience:         "https://api.loganalytics.io",
+				StorageEndpointSuffix:        "core.windows.net",
+				StorageAudience:              "https://storage.azure.com",
+			},
+		},
+		{
+			name:      "private cloud audiences default to endpoints",
+			cloudName: AZURE_PRIVATE_CLOUD,
+			overrides: Cloud{ActiveDirectoryAuthorityHost: "https://login.contoso.com", ResourceManagerEndpoint: "https://management.contoso.com", LogAnalyticsEndpoint: "https://api.loganalytics.contoso.com", StorageEndpointSuffix: ".core.contoso.com"},
+			expected: Cloud{
+				Name:                         AZURE_PRIVATE_CLOUD,
+				ActiveDirectoryAuthorityHost: "https://login.contoso.com",
+				ResourceManagerEndpoint:      "https://management.contoso.com",
+				ResourceManagerAudience:      "https://management.contoso.com",
+				LogAnalyticsEndpoint:         "https://api.loganalytics.contoso.com",
+				LogAnalyticsAudience:         "https://api.loganalytics.contoso.com",
+				StorageEndpointSuffix:        "core.contoso.com",
+				StorageAudience:              "https://storage.azure.com",
+			},
+		},
+		{
+			name:        "private cloud without endpoints",
+			cloudName:   AZURE_PRIVATE_CLOUD,
+			expectError: true,
+		},
+		{
+			name:        "unknown cloud",
+			cloudName:   "AzureGermanCloud",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		result, err := GetCloud(tc.cloudName, tc.overrides)
+
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("Expected an error (%q)", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%q)", err, tc.name)
+		}
+		if result != tc.expected {
+			t.Errorf("Expected %+v, but got %+v (%q)", tc.expected, result, tc.name)
+		}
+	}
+}
+
+func TestCloudScopes(t *testing.T) {
+	c, err := GetCloud(AZURE_CHINA_CLOUD, Cloud{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.ResourceManagerScope() != "https://management.chinacloudapi.cn/.default" {
+		t.Errorf("Unexpected resource manager scope %s", c.ResourceManagerScope())
+	}
+	if c.LogAnalyticsScope() != "https://api.loganalytics.azure.cn/.default" {
+		t.Errorf("Unexpected log analytics scope %s", c.LogAnalyticsScope())
+	}
+	if c.StorageScope() != "https://storage.azure.com/.default" {
+		t.Errorf("Unexpected storage scope %s", c.StorageScope())
+	}
+}
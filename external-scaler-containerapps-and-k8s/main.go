@@ -2,35 +2,93 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net"
+	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/azureCredentials"
 	pb "github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/externalscaler"
 	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/metricsReaders"
 	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/replicaCountReaders"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	// registers the postgres database/sql driver used by the postgres metrics backend
+	_ "github.com/lib/pq"
 
 	"os"
 	"time"
 )
 
 type ReplicaCountReader interface {
-	GetInstanceCount() (int, error)
+	GetInstanceCount(ctx context.Context) (int, error)
 }
 
 type MetricsReader interface {
-	GetQueueLength() (int, error)
-	GetRate429Errors() (int, error)
+	GetQueueLength(ctx context.Context) (int, error)
+	GetRate429Errors(ctx context.Context) (int, error)
+}
+
+// DeadLetterReader is implemented by metrics readers whose queue has a dead letter count,
+// used to pause scale up while dead lettered messages pile up
+type DeadLetterReader interface {
+	GetDeadLetterCount(ctx context.Context) (int, error)
+}
+
+// getDeadLetterReader returns the DeadLetterReader of reader, if its configuration has a dead
+// letter count. Readers such as SplitMetricsReader that have one for some configurations only
+// report it with SupportsDeadLetterCount
+func getDeadLetterReader(reader MetricsReader) (DeadLetterReader, bool) {
+	deadLetterReader, ok := reader.(DeadLetterReader)
+	if !ok {
+		return nil, false
+	}
+	if supporter, ok := reader.(interface{ SupportsDeadLetterCount() bool }); ok && !supporter.SupportsDeadLetterCount() {
+		return nil, false
+	}
+	return deadLetterReader, true
 }
 
 const (
 	METRICS_BACKEND_PROMETHEUS              = "prometheus"
 	METRICS_BACKEND_AZURE                   = "azure"
+	METRICS_BACKEND_RABBITMQ                = "rabbitmq"
+	METRICS_BACKEND_KAFKA                   = "kafka"
+	METRICS_BACKEND_REDIS                   = "redis"
+	METRICS_BACKEND_SQS                     = "sqs"
+	METRICS_BACKEND_NATS                    = "nats"
+	METRICS_BACKEND_POSTGRES                = "postgres"
+	METRICS_BACKEND_HTTP                    = "http"
+	METRICS_BACKEND_SCRAPE                  = "scrape"
+	METRICS_BACKEND_OTLP                    = "otlp"
+	METRICS_BACKEND_PUSH                    = "push"
 	INSTANCE_COMPUTE_BACKEND_KUBERNETES     = "kubernetes"
 	INSTANCE_COMPUTE_BACKEND_CONTAINER_APPS = "containerApps"
+
+	// metadata keys selecting the metrics backends of the queue length and the 429 errors, also
+	// the prefixes of their metadata blocks
+	QUEUE_SOURCE_KEY = "queueSource"
+	ERROR_SOURCE_KEY = "errorSource"
+
+	// what GetMetrics does when a read fails, fail it, or use the read's last known value
+	FALLBACK_POLICY_FAIL       = "fail"
+	FALLBACK_POLICY_LAST_KNOWN = "lastKnown"
+
+	// default timeout of each read of GetMetrics
+	DEFAULT_READ_TIMEOUT_SECONDS = 5
+	// default time a poller keeps polling after the last metric request of its scaled object
+	DEFAULT_POLLER_IDLE_TIMEOUT_SECONDS = 300
 )
 
 type ExternalScaler struct {
@@ -39,6 +97,13 @@ type ExternalScaler struct {
 	// state variables
 	lastScaleDownRequestTime               time.Time
 	replicaCountDuringLastScaleDownRequest int
+	// dead letter counts read by the previous GetMetrics call, by scaled object
+	lastDeadLetterCounts lastDeadLetterCounts
+	// successful reads of GetMetrics, by read, for the lastKnown fallback policy
+	lastKnownReads lastKnownMetricReads
+	// background pollers, by the readers they read
+	pollersMu sync.Mutex
+	pollers   map[pollerKey]*metricsPoller
 
 	// common settings
 	QUEUE_MESSAGE_COUNT_PER_REPLICA          int
@@ -48,12 +113,61 @@ type ExternalScaler struct {
 	METRICS_BACKEND          string
 	INSTANCE_COMPUTE_BACKEND string
 
+	// metrics backends of the queue length and the 429 errors, set via metadata and defaulting
+	// to METRICS_BACKEND
+	QUEUE_SOURCE string
+	ERROR_SOURCE string
+
+	// scaler side guards on what scaler metadata can query
+	ALLOWED_METRIC_NAMES         metricsReaders.Allowlist
+	ALLOWED_LOG_ANALYTICS_TABLES metricsReaders.Allowlist
+	ALLOW_USER_DEFINED_QUERIES   bool
+
 	// Prometheus Metrics Reader settings set via metadata
 	PROMETHEUS_ENDPOINT          string
 	MSG_QUEUE_LENGTH_METRIC_NAME string
 
+	// RabbitMQ Metrics Reader settings set via metadata
+	RABBITMQ_MANAGEMENT_ENDPOINT string
+	RABBITMQ_VHOST               string
+	RABBITMQ_QUEUE_NAME          string
+
+	// Kafka Metrics Reader settings set via metadata
+	KAFKA_BOOTSTRAP_SERVERS []string
+	KAFKA_TOPIC             string
+	KAFKA_CONSUMER_GROUP    string
+
+	// Redis Metrics Reader settings set via metadata
+	REDIS_ADDRESS        string
+	REDIS_KEY            string
+	REDIS_KEY_TYPE       string
+	REDIS_CONSUMER_GROUP string
+
+	// SQS Metrics Reader settings set via metadata
+	SQS_QUEUE_URL string
+	AWS_REGION    string
+
+	// NATS JetStream Metrics Reader settings set via metadata
+	NATS_URL      string
+	NATS_STREAM   string
+	NATS_CONSUMER string
+
+	// Postgres Metrics Reader settings set via metadata
+	POSTGRES_TABLE          string
+	POSTGRES_STATUS_COLUMN  string
+	POSTGRES_PENDING_STATUS string
+
+	// HTTP JSON Metrics Reader settings set via metadata
+	HTTP_URL string
+
 	// common metrics settings set via metadata
 	RATE_429_ERRORS_METRIC_NAME string
+	RATE_429_LOOKBACK_MINUTES   int
+
+	// user defined KQL / PromQL queries set via metadata or a referenced ConfigMap, rendered
+	// with the scaled object's namespace, name and the lookback window
+	QUEUE_LENGTH_QUERY string
+	ERROR_RATE_QUERY   string
 
 	// common settings set via metadata
 	MIN_REPLICAS int
@@ -73,12 +187,61 @@ type ExternalScaler struct {
 	SERVICE_BUS_RESOURCE_ID             string
 	SERVICE_BUS_QUEUE_OR_TOPIC_NAME     string
 	SERVICE_BUS_TOPIC_SUBSCRIPTION_NAME string
+	SERVICE_BUS_QUEUE_LENGTH_SOURCE     string
+	SERVICE_BUS_CONNECTION_STRING       *metricsReaders.ServiceBusConnectionString
+	SERVICE_BUS_BACKLOG_COUNTS          []string
+
+	// Azure Storage Queue settings set via metadata, used instead of service bus when set
+	STORAGE_QUEUE_NAME     string
+	STORAGE_ACCOUNT_NAME   string
+	STORAGE_QUEUE_ENDPOINT string
+
+	// scale up is paused while the dead letter count grows by at least this much between
+	// GetMetrics calls, 0 disables the check
+	DEAD_LETTER_GROWTH_THRESHOLD int
+
+	// timeouts of the concurrent reads of GetMetrics, bounded by the deadline of KEDA's call,
+	// set via metadata
+	REPLICA_COUNT_TIMEOUT_SECONDS   int
+	QUEUE_LENGTH_TIMEOUT_SECONDS    int
+	RATE_429_ERRORS_TIMEOUT_SECONDS int
+
+	// retries of the reads of GetMetrics failing with transient errors, set via metadata
+	RETRY_POLICY metricsReaders.RetryPolicy
+
+	// what GetMetrics does when a read fails or times out, set via metadata
+	FALLBACK_POLICY          string
+	FALLBACK_MAX_AGE_MINUTES int
+
+	// when set, readings are refreshed in the background every POLL_INTERVAL_SECONDS and
+	// GetMetrics answers from them, pollers stop once not used for POLLER_IDLE_TIMEOUT_SECONDS.
+	// Set via metadata
+	POLL_INTERVAL_SECONDS       int
+	POLLER_IDLE_TIMEOUT_SECONDS int
+
+	// Azure cloud and credential shared by the Azure metrics and replica count readers, selected via metadata
+	AZURE_CLOUD      azureCredentials.Cloud
+	AZURE_CREDENTIAL *azureCredentials.CachedTokenCredential
 
 	// Azure setting to get rate_429_errors metrics
+	RATE_429_SOURCE            string
 	LOG_ANALYTICS_WORKSPACE_ID string
+	LOG_ANALYTICS_TABLE        string
+	OPENAI_RESOURCE_ID         string
+	OPENAI_DEPLOYMENT_NAME     string
 
 	MetricsReader      MetricsReader
 	ReplicaCountReader ReplicaCountReader
+
+	// receives the metrics pushed by workers for the otlp metrics backend, nil unless enabled
+	// with OTLP_RECEIVER_ENABLED
+	OTLPReceiver *metricsReaders.OTLPReceiver
+
+	// counts the 429 errors pushed by workers for the push error source, nil unless enabled with
+	// PUSH_RECEIVER_ENABLED
+	PushErrorCounter *metricsReaders.PushErrorCounter
+	// the namespace/name the push error source counts errors for, set via metadata
+	PUSH_SCALED_OBJECT string
 }
 
 func getEnvInt(key string, defaultValue int) int {
@@ -94,6 +257,57 @@ func getEnvInt(key string, defaultValue int) int {
 	return value
 }
 
+func getEnvBool(key string, defaultValue bool) bool {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		log.Printf("Failed to convert %s to bool: %v\n", key, err)
+		return defaultValue
+	}
+	return value
+}
+
+func getMetadataInt(metadata map[string]string, key string, defaultValue int) (int, error) {
+	valueStr := metadata[key]
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert %s to int: %v", key, err)
+	}
+	return value, nil
+}
+
+func getMetadataBool(metadata map[string]string, key string, defaultValue bool) (bool, error) {
+	valueStr := metadata[key]
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseBool(valueStr)
+	if err != nil {
+		return false, fmt.Errorf("failed to convert %s to bool: %v", key, err)
+	}
+	return value, nil
+}
+
+// getMetadataStrings parses a JSON array of strings, so values may contain commas and
+// surrounding whitespace
+func getMetadataStrings(metadata map[string]string, key string) ([]string, error) {
+	valueStr := metadata[key]
+	if valueStr == "" {
+		return nil, nil
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(valueStr), &values); err != nil {
+		return nil, fmt.Errorf("failed to convert %s to a JSON array of strings: %v", key, err)
+	}
+	return values, nil
+}
+
 func getEnvString(key string, defaultValue string) string {
 	value := os.Getenv(key)
 	if value == "" {
@@ -123,63 +337,105 @@ func (e *ExternalScaler) GetMetricSpec(context.Context, *pb.ScaledObjectRef) (*p
 	}, nil
 }
 
-func (e *ExternalScaler) ValidateSetRequiredMetadata(metadata map[string]string) error {
+func (e *ExternalScaler) ValidateSetRequiredMetadata(ctx context.Context, scaledObject *pb.ScaledObjectRef) error {
 
-	if e.RATE_429_ERRORS_METRIC_NAME == "" && metadata["rate429ErrorsMetricName"] == "" {
-		e.RATE_429_ERRORS_METRIC_NAME = "rate_429_errors"
-	}
-	if e.RATE_429_ERRORS_METRIC_NAME == "" && metadata["rate429ErrorsMetricName"] != "" {
-		fmt.Printf("Setting rate429ErrorsMetricName to %s\n", metadata["rate429ErrorsMetricName"])
-		e.RATE_429_ERRORS_METRIC_NAME = metadata["rate429ErrorsMetricName"]
-	}
+	metadata := scaledObject.ScalerMetadata
 
-	if e.METRICS_BACKEND == METRICS_BACKEND_PROMETHEUS {
-		if e.PROMETHEUS_ENDPOINT == "" && metadata["prometheusEndpoint"] == "" {
-			return fmt.Errorf("prometheusEndpoint is required for this configuration and not set")
-		}
-		if e.PROMETHEUS_ENDPOINT == "" && metadata["prometheusEndpoint"] != "" {
-			fmt.Printf("Setting prometheusEndpoint to %s\n", metadata["prometheusEndpoint"])
-			e.PROMETHEUS_ENDPOINT = metadata["prometheusEndpoint"]
+	// the metrics backend can be chosen per scaler through metadata, until the metrics reader is created
+	if e.MetricsReader == nil && metadata["metricsBackend"] != "" {
+		if err := validateMetricsBackend("metricsBackend", metadata["metricsBackend"]); err != nil {
+			return err
 		}
+		fmt.Printf("Setting metricsBackend to %s\n", metadata["metricsBackend"])
+		e.METRICS_BACKEND = metadata["metricsBackend"]
+	}
 
-		if e.MSG_QUEUE_LENGTH_METRIC_NAME == "" && metadata["msgQueueLengthMetricName"] == "" {
-			e.MSG_QUEUE_LENGTH_METRIC_NAME = "msg_queue_length"
+	// the queue length and the 429 errors can come from different backends, e.g. a service bus
+	// backlog with 429 errors in Prometheus
+	if e.QUEUE_SOURCE == "" && metadata[QUEUE_SOURCE_KEY] == "" {
+		e.QUEUE_SOURCE = e.METRICS_BACKEND
+	}
+	if e.QUEUE_SOURCE == "" && metadata[QUEUE_SOURCE_KEY] != "" {
+		if err := validateMetricsBackend(QUEUE_SOURCE_KEY, metadata[QUEUE_SOURCE_KEY]); err != nil {
+			return err
 		}
-		if e.MSG_QUEUE_LENGTH_METRIC_NAME == "" && metadata["msgQueueLengthMetricName"] != "" {
-			fmt.Printf("Setting msgQueueLengthMetricName to %s\n", metadata["msgQueueLengthMetricName"])
-			e.MSG_QUEUE_LENGTH_METRIC_NAME = metadata["msgQueueLengthMetricName"]
-			e.MetricsReader = metricsReaders.NewPrometheusMetricsReader(e.PROMETHEUS_ENDPOINT, e.MSG_QUEUE_LENGTH_METRIC_NAME, e.RATE_429_ERRORS_METRIC_NAME)
+		fmt.Printf("Setting queueSource to %s\n", metadata[QUEUE_SOURCE_KEY])
+		e.QUEUE_SOURCE = metadata[QUEUE_SOURCE_KEY]
+	}
+
+	if e.ERROR_SOURCE == "" && metadata[ERROR_SOURCE_KEY] == "" {
+		e.ERROR_SOURCE = e.METRICS_BACKEND
+	}
+	if e.ERROR_SOURCE == "" && metadata[ERROR_SOURCE_KEY] != "" {
+		// the queue backends have no 429 errors of their own
+		errorSource := metadata[ERROR_SOURCE_KEY]
+		switch errorSource {
+		case METRICS_BACKEND_PROMETHEUS, METRICS_BACKEND_AZURE, METRICS_BACKEND_HTTP, METRICS_BACKEND_SCRAPE, METRICS_BACKEND_OTLP, METRICS_BACKEND_PUSH:
+		default:
+			return fmt.Errorf("unsupported errorSource %q, supported sources are %s, %s, %s, %s, %s and %s", errorSource, METRICS_BACKEND_PROMETHEUS, METRICS_BACKEND_AZURE, METRICS_BACKEND_HTTP, METRICS_BACKEND_SCRAPE, METRICS_BACKEND_OTLP, METRICS_BACKEND_PUSH)
 		}
+		fmt.Printf("Setting errorSource to %s\n", errorSource)
+		e.ERROR_SOURCE = errorSource
+	}
+	errorMetadata := sourceMetadata(metadata, ERROR_SOURCE_KEY)
 
+	// errors are pushed for the scaled object itself unless pushScaledObject names another
+	if e.ERROR_SOURCE == METRICS_BACKEND_PUSH && e.PUSH_SCALED_OBJECT == "" && errorMetadata["pushScaledObject"] == "" {
+		e.PUSH_SCALED_OBJECT = scaledObject.Namespace + "/" + scaledObject.Name
+	}
+	if e.ERROR_SOURCE == METRICS_BACKEND_PUSH && e.PUSH_SCALED_OBJECT == "" && errorMetadata["pushScaledObject"] != "" {
+		fmt.Printf("Setting pushScaledObject to %s\n", errorMetadata["pushScaledObject"])
+		e.PUSH_SCALED_OBJECT = errorMetadata["pushScaledObject"]
 	}
 
-	if e.METRICS_BACKEND == METRICS_BACKEND_AZURE {
-		if e.LOG_ANALYTICS_WORKSPACE_ID == "" && metadata["logAnalyticsWorkspaceId"] == "" {
-			return fmt.Errorf("logAnalyticsWorkspaceId is required for this configuration and not set")
+	if e.RATE_429_ERRORS_METRIC_NAME == "" && errorMetadata["rate429ErrorsMetricName"] == "" {
+		e.RATE_429_ERRORS_METRIC_NAME = "rate_429_errors"
+	}
+	// the name is validated for the query language of the reader built for it, see
+	// validateRate429ErrorsMetricName
+	if e.RATE_429_ERRORS_METRIC_NAME == "" && errorMetadata["rate429ErrorsMetricName"] != "" {
+		fmt.Printf("Setting rate429ErrorsMetricName to %s\n", errorMetadata["rate429ErrorsMetricName"])
+		e.RATE_429_ERRORS_METRIC_NAME = errorMetadata["rate429ErrorsMetricName"]
+	}
+
+	if e.RATE_429_LOOKBACK_MINUTES == 0 {
+		rate429LookbackMinutes, err := getMetadataInt(metadata, "rate429LookbackMinutes", 1)
+		if err != nil {
+			return err
 		}
-		if e.LOG_ANALYTICS_WORKSPACE_ID == "" && metadata["logAnalyticsWorkspaceId"] != "" {
-			fmt.Printf("Setting logAnalyticsWorkspaceId to %s\n", metadata["logAnalyticsWorkspaceId"])
-			e.LOG_ANALYTICS_WORKSPACE_ID = metadata["logAnalyticsWorkspaceId"]
+		if rate429LookbackMinutes < 1 {
+			return fmt.Errorf("rate429LookbackMinutes must be at least 1, got %d", rate429LookbackMinutes)
 		}
+		e.RATE_429_LOOKBACK_MINUTES = rate429LookbackMinutes
+	}
 
-		if e.SERVICE_BUS_RESOURCE_ID == "" && metadata["serviceBusResourceId"] == "" {
-			return fmt.Errorf("serviceBusResourceId is required for this configuration and not set")
-		}
-		if e.SERVICE_BUS_RESOURCE_ID == "" && metadata["serviceBusResourceId"] != "" {
-			fmt.Println("Setting serviceBusResourceId")
-			e.SERVICE_BUS_RESOURCE_ID = metadata["serviceBusResourceId"]
+	// queries are rendered once, when the metrics reader is created
+	if e.MetricsReader == nil {
+		if err := e.setUserDefinedQueries(ctx, scaledObject); err != nil {
+			return err
 		}
+	}
 
-		if e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME == "" && metadata["serviceBusQueueOrTopicName"] == "" {
-			return fmt.Errorf("serviceBusQueueOrTopicName is required for this configuration and not set")
+	if e.AZURE_CREDENTIAL == nil && (e.QUEUE_SOURCE == METRICS_BACKEND_AZURE || e.ERROR_SOURCE == METRICS_BACKEND_AZURE || e.INSTANCE_COMPUTE_BACKEND == INSTANCE_COMPUTE_BACKEND_CONTAINER_APPS) {
+		cloud, err := getAzureCloud(metadata)
+		if err != nil {
+			return err
 		}
-		if e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME == "" && metadata["serviceBusQueueOrTopicName"] != "" {
-			fmt.Printf("Setting serviceBusQueueOrTopicName to %s\n", metadata["serviceBusQueueOrTopicName"])
-			e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME = metadata["serviceBusQueueOrTopicName"]
-			e.SERVICE_BUS_TOPIC_SUBSCRIPTION_NAME = metadata["serviceBusTopicSubscriptionName"]
-			e.MetricsReader = metricsReaders.NewAzureMetricsReader(e.SERVICE_BUS_RESOURCE_ID, e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME, e.SERVICE_BUS_TOPIC_SUBSCRIPTION_NAME, e.RATE_429_ERRORS_METRIC_NAME, e.LOG_ANALYTICS_WORKSPACE_ID)
+		e.AZURE_CLOUD = cloud
+
+		credential, err := getAzureCredential(metadata, cloud)
+		if err != nil {
+			return err
 		}
+		e.AZURE_CREDENTIAL = credential
+	}
 
+	if e.QUEUE_SOURCE == e.ERROR_SOURCE {
+		if err := e.setSourceMetricsReader(e.QUEUE_SOURCE, metadata); err != nil {
+			return err
+		}
+	} else if err := e.setComposedMetricsReader(metadata); err != nil {
+		return err
 	}
 
 	if e.INSTANCE_COMPUTE_BACKEND == INSTANCE_COMPUTE_BACKEND_KUBERNETES {
@@ -228,12 +484,108 @@ func (e *ExternalScaler) ValidateSetRequiredMetadata(metadata map[string]string)
 			//
 
 			fmt.Printf("Setting Instance compute backend to containerApps")
-			e.ReplicaCountReader = replicaCountReaders.NewContainerAppReplicaCountReader(e.AZURE_SUBSCRIPTION_ID, e.RESOURCE_GROUP, e.CONTAINER_APP)
+			e.ReplicaCountReader = replicaCountReaders.NewContainerAppReplicaCountReader(e.AZURE_SUBSCRIPTION_ID, e.RESOURCE_GROUP, e.CONTAINER_APP, e.AZURE_CREDENTIAL, e.AZURE_CLOUD.Configuration(), nil)
 			//
 
 		}
 	}
 
+	if e.DEAD_LETTER_GROWTH_THRESHOLD == 0 && metadata["deadLetterGrowthThreshold"] != "" {
+		deadLetterGrowthThreshold, err := getMetadataInt(metadata, "deadLetterGrowthThreshold", 0)
+		if err != nil {
+			return err
+		}
+		if deadLetterGrowthThreshold < 0 {
+			return fmt.Errorf("deadLetterGrowthThreshold must not be negative, got %d", deadLetterGrowthThreshold)
+		}
+		if _, ok := getDeadLetterReader(e.MetricsReader); deadLetterGrowthThreshold > 0 && !ok {
+			return fmt.Errorf("deadLetterGrowthThreshold is not supported by the %s metrics backend for this queue, only service bus queues and topic subscriptions have a dead letter count", e.QUEUE_SOURCE)
+		}
+		fmt.Printf("Setting deadLetterGrowthThreshold to %d\n", deadLetterGrowthThreshold)
+		e.DEAD_LETTER_GROWTH_THRESHOLD = deadLetterGrowthThreshold
+	}
+
+	for _, timeout := range []struct {
+		key     string
+		setting *int
+	}{
+		{"replicaCountTimeoutSeconds", &e.REPLICA_COUNT_TIMEOUT_SECONDS},
+		{"queueLengthTimeoutSeconds", &e.QUEUE_LENGTH_TIMEOUT_SECONDS},
+		{"rate429ErrorsTimeoutSeconds", &e.RATE_429_ERRORS_TIMEOUT_SECONDS},
+	} {
+		if *timeout.setting == 0 {
+			timeoutSeconds, err := getMetadataInt(metadata, timeout.key, DEFAULT_READ_TIMEOUT_SECONDS)
+			if err != nil {
+				return err
+			}
+			if timeoutSeconds < 1 {
+				return fmt.Errorf("%s must be at least 1, got %d", timeout.key, timeoutSeconds)
+			}
+			*timeout.setting = timeoutSeconds
+		}
+	}
+
+	if e.RETRY_POLICY.MaxAttempts == 0 {
+		retryMaxAttempts, err := getMetadataInt(metadata, "retryMaxAttempts", metricsReaders.DEFAULT_RETRY_MAX_ATTEMPTS)
+		if err != nil {
+			return err
+		}
+		if retryMaxAttempts < 1 {
+			return fmt.Errorf("retryMaxAttempts must be at least 1, got %d", retryMaxAttempts)
+		}
+		e.RETRY_POLICY = metricsReaders.RetryPolicy{
+			MaxAttempts: retryMaxAttempts,
+			BaseDelay:   metricsReaders.DEFAULT_RETRY_BASE_DELAY,
+			MaxDelay:    metricsReaders.DEFAULT_RETRY_MAX_DELAY,
+		}
+	}
+
+	// failing by default keeps a broken backend visible, last known values are opt in
+	if e.FALLBACK_POLICY == "" && metadata["fallbackPolicy"] == "" {
+		e.FALLBACK_POLICY = FALLBACK_POLICY_FAIL
+	}
+	if e.FALLBACK_POLICY == "" && metadata["fallbackPolicy"] != "" {
+		fallbackPolicy := metadata["fallbackPolicy"]
+		if fallbackPolicy != FALLBACK_POLICY_FAIL && fallbackPolicy != FALLBACK_POLICY_LAST_KNOWN {
+			return fmt.Errorf("unsupported fallbackPolicy %q, supported policies are %s and %s", fallbackPolicy, FALLBACK_POLICY_FAIL, FALLBACK_POLICY_LAST_KNOWN)
+		}
+		fmt.Printf("Setting fallbackPolicy to %s\n", fallbackPolicy)
+		e.FALLBACK_POLICY = fallbackPolicy
+	}
+
+	if e.FALLBACK_MAX_AGE_MINUTES == 0 {
+		fallbackMaxAgeMinutes, err := getMetadataInt(metadata, "fallbackMaxAgeMinutes", 5)
+		if err != nil {
+			return err
+		}
+		if fallbackMaxAgeMinutes < 1 {
+			return fmt.Errorf("fallbackMaxAgeMinutes must be at least 1, got %d", fallbackMaxAgeMinutes)
+		}
+		e.FALLBACK_MAX_AGE_MINUTES = fallbackMaxAgeMinutes
+	}
+
+	if e.POLLER_IDLE_TIMEOUT_SECONDS == 0 {
+		pollIntervalSeconds, err := getMetadataInt(metadata, "pollIntervalSeconds", 0)
+		if err != nil {
+			return err
+		}
+		if pollIntervalSeconds < 0 {
+			return fmt.Errorf("pollIntervalSeconds must not be negative, got %d", pollIntervalSeconds)
+		}
+		pollerIdleTimeoutSeconds, err := getMetadataInt(metadata, "pollerIdleTimeoutSeconds", DEFAULT_POLLER_IDLE_TIMEOUT_SECONDS)
+		if err != nil {
+			return err
+		}
+		if pollerIdleTimeoutSeconds < 1 {
+			return fmt.Errorf("pollerIdleTimeoutSeconds must be at least 1, got %d", pollerIdleTimeoutSeconds)
+		}
+		if pollIntervalSeconds > 0 {
+			fmt.Printf("Setting pollIntervalSeconds to %d\n", pollIntervalSeconds)
+		}
+		e.POLL_INTERVAL_SECONDS = pollIntervalSeconds
+		e.POLLER_IDLE_TIMEOUT_SECONDS = pollerIdleTimeoutSeconds
+	}
+
 	if e.MIN_REPLICAS == 0 && metadata["minReplicas"] == "" {
 		return fmt.Errorf("minReplicas is required for this configuration and not set")
 	}
@@ -262,41 +614,1092 @@ func (e *ExternalScaler) ValidateSetRequiredMetadata(metadata map[string]string)
 
 }
 
-func (e *ExternalScaler) GetMetrics(_ context.Context, metricRequest *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+// setSourceMetricsReader validates and sets the metadata of a metrics backend, and creates its
+// metrics reader when there is none yet
+func (e *ExternalScaler) setSourceMetricsReader(backend string, metadata map[string]string) error {
+	if backend == METRICS_BACKEND_PROMETHEUS {
+		if e.PROMETHEUS_ENDPOINT == "" && metadata["prometheusEndpoint"] == "" {
+			return fmt.Errorf("prometheusEndpoint is required for this configuration and not set")
+		}
+		if e.PROMETHEUS_ENDPOINT == "" && metadata["prometheusEndpoint"] != "" {
+			fmt.Printf("Setting prometheusEndpoint to %s\n", metadata["prometheusEndpoint"])
+			e.PROMETHEUS_ENDPOINT = metadata["prometheusEndpoint"]
+		}
 
-	slog.Info("GetMetrics called")
+		if e.MSG_QUEUE_LENGTH_METRIC_NAME == "" && metadata["msgQueueLengthMetricName"] == "" {
+			e.MSG_QUEUE_LENGTH_METRIC_NAME = "msg_queue_length"
+		}
+		if e.MSG_QUEUE_LENGTH_METRIC_NAME == "" && metadata["msgQueueLengthMetricName"] != "" {
+			if err := metricsReaders.ValidatePrometheusMetricName("msgQueueLengthMetricName", metadata["msgQueueLengthMetricName"], e.ALLOWED_METRIC_NAMES); err != nil {
+				return err
+			}
+			fmt.Printf("Setting msgQueueLengthMetricName to %s\n", metadata["msgQueueLengthMetricName"])
+			e.MSG_QUEUE_LENGTH_METRIC_NAME = metadata["msgQueueLengthMetricName"]
+		}
 
-	// Validate the metadata and set the required configurations
-	if err := e.ValidateSetRequiredMetadata(metricRequest.ScaledObjectRef.ScalerMetadata); err != nil {
-		slog.Error(fmt.Sprintf("Failed to validate metadata: %v\n", err))
+		if e.MetricsReader == nil {
+			// a queue source composed with another error source doesn't read the 429 errors
+			if backend == e.ERROR_SOURCE {
+				if err := e.validateRate429ErrorsMetricName(metadata, metricsReaders.ValidatePrometheusMetricName); err != nil {
+					return err
+				}
+			}
+			e.MetricsReader = metricsReaders.NewPrometheusMetricsReader(metricsReaders.PrometheusMetricsReaderConfig{
+				PrometheusEndpoint:       e.PROMETHEUS_ENDPOINT,
+				MsgQueueLengthMetricName: e.MSG_QUEUE_LENGTH_METRIC_NAME,
+				Rate429ErrorsMetricName:  e.RATE_429_ERRORS_METRIC_NAME,
+				QueueLengthQuery:         e.QUEUE_LENGTH_QUERY,
+				ErrorRateQuery:           e.ERROR_RATE_QUERY,
+			})
+		}
+
+	}
+
+	if backend == METRICS_BACKEND_RABBITMQ {
+		if e.QUEUE_LENGTH_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery is not supported by the %s metrics backend", METRICS_BACKEND_RABBITMQ)
+		}
+
+		if e.RABBITMQ_MANAGEMENT_ENDPOINT == "" && metadata["rabbitMQManagementEndpoint"] == "" {
+			return fmt.Errorf("rabbitMQManagementEndpoint is required for this configuration and not set")
+		}
+		if e.RABBITMQ_MANAGEMENT_ENDPOINT == "" && metadata["rabbitMQManagementEndpoint"] != "" {
+			fmt.Println("Setting rabbitMQManagementEndpoint")
+			e.RABBITMQ_MANAGEMENT_ENDPOINT = metadata["rabbitMQManagementEndpoint"]
+		}
+
+		if e.RABBITMQ_VHOST == "" && metadata["rabbitMQVHost"] == "" {
+			e.RABBITMQ_VHOST = metricsReaders.DEFAULT_RABBITMQ_VHOST
+		}
+		if e.RABBITMQ_VHOST == "" && metadata["rabbitMQVHost"] != "" {
+			fmt.Printf("Setting rabbitMQVHost to %s\n", metadata["rabbitMQVHost"])
+			e.RABBITMQ_VHOST = metadata["rabbitMQVHost"]
+		}
+
+		if e.RABBITMQ_QUEUE_NAME == "" && metadata["rabbitMQQueueName"] == "" {
+			return fmt.Errorf("rabbitMQQueueName is required for this configuration and not set")
+		}
+		if e.RABBITMQ_QUEUE_NAME == "" && metadata["rabbitMQQueueName"] != "" {
+			fmt.Printf("Setting rabbitMQQueueName to %s\n", metadata["rabbitMQQueueName"])
+			e.RABBITMQ_QUEUE_NAME = metadata["rabbitMQQueueName"]
+		}
+
+		if e.MetricsReader == nil {
+			includeUnacknowledged, err := getMetadataBool(metadata, "rabbitMQIncludeUnacknowledged", false)
+			if err != nil {
+				return err
+			}
+			username := metadata["rabbitMQUsername"]
+			password := metadata["rabbitMQPassword"]
+			if username == "" {
+				username = os.Getenv("RABBITMQ_USERNAME")
+				password = os.Getenv("RABBITMQ_PASSWORD")
+			}
+
+			queueLengthReader, err := metricsReaders.NewRabbitMQMetricsReader(metricsReaders.RabbitMQMetricsReaderConfig{
+				ManagementEndpoint:    e.RABBITMQ_MANAGEMENT_ENDPOINT,
+				VHost:                 e.RABBITMQ_VHOST,
+				QueueName:             e.RABBITMQ_QUEUE_NAME,
+				Username:              username,
+				Password:              password,
+				IncludeUnacknowledged: includeUnacknowledged,
+			})
+			if err != nil {
+				return err
+			}
+
+			rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+			if err != nil {
+				return err
+			}
+			e.MetricsReader = metricsReaders.NewSplitMetricsReader(queueLengthReader, rate429ErrorsReader)
+		}
+	}
+
+	if backend == METRICS_BACKEND_KAFKA {
+		if e.QUEUE_LENGTH_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery is not supported by the %s metrics backend", METRICS_BACKEND_KAFKA)
+		}
+
+		if e.KAFKA_BOOTSTRAP_SERVERS == nil && metadata["kafkaBootstrapServers"] == "" {
+			return fmt.Errorf("kafkaBootstrapServers is required for this configuration and not set")
+		}
+		if e.KAFKA_BOOTSTRAP_SERVERS == nil && metadata["kafkaBootstrapServers"] != "" {
+			fmt.Printf("Setting kafkaBootstrapServers to %s\n", metadata["kafkaBootstrapServers"])
+			for _, server := range strings.Split(metadata["kafkaBootstrapServers"], ",") {
+				if server = strings.TrimSpace(server); server != "" {
+					e.KAFKA_BOOTSTRAP_SERVERS = append(e.KAFKA_BOOTSTRAP_SERVERS, server)
+				}
+			}
+		}
+
+		if e.KAFKA_TOPIC == "" && metadata["kafkaTopic"] == "" {
+			return fmt.Errorf("kafkaTopic is required for this configuration and not set")
+		}
+		if e.KAFKA_TOPIC == "" && metadata["kafkaTopic"] != "" {
+			fmt.Printf("Setting kafkaTopic to %s\n", metadata["kafkaTopic"])
+			e.KAFKA_TOPIC = metadata["kafkaTopic"]
+		}
+
+		if e.KAFKA_CONSUMER_GROUP == "" && metadata["kafkaConsumerGroup"] == "" {
+			return fmt.Errorf("kafkaConsumerGroup is required for this configuration and not set")
+		}
+		if e.KAFKA_CONSUMER_GROUP == "" && metadata["kafkaConsumerGroup"] != "" {
+			fmt.Printf("Setting kafkaConsumerGroup to %s\n", metadata["kafkaConsumerGroup"])
+			e.KAFKA_CONSUMER_GROUP = metadata["kafkaConsumerGroup"]
+		}
+
+		if e.MetricsReader == nil {
+			tlsConfig, err := getTLSConfig(metadata, "kafka")
+			if err != nil {
+				return err
+			}
+			username := metadata["kafkaUsername"]
+			password := metadata["kafkaPassword"]
+			if username == "" {
+				username = os.Getenv("KAFKA_USERNAME")
+				password = os.Getenv("KAFKA_PASSWORD")
+			}
+
+			queueLengthReader, err := metricsReaders.NewKafkaMetricsReader(metricsReaders.KafkaMetricsReaderConfig{
+				BootstrapServers:  e.KAFKA_BOOTSTRAP_SERVERS,
+				Topic:             e.KAFKA_TOPIC,
+				ConsumerGroup:     e.KAFKA_CONSUMER_GROUP,
+				LagAggregation:    metadata["kafkaLagAggregation"],
+				OffsetResetPolicy: metadata["kafkaOffsetResetPolicy"],
+				SASLMechanism:     metadata["kafkaSaslMechanism"],
+				Username:          username,
+				Password:          password,
+				TLSConfig:         tlsConfig,
+			})
+			if err != nil {
+				return err
+			}
+
+			rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+			if err != nil {
+				return err
+			}
+			e.MetricsReader = metricsReaders.NewSplitMetricsReader(queueLengthReader, rate429ErrorsReader)
+		}
+	}
+
+	if backend == METRICS_BACKEND_REDIS {
+		if e.QUEUE_LENGTH_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery is not supported by the %s metrics backend", METRICS_BACKEND_REDIS)
+		}
+
+		if e.REDIS_ADDRESS == "" && metadata["redisAddress"] == "" {
+			return fmt.Errorf("redisAddress is required for this configuration and not set")
+		}
+		if e.REDIS_ADDRESS == "" && metadata["redisAddress"] != "" {
+			fmt.Printf("Setting redisAddress to %s\n", metadata["redisAddress"])
+			e.REDIS_ADDRESS = metadata["redisAddress"]
+		}
+
+		if e.REDIS_KEY == "" && metadata["redisKey"] == "" {
+			return fmt.Errorf("redisKey is required for this configuration and not set")
+		}
+		if e.REDIS_KEY == "" && metadata["redisKey"] != "" {
+			fmt.Printf("Setting redisKey to %s\n", metadata["redisKey"])
+			e.REDIS_KEY = metadata["redisKey"]
+		}
+
+		if e.REDIS_KEY_TYPE == "" && metadata["redisKeyType"] == "" {
+			e.REDIS_KEY_TYPE = metricsReaders.REDIS_KEY_TYPE_LIST
+		}
+		if e.REDIS_KEY_TYPE == "" && metadata["redisKeyType"] != "" {
+			fmt.Printf("Setting redisKeyType to %s\n", metadata["redisKeyType"])
+			e.REDIS_KEY_TYPE = metadata["redisKeyType"]
+		}
+
+		if e.REDIS_CONSUMER_GROUP == "" && metadata["redisConsumerGroup"] != "" {
+			fmt.Printf("Setting redisConsumerGroup to %s\n", metadata["redisConsumerGroup"])
+			e.REDIS_CONSUMER_GROUP = metadata["redisConsumerGroup"]
+		}
+
+		if e.MetricsReader == nil {
+			db, err := getMetadataInt(metadata, "redisDB", 0)
+			if err != nil {
+				return err
+			}
+			tlsConfig, err := getTLSConfig(metadata, "redis")
+			if err != nil {
+				return err
+			}
+			username := metadata["redisUsername"]
+			password := metadata["redisPassword"]
+			if username == "" && password == "" {
+				username = os.Getenv("REDIS_USERNAME")
+				password = os.Getenv("REDIS_PASSWORD")
+			}
+
+			queueLengthReader, err := metricsReaders.NewRedisMetricsReader(metricsReaders.RedisMetricsReaderConfig{
+				Address:       e.REDIS_ADDRESS,
+				Username:      username,
+				Password:      password,
+				DB:            db,
+				TLSConfig:     tlsConfig,
+				Key:           e.REDIS_KEY,
+				KeyType:       e.REDIS_KEY_TYPE,
+				ConsumerGroup: e.REDIS_CONSUMER_GROUP,
+			})
+			if err != nil {
+				return err
+			}
+
+			rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+			if err != nil {
+				return err
+			}
+			e.MetricsReader = metricsReaders.NewSplitMetricsReader(queueLengthReader, rate429ErrorsReader)
+		}
+	}
+
+	if backend == METRICS_BACKEND_SQS {
+		if e.QUEUE_LENGTH_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery is not supported by the %s metrics backend", METRICS_BACKEND_SQS)
+		}
+
+		if e.SQS_QUEUE_URL == "" && metadata["sqsQueueUrl"] == "" {
+			return fmt.Errorf("sqsQueueUrl is required for this configuration and not set")
+		}
+		if e.SQS_QUEUE_URL == "" && metadata["sqsQueueUrl"] != "" {
+			fmt.Printf("Setting sqsQueueUrl to %s\n", metadata["sqsQueueUrl"])
+			e.SQS_QUEUE_URL = metadata["sqsQueueUrl"]
+		}
+
+		if e.AWS_REGION == "" && metadata["awsRegion"] != "" {
+			fmt.Printf("Setting awsRegion to %s\n", metadata["awsRegion"])
+			e.AWS_REGION = metadata["awsRegion"]
+		}
+
+		if e.MetricsReader == nil {
+			includeInFlight, err := getMetadataBool(metadata, "sqsIncludeInFlight", false)
+			if err != nil {
+				return err
+			}
+			includeDelayed, err := getMetadataBool(metadata, "sqsIncludeDelayed", false)
+			if err != nil {
+				return err
+			}
+
+			queueLengthReader, err := metricsReaders.NewSQSMetricsReader(metricsReaders.SQSMetricsReaderConfig{
+				QueueURL:        e.SQS_QUEUE_URL,
+				Region:          e.AWS_REGION,
+				Endpoint:        metadata["sqsEndpoint"],
+				AccessKeyID:     metadata["awsAccessKeyId"],
+				SecretAccessKey: metadata["awsSecretAccessKey"],
+				SessionToken:    metadata["awsSessionToken"],
+				IncludeInFlight: includeInFlight,
+				IncludeDelayed:  includeDelayed,
+			})
+			if err != nil {
+				return err
+			}
+
+			rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+			if err != nil {
+				return err
+			}
+			e.MetricsReader = metricsReaders.NewSplitMetricsReader(queueLengthReader, rate429ErrorsReader)
+		}
+	}
+
+	if backend == METRICS_BACKEND_NATS {
+		if e.QUEUE_LENGTH_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery is not supported by the %s metrics backend", METRICS_BACKEND_NATS)
+		}
+
+		if e.NATS_URL == "" && metadata["natsUrl"] == "" {
+			return fmt.Errorf("natsUrl is required for this configuration and not set")
+		}
+		if e.NATS_URL == "" && metadata["natsUrl"] != "" {
+			fmt.Println("Setting natsUrl")
+			e.NATS_URL = metadata["natsUrl"]
+		}
+
+		if e.NATS_STREAM == "" && metadata["natsStream"] == "" {
+			return fmt.Errorf("natsStream is required for this configuration and not set")
+		}
+		if e.NATS_STREAM == "" && metadata["natsStream"] != "" {
+			fmt.Printf("Setting natsStream to %s\n", metadata["natsStream"])
+			e.NATS_STREAM = metadata["natsStream"]
+		}
+
+		if e.NATS_CONSUMER == "" && metadata["natsConsumer"] == "" {
+			return fmt.Errorf("natsConsumer is required for this configuration and not set")
+		}
+		if e.NATS_CONSUMER == "" && metadata["natsConsumer"] != "" {
+			fmt.Printf("Setting natsConsumer to %s\n", metadata["natsConsumer"])
+			e.NATS_CONSUMER = metadata["natsConsumer"]
+		}
+
+		if e.MetricsReader == nil {
+			tlsConfig, err := getTLSConfig(metadata, "nats")
+			if err != nil {
+				return err
+			}
+			username := metadata["natsUsername"]
+			password := metadata["natsPassword"]
+			if username == "" {
+				username = os.Getenv("NATS_USERNAME")
+				password = os.Getenv("NATS_PASSWORD")
+			}
+			token := metadata["natsToken"]
+			if token == "" {
+				token = os.Getenv("NATS_TOKEN")
+			}
+
+			queueLengthReader, err := metricsReaders.NewNATSMetricsReader(metricsReaders.NATSMetricsReaderConfig{
+				URL:             e.NATS_URL,
+				Domain:          metadata["natsDomain"],
+				Stream:          e.NATS_STREAM,
+				Consumer:        e.NATS_CONSUMER,
+				Username:        username,
+				Password:        password,
+				Token:           token,
+				CredentialsFile: metadata["natsCredentialsFile"],
+				TLSConfig:       tlsConfig,
+			})
+			if err != nil {
+				return err
+			}
+
+			rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+			if err != nil {
+				return err
+			}
+			e.MetricsReader = metricsReaders.NewSplitMetricsReader(queueLengthReader, rate429ErrorsReader)
+		}
+	}
+
+	if backend == METRICS_BACKEND_POSTGRES {
+		// a user defined queueLengthQuery replaces the count over postgresTable, its parameters
+		// are set with postgresQueryParameters
+		if e.QUEUE_LENGTH_QUERY != "" {
+			if err := metricsReaders.ValidateSQLQuery(metricsReaders.QUEUE_LENGTH_QUERY_KEY, e.QUEUE_LENGTH_QUERY); err != nil {
+				return err
+			}
+		}
+
+		if e.QUEUE_LENGTH_QUERY == "" && e.POSTGRES_TABLE == "" && metadata["postgresTable"] == "" {
+			return fmt.Errorf("postgresTable is required for this configuration and not set")
+		}
+		if e.QUEUE_LENGTH_QUERY == "" && e.POSTGRES_TABLE == "" && metadata["postgresTable"] != "" {
+			if err := metricsReaders.ValidateSQLIdentifier("postgresTable", metadata["postgresTable"]); err != nil {
+				return err
+			}
+			fmt.Printf("Setting postgresTable to %s\n", metadata["postgresTable"])
+			e.POSTGRES_TABLE = metadata["postgresTable"]
+		}
+
+		if e.QUEUE_LENGTH_QUERY == "" && e.POSTGRES_STATUS_COLUMN == "" && metadata["postgresStatusColumn"] == "" {
+			e.POSTGRES_STATUS_COLUMN = "status"
+		}
+		if e.QUEUE_LENGTH_QUERY == "" && e.POSTGRES_STATUS_COLUMN == "" && metadata["postgresStatusColumn"] != "" {
+			if err := metricsReaders.ValidateSQLIdentifier("postgresStatusColumn", metadata["postgresStatusColumn"]); err != nil {
+				return err
+			}
+			fmt.Printf("Setting postgresStatusColumn to %s\n", metadata["postgresStatusColumn"])
+			e.POSTGRES_STATUS_COLUMN = metadata["postgresStatusColumn"]
+		}
+
+		if e.QUEUE_LENGTH_QUERY == "" && e.POSTGRES_PENDING_STATUS == "" && metadata["postgresPendingStatus"] == "" {
+			e.POSTGRES_PENDING_STATUS = "pending"
+		}
+		if e.QUEUE_LENGTH_QUERY == "" && e.POSTGRES_PENDING_STATUS == "" && metadata["postgresPendingStatus"] != "" {
+			fmt.Printf("Setting postgresPendingStatus to %s\n", metadata["postgresPendingStatus"])
+			e.POSTGRES_PENDING_STATUS = metadata["postgresPendingStatus"]
+		}
+
+		if e.MetricsReader == nil {
+			connectionString := metadata["postgresConnectionString"]
+			if connectionString == "" {
+				connectionString = os.Getenv("POSTGRES_CONNECTION_STRING")
+			}
+			if connectionString == "" {
+				return fmt.Errorf("postgresConnectionString is required for this configuration and not set")
+			}
+			queryTimeoutSeconds, err := getMetadataInt(metadata, "postgresQueryTimeoutSeconds", int(metricsReaders.DEFAULT_SQL_QUERY_TIMEOUT/time.Second))
+			if err != nil {
+				return err
+			}
+			maxConnections, err := getMetadataInt(metadata, "postgresMaxConnections", metricsReaders.DEFAULT_SQL_MAX_OPEN_CONNS)
+			if err != nil {
+				return err
+			}
+
+			query := e.QUEUE_LENGTH_QUERY
+			args := []any{}
+			if query == "" {
+				query = metricsReaders.SQLCountQuery(e.POSTGRES_TABLE, e.POSTGRES_STATUS_COLUMN)
+				args = append(args, e.POSTGRES_PENDING_STATUS)
+			} else {
+				parameters, err := getMetadataStrings(metadata, "postgresQueryParameters")
+				if err != nil {
+					return err
+				}
+				for _, parameter := range parameters {
+					args = append(args, parameter)
+				}
+			}
+
+			queueLengthReader, err := metricsReaders.NewSQLMetricsReader(metricsReaders.SQLMetricsReaderConfig{
+				DriverName:     metricsReaders.DEFAULT_SQL_DRIVER_NAME,
+				DataSourceName: connectionString,
+				Query:          query,
+				Args:           args,
+				QueryTimeout:   time.Duration(queryTimeoutSeconds) * time.Second,
+				MaxOpenConns:   maxConnections,
+			})
+			if err != nil {
+				return err
+			}
+
+			rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+			if err != nil {
+				return err
+			}
+			e.MetricsReader = metricsReaders.NewSplitMetricsReader(queueLengthReader, rate429ErrorsReader)
+		}
+	}
+
+	if backend == METRICS_BACKEND_HTTP {
+		if e.QUEUE_LENGTH_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery is not supported by the %s metrics backend", METRICS_BACKEND_HTTP)
+		}
+
+		if e.HTTP_URL == "" && metadata["httpUrl"] == "" {
+			return fmt.Errorf("httpUrl is required for this configuration and not set")
+		}
+		if e.HTTP_URL == "" && metadata["httpUrl"] != "" {
+			fmt.Println("Setting httpUrl")
+			e.HTTP_URL = metadata["httpUrl"]
+		}
+
+		if e.MetricsReader == nil {
+			// the same endpoint can serve the queue length, the 429 errors or both. Without
+			// httpRate429ErrorsPath the 429 errors of the queue source are read from prometheusEndpoint
+			if e.QUEUE_SOURCE == METRICS_BACKEND_HTTP && metadata["httpQueueLengthPath"] == "" {
+				return fmt.Errorf("httpQueueLengthPath is required for this configuration and not set")
+			}
+			if e.QUEUE_SOURCE != METRICS_BACKEND_HTTP && metadata["httpRate429ErrorsPath"] == "" {
+				return fmt.Errorf("httpRate429ErrorsPath is required for this configuration and not set")
+			}
+			headers, err := metricsReaders.ParseHTTPHeaders(metadata["httpHeaders"])
+			if err != nil {
+				return err
+			}
+			bearerToken := metadata["httpBearerToken"]
+			if bearerToken == "" {
+				bearerToken = os.Getenv("HTTP_BEARER_TOKEN")
+			}
+			if bearerToken != "" {
+				headers.Set("Authorization", "Bearer "+bearerToken)
+			}
+			timeoutSeconds, err := getMetadataInt(metadata, "httpTimeoutSeconds", int(metricsReaders.DEFAULT_HTTP_JSON_TIMEOUT/time.Second))
+			if err != nil {
+				return err
+			}
+			maxResponseBytes, err := getMetadataInt(metadata, "httpMaxResponseBytes", metricsReaders.DEFAULT_HTTP_JSON_MAX_RESPONSE_BYTES)
+			if err != nil {
+				return err
+			}
+
+			reader, err := metricsReaders.NewHTTPJSONMetricsReader(metricsReaders.HTTPJSONMetricsReaderConfig{
+				URL:               e.HTTP_URL,
+				Method:            metadata["httpMethod"],
+				Body:              metadata["httpBody"],
+				Headers:           headers,
+				QueueLengthPath:   metadata["httpQueueLengthPath"],
+				Rate429ErrorsPath: metadata["httpRate429ErrorsPath"],
+				Timeout:           time.Duration(timeoutSeconds) * time.Second,
+				MaxResponseBytes:  int64(maxResponseBytes),
+			})
+			if err != nil {
+				return err
+			}
+
+			if metadata["httpRate429ErrorsPath"] != "" {
+				e.MetricsReader = reader
+			} else {
+				rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+				if err != nil {
+					return err
+				}
+				e.MetricsReader = metricsReaders.NewSplitMetricsReader(reader, rate429ErrorsReader)
+			}
+		}
+	}
+
+	// the scrape backend reads the same metrics as the prometheus backend from the workload's
+	// pods, without a Prometheus server
+	if backend == METRICS_BACKEND_SCRAPE {
+		if e.QUEUE_LENGTH_QUERY != "" || e.ERROR_RATE_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery and errorRateQuery are not supported by the %s metrics backend", METRICS_BACKEND_SCRAPE)
+		}
+
+		if e.MSG_QUEUE_LENGTH_METRIC_NAME == "" && metadata["msgQueueLengthMetricName"] == "" {
+			e.MSG_QUEUE_LENGTH_METRIC_NAME = "msg_queue_length"
+		}
+		if e.MSG_QUEUE_LENGTH_METRIC_NAME == "" && metadata["msgQueueLengthMetricName"] != "" {
+			if err := metricsReaders.ValidatePrometheusMetricName("msgQueueLengthMetricName", metadata["msgQueueLengthMetricName"], e.ALLOWED_METRIC_NAMES); err != nil {
+				return err
+			}
+			fmt.Printf("Setting msgQueueLengthMetricName to %s\n", metadata["msgQueueLengthMetricName"])
+			e.MSG_QUEUE_LENGTH_METRIC_NAME = metadata["msgQueueLengthMetricName"]
+		}
+
+		if e.MetricsReader == nil {
+			// the scraped pods are the scaled deployment's
+			if metadata["deploymentName"] == "" || metadata["deploymentNamespace"] == "" {
+				return fmt.Errorf("deploymentName and deploymentNamespace are required for the %s metrics backend and not set", METRICS_BACKEND_SCRAPE)
+			}
+			port, err := getMetadataInt(metadata, "scrapePort", 0)
+			if err != nil {
+				return err
+			}
+			timeoutSeconds, err := getMetadataInt(metadata, "scrapeTimeoutSeconds", int(metricsReaders.DEFAULT_SCRAPE_TIMEOUT/time.Second))
+			if err != nil {
+				return err
+			}
+			if backend == e.ERROR_SOURCE {
+				if err := e.validateRate429ErrorsMetricName(metadata, metricsReaders.ValidatePrometheusMetricName); err != nil {
+					return err
+				}
+			}
+
+			reader, err := metricsReaders.NewPodScrapeMetricsReader(metricsReaders.PodScrapeMetricsReaderConfig{
+				Namespace:                metadata["deploymentNamespace"],
+				Deployment:               metadata["deploymentName"],
+				Port:                     port,
+				Path:                     metadata["scrapePath"],
+				MsgQueueLengthMetricName: e.MSG_QUEUE_LENGTH_METRIC_NAME,
+				Rate429ErrorsMetricName:  e.RATE_429_ERRORS_METRIC_NAME,
+				Reducer:                  metadata["scrapeReducer"],
+				Timeout:                  time.Duration(timeoutSeconds) * time.Second,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Setting scrape targets to the pods of deployment %s/%s\n", metadata["deploymentNamespace"], metadata["deploymentName"])
+			e.MetricsReader = reader
+		}
+	}
+
+	// the otlp backend reads the metrics workers push to the scaler's OTLP receiver
+	if backend == METRICS_BACKEND_OTLP {
+		if e.QUEUE_LENGTH_QUERY != "" || e.ERROR_RATE_QUERY != "" {
+			return fmt.Errorf("queueLengthQuery and errorRateQuery are not supported by the %s metrics backend", METRICS_BACKEND_OTLP)
+		}
+
+		if e.MetricsReader == nil {
+			// without otlpRate429ErrorsMetric the 429 errors of the queue source are read from
+			// prometheusEndpoint
+			if e.QUEUE_SOURCE == METRICS_BACKEND_OTLP && metadata["otlpQueueLengthMetric"] == "" {
+				return fmt.Errorf("otlpQueueLengthMetric is required for this configuration and not set")
+			}
+			if e.QUEUE_SOURCE != METRICS_BACKEND_OTLP && metadata["otlpRate429ErrorsMetric"] == "" {
+				return fmt.Errorf("otlpRate429ErrorsMetric is required for this configuration and not set")
+			}
+			queueLengthAttributes, err := metricsReaders.ParseOTLPAttributes(metadata["otlpQueueLengthAttributes"])
+			if err != nil {
+				return err
+			}
+			rate429ErrorsAttributes, err := metricsReaders.ParseOTLPAttributes(metadata["otlpRate429ErrorsAttributes"])
+			if err != nil {
+				return err
+			}
+
+			reader, err := metricsReaders.NewOTLPMetricsReader(metricsReaders.OTLPMetricsReaderConfig{
+				Receiver:                e.OTLPReceiver,
+				QueueLengthMetric:       metadata["otlpQueueLengthMetric"],
+				QueueLengthAttributes:   queueLengthAttributes,
+				Rate429ErrorsMetric:     metadata["otlpRate429ErrorsMetric"],
+				Rate429ErrorsAttributes: rate429ErrorsAttributes,
+				Window:                  time.Duration(e.RATE_429_LOOKBACK_MINUTES) * time.Minute,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Setting otlp metrics to %q %v and %q %v\n", metadata["otlpQueueLengthMetric"], queueLengthAttributes, metadata["otlpRate429ErrorsMetric"], rate429ErrorsAttributes)
+
+			if metadata["otlpRate429ErrorsMetric"] != "" {
+				e.MetricsReader = reader
+			} else {
+				rate429ErrorsReader, err := e.getRate429ErrorsReader(metadata)
+				if err != nil {
+					return err
+				}
+				e.MetricsReader = metricsReaders.NewSplitMetricsReader(reader, rate429ErrorsReader)
+			}
+		}
+	}
+
+	// the push error source counts the 429 errors workers push to the scaler's push receiver
+	if backend == METRICS_BACKEND_PUSH {
+		if e.ERROR_RATE_QUERY != "" {
+			return fmt.Errorf("errorRateQuery is not supported by the %s error source", METRICS_BACKEND_PUSH)
+		}
+
+		if e.MetricsReader == nil {
+			status, err := getMetadataInt(metadata, "pushStatus", metricsReaders.DEFAULT_PUSH_STATUS)
+			if err != nil {
+				return err
+			}
+			reader, err := metricsReaders.NewPushMetricsReader(metricsReaders.PushMetricsReaderConfig{
+				Counter:      e.PushErrorCounter,
+				ScaledObject: e.PUSH_SCALED_OBJECT,
+				Status:       status,
+				Window:       time.Duration(e.RATE_429_LOOKBACK_MINUTES) * time.Minute,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Setting pushed errors to status %d of %s\n", status, e.PUSH_SCALED_OBJECT)
+			e.MetricsReader = reader
+		}
+	}
+
+	if backend == METRICS_BACKEND_AZURE {
+		if e.RATE_429_SOURCE == "" && metadata["rate429Source"] == "" {
+			e.RATE_429_SOURCE = metricsReaders.RATE_429_SOURCE_LOG_ANALYTICS
+		}
+		if e.RATE_429_SOURCE == "" && metadata["rate429Source"] != "" {
+			source := metadata["rate429Source"]
+			if source != metricsReaders.RATE_429_SOURCE_LOG_ANALYTICS && source != metricsReaders.RATE_429_SOURCE_AZURE_OPENAI {
+				return fmt.Errorf("unsupported rate429Source %q, supported sources are %s and %s", source, metricsReaders.RATE_429_SOURCE_LOG_ANALYTICS, metricsReaders.RATE_429_SOURCE_AZURE_OPENAI)
+			}
+			fmt.Printf("Setting rate429Source to %s\n", source)
+			e.RATE_429_SOURCE = source
+		}
+
+		if e.RATE_429_SOURCE == metricsReaders.RATE_429_SOURCE_AZURE_OPENAI {
+			if e.OPENAI_RESOURCE_ID == "" && metadata["openAIResourceId"] == "" {
+				return fmt.Errorf("openAIResourceId is required for the %s rate429Source and not set", metricsReaders.RATE_429_SOURCE_AZURE_OPENAI)
+			}
+			if e.OPENAI_RESOURCE_ID == "" && metadata["openAIResourceId"] != "" {
+				if err := metricsReaders.ValidateAzureOpenAIResourceID("openAIResourceId", metadata["openAIResourceId"]); err != nil {
+					return err
+				}
+				fmt.Println("Setting openAIResourceId")
+				e.OPENAI_RESOURCE_ID = metadata["openAIResourceId"]
+
+				if metadata["openAIDeploymentName"] != "" {
+					if err := metricsReaders.ValidateAzureOpenAIDeploymentName("openAIDeploymentName", metadata["openAIDeploymentName"]); err != nil {
+						return err
+					}
+					fmt.Printf("Setting openAIDeploymentName to %s\n", metadata["openAIDeploymentName"])
+					e.OPENAI_DEPLOYMENT_NAME = metadata["openAIDeploymentName"]
+				}
+			}
+		}
+
+		// the workspace is only needed for KQL queries
+		if (e.ERROR_SOURCE == METRICS_BACKEND_AZURE && e.RATE_429_SOURCE == metricsReaders.RATE_429_SOURCE_LOG_ANALYTICS) || e.QUEUE_LENGTH_QUERY != "" || e.ERROR_RATE_QUERY != "" {
+			if e.LOG_ANALYTICS_WORKSPACE_ID == "" && metadata["logAnalyticsWorkspaceId"] == "" {
+				return fmt.Errorf("logAnalyticsWorkspaceId is required for this configuration and not set")
+			}
+			if e.LOG_ANALYTICS_WORKSPACE_ID == "" && metadata["logAnalyticsWorkspaceId"] != "" {
+				fmt.Printf("Setting logAnalyticsWorkspaceId to %s\n", metadata["logAnalyticsWorkspaceId"])
+				e.LOG_ANALYTICS_WORKSPACE_ID = metadata["logAnalyticsWorkspaceId"]
+			}
+		}
+
+		if e.LOG_ANALYTICS_TABLE == "" && metadata["logAnalyticsTable"] == "" {
+			e.LOG_ANALYTICS_TABLE = metricsReaders.DEFAULT_LOG_ANALYTICS_TABLE
+		}
+		if e.LOG_ANALYTICS_TABLE == "" && metadata["logAnalyticsTable"] != "" {
+			if err := metricsReaders.ValidateKQLTableName("logAnalyticsTable", metadata["logAnalyticsTable"], e.ALLOWED_LOG_ANALYTICS_TABLES); err != nil {
+				return err
+			}
+			fmt.Printf("Setting logAnalyticsTable to %s\n", metadata["logAnalyticsTable"])
+			e.LOG_ANALYTICS_TABLE = metadata["logAnalyticsTable"]
+		}
+
+		// the queue length comes from a storage queue instead of service bus when storageQueueName is set
+		if e.QUEUE_SOURCE == METRICS_BACKEND_AZURE && e.QUEUE_LENGTH_QUERY == "" && e.STORAGE_QUEUE_NAME == "" && metadata["storageQueueName"] != "" {
+			if metadata["storageAccountName"] == "" && metadata["storageQueueEndpoint"] == "" {
+				return fmt.Errorf("storageAccountName or storageQueueEndpoint is required for this configuration and not set")
+			}
+			fmt.Printf("Setting storageQueueName to %s (account: %s, endpoint: %s)\n", metadata["storageQueueName"], metadata["storageAccountName"], metadata["storageQueueEndpoint"])
+			e.STORAGE_QUEUE_NAME = metadata["storageQueueName"]
+			e.STORAGE_ACCOUNT_NAME = metadata["storageAccountName"]
+			e.STORAGE_QUEUE_ENDPOINT = metadata["storageQueueEndpoint"]
+		}
+
+		// the service bus settings are not needed when the queue length comes from a user defined query,
+		// a storage queue or another queueSource
+		if e.QUEUE_SOURCE == METRICS_BACKEND_AZURE && e.QUEUE_LENGTH_QUERY == "" && e.STORAGE_QUEUE_NAME == "" {
+			if e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME == "" && metadata["serviceBusQueueOrTopicName"] == "" {
+				return fmt.Errorf("serviceBusQueueOrTopicName is required for this configuration and not set")
+			}
+			if e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME == "" && metadata["serviceBusQueueOrTopicName"] != "" {
+				fmt.Printf("Setting serviceBusQueueOrTopicName to %s\n", metadata["serviceBusQueueOrTopicName"])
+				e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME = metadata["serviceBusQueueOrTopicName"]
+				e.SERVICE_BUS_TOPIC_SUBSCRIPTION_NAME = metadata["serviceBusTopicSubscriptionName"]
+			}
+
+			if e.SERVICE_BUS_QUEUE_LENGTH_SOURCE == "" && metadata["serviceBusQueueLengthSource"] == "" {
+				e.SERVICE_BUS_QUEUE_LENGTH_SOURCE = metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_ARM
+			}
+			if e.SERVICE_BUS_QUEUE_LENGTH_SOURCE == "" && metadata["serviceBusQueueLengthSource"] != "" {
+				source := metadata["serviceBusQueueLengthSource"]
+				if source != metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_ARM && source != metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_AZURE_MONITOR && source != metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE {
+					return fmt.Errorf("unsupported serviceBusQueueLengthSource %q, supported sources are %s, %s and %s", source, metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_ARM, metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_AZURE_MONITOR, metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE)
+				}
+				if source == metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_AZURE_MONITOR && e.SERVICE_BUS_TOPIC_SUBSCRIPTION_NAME != "" {
+					slog.Warn("Azure Monitor has no subscription dimension, the queue length will be the topic's message count across all subscriptions")
+				}
+				fmt.Printf("Setting serviceBusQueueLengthSource to %s\n", source)
+				e.SERVICE_BUS_QUEUE_LENGTH_SOURCE = source
+			}
+
+			// the data plane is reached with a SAS connection string instead of through ARM
+			if e.SERVICE_BUS_QUEUE_LENGTH_SOURCE == metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE {
+				if e.SERVICE_BUS_CONNECTION_STRING == nil {
+					connectionString := metadata["serviceBusConnectionString"]
+					if connectionString == "" {
+						connectionString = os.Getenv("SERVICE_BUS_CONNECTION_STRING")
+					}
+					if connectionString == "" {
+						return fmt.Errorf("serviceBusConnectionString or the SERVICE_BUS_CONNECTION_STRING environment variable is required for the %s serviceBusQueueLengthSource and not set", metricsReaders.SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE)
+					}
+					serviceBusConnectionString, err := metricsReaders.ParseServiceBusConnectionString(connectionString)
+					if err != nil {
+						return err
+					}
+					fmt.Printf("Setting service bus connection string for %s\n", serviceBusConnectionString.Endpoint)
+					e.SERVICE_BUS_CONNECTION_STRING = &serviceBusConnectionString
+				}
+			} else {
+				if e.SERVICE_BUS_RESOURCE_ID == "" && metadata["serviceBusResourceId"] == "" {
+					return fmt.Errorf("serviceBusResourceId is required for this configuration and not set")
+				}
+				if e.SERVICE_BUS_RESOURCE_ID == "" && metadata["serviceBusResourceId"] != "" {
+					fmt.Println("Setting serviceBusResourceId")
+					e.SERVICE_BUS_RESOURCE_ID = metadata["serviceBusResourceId"]
+				}
+			}
+
+			if e.SERVICE_BUS_BACKLOG_COUNTS == nil && metadata["serviceBusBacklogCounts"] == "" {
+				e.SERVICE_BUS_BACKLOG_COUNTS = metricsReaders.DEFAULT_SERVICE_BUS_BACKLOG_COUNTS
+			}
+			if e.SERVICE_BUS_BACKLOG_COUNTS == nil && metadata["serviceBusBacklogCounts"] != "" {
+				backlogCounts, err := metricsReaders.ParseServiceBusBacklogCounts(metadata["serviceBusBacklogCounts"])
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Setting serviceBusBacklogCounts to %v\n", backlogCounts)
+				e.SERVICE_BUS_BACKLOG_COUNTS = backlogCounts
+			}
+		}
+
+		if e.MetricsReader == nil {
+			// the name is quoted as a KQL string literal
+			if backend == e.ERROR_SOURCE {
+				if err := e.validateRate429ErrorsMetricName(metadata, metricsReaders.ValidateAppInsightsMetricName); err != nil {
+					return err
+				}
+			}
+			rate429IngestionOffsetMinutes, err := getMetadataInt(metadata, "rate429IngestionOffsetMinutes", 0)
+			if err != nil {
+				return err
+			}
+			if rate429IngestionOffsetMinutes < 0 {
+				return fmt.Errorf("rate429IngestionOffsetMinutes must not be negative, got %d", rate429IngestionOffsetMinutes)
+			}
+			detectIngestionLag, err := getMetadataBool(metadata, "rate429DetectIngestionLag", false)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Setting rate429 lookback window to %d minutes ending %d minutes ago (detect ingestion lag: %t)\n", e.RATE_429_LOOKBACK_MINUTES, rate429IngestionOffsetMinutes, detectIngestionLag)
+
+			// storage queue requests are authorized with the account key or a SAS token when one
+			// is set, and otherwise with the Azure credential
+			storageAccountKey := metadata["storageAccountKey"]
+			if storageAccountKey == "" {
+				storageAccountKey = os.Getenv("STORAGE_ACCOUNT_KEY")
+			}
+			storageSASToken := metadata["storageSasToken"]
+			if storageSASToken == "" {
+				storageSASToken = os.Getenv("STORAGE_SAS_TOKEN")
+			}
+
+			e.MetricsReader = metricsReaders.NewAzureMetricsReader(metricsReaders.AzureMetricsReaderConfig{
+				ServiceBusResourceID:            e.SERVICE_BUS_RESOURCE_ID,
+				ServiceBusQueueOrTopicName:      e.SERVICE_BUS_QUEUE_OR_TOPIC_NAME,
+				ServiceBusTopicSubscriptionName: e.SERVICE_BUS_TOPIC_SUBSCRIPTION_NAME,
+				ServiceBusQueueLengthSource:     e.SERVICE_BUS_QUEUE_LENGTH_SOURCE,
+				ServiceBusConnectionString:      e.SERVICE_BUS_CONNECTION_STRING,
+				ServiceBusBacklogCounts:         e.SERVICE_BUS_BACKLOG_COUNTS,
+				StorageQueueName:                e.STORAGE_QUEUE_NAME,
+				StorageAccountName:              e.STORAGE_ACCOUNT_NAME,
+				StorageQueueEndpoint:            e.STORAGE_QUEUE_ENDPOINT,
+				StorageAccountKey:               storageAccountKey,
+				StorageSASToken:                 storageSASToken,
+				Error429MetricName:              e.RATE_429_ERRORS_METRIC_NAME,
+				LogAnalyticsWorkspaceID:         e.LOG_ANALYTICS_WORKSPACE_ID,
+				Rate429Source:                   e.RATE_429_SOURCE,
+				OpenAIResourceID:                e.OPENAI_RESOURCE_ID,
+				OpenAIDeploymentName:            e.OPENAI_DEPLOYMENT_NAME,
+				Credential:                      e.AZURE_CREDENTIAL,
+				Cloud:                           e.AZURE_CLOUD,
+				LogAnalyticsTable:               e.LOG_ANALYTICS_TABLE,
+				Rate429LookbackWindow:           time.Duration(e.RATE_429_LOOKBACK_MINUTES) * time.Minute,
+				Rate429IngestionOffset:          time.Duration(rate429IngestionOffsetMinutes) * time.Minute,
+				DetectIngestionLag:              detectIngestionLag,
+				QueueLengthQuery:                e.QUEUE_LENGTH_QUERY,
+				ErrorRateQuery:                  e.ERROR_RATE_QUERY,
+			})
+		}
+
+	}
+
+	return nil
+}
+
+// setComposedMetricsReader reads the queue length from the queueSource and the 429 errors from
+// the errorSource. Each source is set with its own metadata block, see sourceMetadata, and only
+// sees its own user defined query
+func (e *ExternalScaler) setComposedMetricsReader(metadata map[string]string) error {
+	queueLengthQuery, errorRateQuery := e.QUEUE_LENGTH_QUERY, e.ERROR_RATE_QUERY
+	defer func() {
+		e.QUEUE_LENGTH_QUERY, e.ERROR_RATE_QUERY = queueLengthQuery, errorRateQuery
+	}()
+	// once composed, the sources only validate their metadata
+	composed := e.MetricsReader != nil
+
+	e.QUEUE_LENGTH_QUERY, e.ERROR_RATE_QUERY = queueLengthQuery, ""
+	if err := e.setSourceMetricsReader(e.QUEUE_SOURCE, sourceMetadata(metadata, QUEUE_SOURCE_KEY)); err != nil {
+		return err
+	}
+	queueLengthReader := e.MetricsReader
+	if !composed {
+		e.MetricsReader = nil
+	}
+
+	e.QUEUE_LENGTH_QUERY, e.ERROR_RATE_QUERY = "", errorRateQuery
+	if err := e.setSourceMetricsReader(e.ERROR_SOURCE, sourceMetadata(metadata, ERROR_SOURCE_KEY)); err != nil {
+		return err
+	}
+
+	if !composed {
+		fmt.Printf("Reading the queue length from %s and rate_429_errors from %s\n", e.QUEUE_SOURCE, e.ERROR_SOURCE)
+		e.MetricsReader = metricsReaders.NewSplitMetricsReader(queueLengthReader, e.MetricsReader)
+	}
+	return nil
+}
+
+// sourceMetadata returns the metadata of a queue or error source, keys in the source's block,
+// prefixed with "<source>.", e.g. "errorSource.prometheusEndpoint", take precedence over the
+// same keys without a prefix
+func sourceMetadata(metadata map[string]string, source string) map[string]string {
+	prefix := source + "."
+	sourceMetadata := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		if !strings.HasPrefix(key, prefix) {
+			sourceMetadata[key] = value
+		}
+	}
+	for key, value := range metadata {
+		if strings.HasPrefix(key, prefix) {
+			sourceMetadata[strings.TrimPrefix(key, prefix)] = value
+		}
+	}
+	return sourceMetadata
+}
+
+// getRate429ErrorsReader returns the Prometheus reader for the 429 errors of queue backends
+// without error metrics of their own, or nil when no prometheusEndpoint is set, in which case
+// the 429 error rate is 0
+func (e *ExternalScaler) getRate429ErrorsReader(metadata map[string]string) (metricsReaders.Rate429ErrorsReader, error) {
+	// the 429 errors are read from the errorSource instead, see setComposedMetricsReader
+	if e.ERROR_SOURCE != e.QUEUE_SOURCE {
+		return nil, nil
+	}
+	if e.PROMETHEUS_ENDPOINT == "" && metadata["prometheusEndpoint"] != "" {
+		fmt.Printf("Setting prometheusEndpoint to %s\n", metadata["prometheusEndpoint"])
+		e.PROMETHEUS_ENDPOINT = metadata["prometheusEndpoint"]
+	}
+	if e.PROMETHEUS_ENDPOINT == "" {
+		slog.Warn(fmt.Sprintf("prometheusEndpoint not set, rate_429_errors will be 0 for the %s metrics backend", e.QUEUE_SOURCE))
+		return nil, nil
+	}
+	if err := e.validateRate429ErrorsMetricName(metadata, metricsReaders.ValidatePrometheusMetricName); err != nil {
 		return nil, err
 	}
 
-	replicas, err := e.ReplicaCountReader.GetInstanceCount()
+	return metricsReaders.NewPrometheusMetricsReader(metricsReaders.PrometheusMetricsReaderConfig{
+		PrometheusEndpoint:      e.PROMETHEUS_ENDPOINT,
+		Rate429ErrorsMetricName: e.RATE_429_ERRORS_METRIC_NAME,
+		ErrorRateQuery:          e.ERROR_RATE_QUERY,
+	}), nil
+}
+
+// validateMetricsBackend returns an error when backend, set via the metadata key, is not a
+// supported metrics backend
+func validateMetricsBackend(key string, backend string) error {
+	switch backend {
+	case METRICS_BACKEND_PROMETHEUS, METRICS_BACKEND_AZURE, METRICS_BACKEND_RABBITMQ, METRICS_BACKEND_KAFKA, METRICS_BACKEND_REDIS, METRICS_BACKEND_SQS, METRICS_BACKEND_NATS, METRICS_BACKEND_POSTGRES, METRICS_BACKEND_HTTP, METRICS_BACKEND_SCRAPE, METRICS_BACKEND_OTLP:
+		return nil
+	}
+	return fmt.Errorf("unsupported %s %q, supported backends are %s, %s, %s, %s, %s, %s, %s, %s, %s, %s and %s", key, backend, METRICS_BACKEND_PROMETHEUS, METRICS_BACKEND_AZURE, METRICS_BACKEND_RABBITMQ, METRICS_BACKEND_KAFKA, METRICS_BACKEND_REDIS, METRICS_BACKEND_SQS, METRICS_BACKEND_NATS, METRICS_BACKEND_POSTGRES, METRICS_BACKEND_HTTP, METRICS_BACKEND_SCRAPE, METRICS_BACKEND_OTLP)
+}
+
+// getTLSConfig returns the TLS config for a queue backend when the <prefix>TLS metadata is true,
+// trusting the PEM certificates in <prefix>CACert in addition to the system roots, or nil for
+// plaintext
+func getTLSConfig(metadata map[string]string, prefix string) (*tls.Config, error) {
+	enableTLS, err := getMetadataBool(metadata, prefix+"TLS", false)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to get deployment instance count: %v\n", err))
 		return nil, err
 	}
+	if !enableTLS {
+		if metadata[prefix+"CACert"] != "" {
+			return nil, fmt.Errorf("%sCACert is set but %sTLS is not true", prefix, prefix)
+		}
+		return nil, nil
+	}
 
-	slog.Debug(fmt.Sprintf("number of current workload replicas: %d\n", replicas))
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if metadata[prefix+"CACert"] != "" {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM([]byte(metadata[prefix+"CACert"])) {
+			return nil, fmt.Errorf("%sCACert does not contain a PEM certificate", prefix)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	return tlsConfig, nil
+}
 
-	rate429Errors, err := e.MetricsReader.GetRate429Errors()
+// getAzureCloud returns the cloud named by the azureCloud metadata, with endpoints and audiences
+// overridden by metadata
+func getAzureCloud(metadata map[string]string) (azureCredentials.Cloud, error) {
+	cloud, err := azureCredentials.GetCloud(metadata["azureCloud"], azureCredentials.Cloud{
+		ActiveDirectoryAuthorityHost: metadata["azureActiveDirectoryAuthorityHost"],
+		ResourceManagerEndpoint:      metadata["azureResourceManagerEndpoint"],
+		ResourceManagerAudience:      metadata["azureResourceManagerAudience"],
+		LogAnalyticsEndpoint:         metadata["logAnalyticsEndpoint"],
+		LogAnalyticsAudience:         metadata["logAnalyticsAudience"],
+		StorageEndpointSuffix:        metadata["azureStorageEndpointSuffix"],
+		StorageAudience:              metadata["azureStorageAudience"],
+	})
 	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to get rate_429_errors: %v\n", err))
-		return nil, err
+		return azureCredentials.Cloud{}, err
+	}
+	fmt.Printf("Setting azureCloud to %s (resource manager: %s, log analytics: %s)\n", cloud.Name, cloud.ResourceManagerEndpoint, cloud.LogAnalyticsEndpoint)
+
+	return cloud, nil
+}
+
+// getAzureCredential returns the shared credential selected by the azureCredentialType,
+// azureClientId, azureTenantId and azureClientSecret metadata
+func getAzureCredential(metadata map[string]string, cloud azureCredentials.Cloud) (*azureCredentials.CachedTokenCredential, error) {
+	options := azureCredentials.Options{
+		CredentialType: metadata["azureCredentialType"],
+		ClientID:       metadata["azureClientId"],
+		TenantID:       metadata["azureTenantId"],
+		ClientSecret:   metadata["azureClientSecret"],
+		AuthorityHost:  cloud.ActiveDirectoryAuthorityHost,
+	}
+	if options.CredentialType == "" {
+		options.CredentialType = azureCredentials.CREDENTIAL_TYPE_DEFAULT
+	}
+	fmt.Printf("Setting azureCredentialType to %s\n", options.CredentialType)
+
+	return azureCredentials.GetCredential(options)
+}
+
+// validateRate429ErrorsMetricName validates the rate429ErrorsMetricName set via metadata with
+// validate, the validation of the query language of the reader it is built into. The default
+// name is valid in every query language
+func (e *ExternalScaler) validateRate429ErrorsMetricName(metadata map[string]string, validate func(key string, name string, allowlist metricsReaders.Allowlist) error) error {
+	if sourceMetadata(metadata, ERROR_SOURCE_KEY)["rate429ErrorsMetricName"] == "" {
+		return nil
+	}
+	return validate("rate429ErrorsMetricName", e.RATE_429_ERRORS_METRIC_NAME, e.ALLOWED_METRIC_NAMES)
+}
+
+// setUserDefinedQueries renders the queueLengthQuery and errorRateQuery set in metadata, or in
+// the ConfigMap referenced by queryConfigMap, in the ScaledObject's namespace. Queries set in
+// metadata take precedence
+func (e *ExternalScaler) setUserDefinedQueries(ctx context.Context, scaledObject *pb.ScaledObjectRef) error {
+	metadata := scaledObject.ScalerMetadata
+
+	queueLengthQuery := metadata[metricsReaders.QUEUE_LENGTH_QUERY_KEY]
+	errorRateQuery := metadata[metricsReaders.ERROR_RATE_QUERY_KEY]
+
+	if queueLengthQuery == "" && errorRateQuery == "" && metadata["queryConfigMap"] == "" {
+		return nil
+	}
+
+	// user defined queries can read anything the scaler's identity can, so they have to be
+	// enabled on the scaler side, before any ConfigMap is read for them
+	if !e.ALLOW_USER_DEFINED_QUERIES {
+		return &metricsReaders.MetadataRejectedError{Key: "queueLengthQuery/errorRateQuery", Value: scaledObject.Namespace + "/" + scaledObject.Name, Reason: "user defined queries are not enabled on the scaler, set ALLOW_USER_DEFINED_QUERIES to true"}
+	}
+
+	if metadata["queryConfigMap"] != "" {
+		fmt.Printf("Reading queries from config map %s/%s\n", scaledObject.Namespace, metadata["queryConfigMap"])
+		configMapQueueLengthQuery, configMapErrorRateQuery, err := metricsReaders.GetQueriesFromConfigMap(ctx, scaledObject.Namespace, metadata["queryConfigMap"])
+		if err != nil {
+			return err
+		}
+		if queueLengthQuery == "" {
+			queueLengthQuery = configMapQueueLengthQuery
+		}
+		if errorRateQuery == "" {
+			errorRateQuery = configMapErrorRateQuery
+		}
 	}
 
-	slog.Debug(fmt.Sprintf("rate_429_errors: %d\n", rate429Errors))
+	if queueLengthQuery == "" && errorRateQuery == "" {
+		return nil
+	}
 
-	msgQueueLength, err := e.MetricsReader.GetQueueLength()
+	templateData, err := metricsReaders.NewQueryTemplateData(scaledObject.Namespace, scaledObject.Name, time.Duration(e.RATE_429_LOOKBACK_MINUTES)*time.Minute)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Failed to get msg_queue_length: %v\n", err))
-		return nil, err
+		return err
 	}
 
-	slog.Debug(fmt.Sprintf("msg_queue_length: %d\n", msgQueueLength))
+	if queueLengthQuery != "" {
+		e.QUEUE_LENGTH_QUERY, err = metricsReaders.RenderQuery(queueLengthQuery, templateData)
+		if err != nil {
+			return fmt.Errorf("invalid queueLengthQuery: %w", err)
+		}
+		fmt.Printf("Setting queueLengthQuery to %s\n", e.QUEUE_LENGTH_QUERY)
+	}
+	if errorRateQuery != "" {
+		e.ERROR_RATE_QUERY, err = metricsReaders.RenderQuery(errorRateQuery, templateData)
+		if err != nil {
+			return fmt.Errorf("invalid errorRateQuery: %w", err)
+		}
+		fmt.Printf("Setting errorRateQuery to %s\n", e.ERROR_RATE_QUERY)
+	}
+
+	return nil
+}
 
-	revisedMetricValue := e.getRevisedMetricValue(msgQueueLength, rate429Errors, replicas, e.MIN_REPLICAS, e.MAX_REPLICAS, time.Since(e.lastScaleDownRequestTime))
+func (e *ExternalScaler) GetMetrics(ctx context.Context, metricRequest *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+
+	slog.Info("GetMetrics called")
+
+	// Validate the metadata and set the required configurations
+	if err := e.ValidateSetRequiredMetadata(ctx, metricRequest.ScaledObjectRef); err != nil {
+		slog.Error(fmt.Sprintf("Failed to validate metadata: %v\n", err))
+		return nil, grpcError(err, codes.InvalidArgument)
+	}
+
+	revisedMetricValue, err := e.getMetricValue(ctx, metricRequest.ScaledObjectRef)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to get the metric value: %v", err))
+		return nil, grpcError(err, codes.Unknown)
+	}
 
 	slog.Debug(fmt.Sprintf("GetMetrics, returning revisedMetricValue: %d\n", revisedMetricValue))
 	return &pb.GetMetricsResponse{
@@ -307,6 +1710,311 @@ func (e *ExternalScaler) GetMetrics(_ context.Context, metricRequest *pb.GetMetr
 	}, nil
 }
 
+// grpcError returns err with the gRPC status code of its class, so KEDA logs tell a mistyped
+// queue name from a backend outage. Errors of unknown class get unknownCode
+func grpcError(err error, unknownCode codes.Code) error {
+	code := unknownCode
+	switch class, _ := metricsReaders.ClassifyError(err); class {
+	case metricsReaders.ERROR_CLASS_NOT_FOUND:
+		code = codes.NotFound
+	case metricsReaders.ERROR_CLASS_INVALID:
+		code = codes.InvalidArgument
+	case metricsReaders.ERROR_CLASS_UNAUTHENTICATED:
+		code = codes.Unauthenticated
+	case metricsReaders.ERROR_CLASS_PERMISSION_DENIED:
+		code = codes.PermissionDenied
+	case metricsReaders.ERROR_CLASS_TRANSIENT:
+		code = codes.Unavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			code = codes.DeadlineExceeded
+		}
+	}
+	return status.Error(code, err.Error())
+}
+
+// metricRead is the result of one of the reads of GetMetrics
+type metricRead struct {
+	name  string
+	value int
+	err   error
+}
+
+// lastDeadLetterCounts keeps the last dead letter count of each scaled object, by namespace/name
+type lastDeadLetterCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// growth returns how much the dead letter count of key grew since the previous call, 0 on the
+// first call
+func (l *lastDeadLetterCounts) growth(key string, count int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts == nil {
+		l.counts = map[string]int{}
+	}
+	lastCount, ok := l.counts[key]
+	l.counts[key] = count
+	if !ok {
+		return 0
+	}
+	return count - lastCount
+}
+
+// lastKnownMetricReads keeps the last successful value of each read with the time it was read
+type lastKnownMetricReads struct {
+	mu     sync.Mutex
+	values map[string]int
+	readAt map[string]time.Time
+}
+
+// readWithTimeout runs read within timeout, retrying transient errors with RETRY_POLICY, and
+// returns when ctx is done even if the reader does not honour it
+func (e *ExternalScaler) readWithTimeout(ctx context.Context, name string, timeout time.Duration, read func(ctx context.Context) (int, error)) metricRead {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	result := make(chan metricRead, 1)
+	go func() {
+		value, err := e.RETRY_POLICY.Do(ctx, read)
+		result <- metricRead{name: name, value: value, err: err}
+	}()
+
+	select {
+	case r := <-result:
+		return r
+	case <-ctx.Done():
+		return metricRead{name: name, err: ctx.Err()}
+	}
+}
+
+// metricReadings are the reads of a metric request, the dead letter count is only read with
+// a dead letter reader and a DEAD_LETTER_GROWTH_THRESHOLD
+type metricReadings struct {
+	replicas        metricRead
+	rate429Errors   metricRead
+	msgQueueLength  metricRead
+	deadLetterCount metricRead
+	readDeadLetters bool
+	// growth of the dead letter count since the poller's previous successful dead letter read,
+	// set by pollers only
+	deadLetterGrowth int
+	readAt           time.Time
+}
+
+// readMetrics reads the replica count, the 429 errors and the queue length concurrently under
+// ctx, each within its own timeout
+func (e *ExternalScaler) readMetrics(ctx context.Context) metricReadings {
+	var readings metricReadings
+	deadLetterReader, readDeadLetters := getDeadLetterReader(e.MetricsReader)
+	readings.readDeadLetters = readDeadLetters && e.DEAD_LETTER_GROWTH_THRESHOLD > 0
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		readings.replicas = e.readWithTimeout(ctx, "deployment instance count", time.Duration(e.REPLICA_COUNT_TIMEOUT_SECONDS)*time.Second, e.ReplicaCountReader.GetInstanceCount)
+	}()
+	go func() {
+		defer wg.Done()
+		readings.rate429Errors = e.readWithTimeout(ctx, "rate_429_errors", time.Duration(e.RATE_429_ERRORS_TIMEOUT_SECONDS)*time.Second, e.MetricsReader.GetRate429Errors)
+	}()
+	go func() {
+		defer wg.Done()
+		queueLengthTimeout := time.Duration(e.QUEUE_LENGTH_TIMEOUT_SECONDS) * time.Second
+		readings.msgQueueLength = e.readWithTimeout(ctx, "msg_queue_length", queueLengthTimeout, e.MetricsReader.GetQueueLength)
+		// read after the queue length, which can read the dead letter count along with it
+		if readings.readDeadLetters {
+			readings.deadLetterCount = e.readWithTimeout(ctx, "dead_letter_count", queueLengthTimeout, deadLetterReader.GetDeadLetterCount)
+		}
+	}()
+	wg.Wait()
+
+	readings.readAt = time.Now()
+	return readings
+}
+
+// getMetricValue gets the readings of the scaled object, read now or by its poller, and
+// calculates the metric value. Failed reads are passed to the fallback policy
+func (e *ExternalScaler) getMetricValue(ctx context.Context, scaledObject *pb.ScaledObjectRef) (int, error) {
+	key := scaledObject.Namespace + "/" + scaledObject.Name
+	var readings metricReadings
+	if e.POLL_INTERVAL_SECONDS == 0 {
+		readings = e.readMetrics(ctx)
+	} else {
+		var err error
+		readings, err = e.getPoller().get(ctx)
+		if err != nil {
+			return 0, err
+		}
+		// the age is only logged, KEDA reports every MetricValue under the requested metric
+		// name, so a second value would be added to the metric
+		slog.Info(fmt.Sprintf("Using the readings polled %v ago for %s/%s", time.Since(readings.readAt).Round(time.Millisecond), scaledObject.Namespace, scaledObject.Name))
+	}
+
+	replicas, rate429Errors, msgQueueLength := readings.replicas, readings.rate429Errors, readings.msgQueueLength
+	if err := errors.Join(e.fallback(&replicas, readings.readAt), e.fallback(&rate429Errors, readings.readAt), e.fallback(&msgQueueLength, readings.readAt)); err != nil {
+		return 0, err
+	}
+
+	slog.Debug(fmt.Sprintf("number of current workload replicas: %d\n", replicas.value))
+	slog.Debug(fmt.Sprintf("rate_429_errors: %d\n", rate429Errors.value))
+	slog.Debug(fmt.Sprintf("msg_queue_length: %d\n", msgQueueLength.value))
+
+	revisedMetricValue := e.getRevisedMetricValue(msgQueueLength.value, rate429Errors.value, replicas.value, e.MIN_REPLICAS, e.MAX_REPLICAS, time.Since(e.lastScaleDownRequestTime))
+
+	if readings.readDeadLetters {
+		deadLetterCount := readings.deadLetterCount
+		if err := e.fallback(&deadLetterCount, readings.readAt); err != nil {
+			if e.FALLBACK_POLICY == FALLBACK_POLICY_FAIL {
+				return 0, err
+			}
+			// the dead letter check only ever holds back scale up, so it is skipped rather
+			// than failing the metric
+			slog.Warn(fmt.Sprintf("Skipping the dead letter growth check: %v", err))
+			return revisedMetricValue, nil
+		}
+
+		slog.Debug(fmt.Sprintf("dead_letter_count: %d", deadLetterCount.value))
+
+		// polled readings are shared by the requests of a poll interval, so their growth is
+		// between polls rather than between requests
+		deadLetterGrowth := readings.deadLetterGrowth
+		if e.POLL_INTERVAL_SECONDS == 0 {
+			deadLetterGrowth = e.lastDeadLetterCounts.growth(key, deadLetterCount.value)
+		}
+		revisedMetricValue = e.pauseScaleUpOnDeadLetterGrowth(revisedMetricValue, deadLetterGrowth, replicas.value)
+	}
+
+	return revisedMetricValue, nil
+}
+
+// pollerKey identifies the readers a poller reads, scaled objects read by the same readers
+// share a poller, so the backends are read once per interval however many there are
+type pollerKey struct {
+	metricsReader      MetricsReader
+	replicaCountReader ReplicaCountReader
+}
+
+// metricsPoller holds the readings a background poller refreshes
+type metricsPoller struct {
+	// closed once the first readings are read
+	ready chan struct{}
+
+	mu       sync.Mutex
+	readings metricReadings
+	lastUsed time.Time
+}
+
+// getPoller returns the poller of the scaler's readers, starting it on first use
+func (e *ExternalScaler) getPoller() *metricsPoller {
+	e.pollersMu.Lock()
+	defer e.pollersMu.Unlock()
+
+	key := pollerKey{metricsReader: e.MetricsReader, replicaCountReader: e.ReplicaCountReader}
+	poller, ok := e.pollers[key]
+	if !ok {
+		if e.pollers == nil {
+			e.pollers = map[pollerKey]*metricsPoller{}
+		}
+		poller = &metricsPoller{ready: make(chan struct{})}
+		e.pollers[key] = poller
+		slog.Info(fmt.Sprintf("Starting a poller, polling every %ds", e.POLL_INTERVAL_SECONDS))
+		go e.poll(key, poller)
+	}
+
+	// marked used while pollersMu is held, so an idle poller cannot stop in between
+	poller.mu.Lock()
+	poller.lastUsed = time.Now()
+	poller.mu.Unlock()
+	return poller
+}
+
+// poll refreshes the poller's readings every POLL_INTERVAL_SECONDS, until they have not been
+// used for POLLER_IDLE_TIMEOUT_SECONDS
+func (e *ExternalScaler) poll(key pollerKey, poller *metricsPoller) {
+	ticker := time.NewTicker(time.Duration(e.POLL_INTERVAL_SECONDS) * time.Second)
+	defer ticker.Stop()
+	idleTimeout := time.Duration(e.POLLER_IDLE_TIMEOUT_SECONDS) * time.Second
+
+	lastDeadLetterCount := -1
+	for first := true; ; first = false {
+		readings := e.readMetrics(context.Background())
+		// a failed dead letter read is skipped, the next growth is then over two intervals
+		if readings.readDeadLetters && readings.deadLetterCount.err == nil {
+			if lastDeadLetterCount != -1 {
+				readings.deadLetterGrowth = readings.deadLetterCount.value - lastDeadLetterCount
+			}
+			lastDeadLetterCount = readings.deadLetterCount.value
+		}
+
+		poller.mu.Lock()
+		poller.readings = readings
+		poller.mu.Unlock()
+		if first {
+			close(poller.ready)
+		}
+
+		<-ticker.C
+
+		e.pollersMu.Lock()
+		poller.mu.Lock()
+		idle := time.Since(poller.lastUsed) > idleTimeout
+		if idle {
+			delete(e.pollers, key)
+		}
+		poller.mu.Unlock()
+		e.pollersMu.Unlock()
+		if idle {
+			slog.Info(fmt.Sprintf("Stopping a poller, not used for %v", idleTimeout))
+			return
+		}
+	}
+}
+
+// get returns the latest readings, waiting for the first ones within ctx
+func (p *metricsPoller) get(ctx context.Context) (metricReadings, error) {
+	select {
+	case <-p.ready:
+	case <-ctx.Done():
+		return metricReadings{}, fmt.Errorf("no readings polled yet: %w", ctx.Err())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.readings, nil
+}
+
+// fallback records a successful read as its last known value. A failed read fails with the
+// fail policy, and with the lastKnown policy is replaced by its last known value, unless that
+// is older than FALLBACK_MAX_AGE_MINUTES
+func (e *ExternalScaler) fallback(read *metricRead, now time.Time) error {
+	lastKnown := &e.lastKnownReads
+	lastKnown.mu.Lock()
+	defer lastKnown.mu.Unlock()
+
+	if read.err == nil {
+		if lastKnown.values == nil {
+			lastKnown.values, lastKnown.readAt = map[string]int{}, map[string]time.Time{}
+		}
+		lastKnown.values[read.name], lastKnown.readAt[read.name] = read.value, now
+		return nil
+	}
+
+	if e.FALLBACK_POLICY != FALLBACK_POLICY_LAST_KNOWN {
+		return fmt.Errorf("failed to get %s: %w", read.name, read.err)
+	}
+	readAt, ok := lastKnown.readAt[read.name]
+	if !ok || now.Sub(readAt) > time.Duration(e.FALLBACK_MAX_AGE_MINUTES)*time.Minute {
+		return fmt.Errorf("failed to get %s, with no value read within the last %d minutes to fall back to: %w", read.name, e.FALLBACK_MAX_AGE_MINUTES, read.err)
+	}
+
+	slog.Warn(fmt.Sprintf("Failed to get %s, falling back to %d read %v ago: %v", read.name, lastKnown.values[read.name], now.Sub(readAt).Round(time.Second), read.err))
+	read.value, read.err = lastKnown.values[read.name], nil
+	return nil
+}
+
 func (e *ExternalScaler) getRevisedMetricValue(msgQueueLength int, rate429Errors int, workloadReplicaCount int, minReplicas int, maxReplicas int, timeSinceLastScaleDownRequest time.Duration) int {
 
 	retVal := calculateMetricValue(e, workloadReplicaCount, rate429Errors, msgQueueLength, minReplicas, timeSinceLastScaleDownRequest)
@@ -361,6 +2069,24 @@ func calculateMetricValue(e *ExternalScaler, workloadReplicaCount int, rate429Er
 	return retVal
 }
 
+// pauseScaleUpOnDeadLetterGrowth caps the metric value at the current replica count while the
+// dead letter count grows by at least DEAD_LETTER_GROWTH_THRESHOLD between reads, as adding
+// replicas does not help when messages are failing. Scale down is not affected
+func (e *ExternalScaler) pauseScaleUpOnDeadLetterGrowth(metricValue int, deadLetterGrowth int, workloadReplicaCount int) int {
+
+	if deadLetterGrowth < e.DEAD_LETTER_GROWTH_THRESHOLD {
+		return metricValue
+	}
+
+	currentReplicasMetricValue := workloadReplicaCount * e.QUEUE_MESSAGE_COUNT_PER_REPLICA
+	if metricValue <= currentReplicasMetricValue {
+		return metricValue
+	}
+
+	slog.Warn(fmt.Sprintf("dead letter count grew by %d (threshold %d), pausing scale up at %d replicas, returning %d instead of %d", deadLetterGrowth, e.DEAD_LETTER_GROWTH_THRESHOLD, workloadReplicaCount, currentReplicasMetricValue, metricValue))
+	return currentReplicasMetricValue
+}
+
 func (e *ExternalScaler) StreamIsActive(scaledObject *pb.ScaledObjectRef, epsServer pb.ExternalScaler_StreamIsActiveServer) error {
 
 	slog.Info("StreamIsActive called")
@@ -386,6 +2112,54 @@ func printConfigurationSettings(es *ExternalScaler) {
 	fmt.Println("PROMETHEUS_ENDPOINT: ", es.PROMETHEUS_ENDPOINT)
 }
 
+// serveOTLPReceiver serves OTLP over gRPC and HTTP on the standard OTLP ports in the
+// background, a port set to "" is not served
+func serveOTLPReceiver(receiver *metricsReaders.OTLPReceiver, grpcPort string, httpPort string) {
+	if grpcPort != "" {
+		lis, err := net.Listen("tcp", ":"+grpcPort)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		otlpGrpcServer := grpc.NewServer()
+		colmetricspb.RegisterMetricsServiceServer(otlpGrpcServer, receiver)
+		fmt.Printf("OTLP/gRPC receiver listening on :%s\n", grpcPort)
+		go func() {
+			log.Fatal(otlpGrpcServer.Serve(lis))
+		}()
+	}
+
+	if httpPort != "" {
+		mux := http.NewServeMux()
+		mux.Handle(metricsReaders.OTLP_HTTP_METRICS_PATH, receiver)
+		server := &http.Server{
+			Addr:              ":" + httpPort,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+		}
+		fmt.Printf("OTLP/HTTP receiver listening on :%s%s\n", httpPort, metricsReaders.OTLP_HTTP_METRICS_PATH)
+		go func() {
+			log.Fatal(server.ListenAndServe())
+		}()
+	}
+}
+
+// servePushReceiver serves the push endpoint of pushed errors in the background
+func servePushReceiver(counter *metricsReaders.PushErrorCounter, port string) {
+	mux := http.NewServeMux()
+	mux.Handle(metricsReaders.PUSH_ERRORS_PATH, counter)
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+	fmt.Printf("Push receiver listening on :%s%s\n", port, metricsReaders.PUSH_ERRORS_PATH)
+	go func() {
+		log.Fatal(server.ListenAndServe())
+	}()
+}
+
 func main() {
 
 	logLevel := getEnvString("LOG_LEVEL", "info")
@@ -417,6 +2191,9 @@ func main() {
 		TIME_BETWEEN_SCALE_DOWN_REQUESTS_MINUTES: getEnvInt("TIME_BETWEEN_SCALE_DOWN_REQUESTS_MINUTES", 1),
 		METRICS_BACKEND:                          getEnvString("METRICS_BACKEND", ""),
 		INSTANCE_COMPUTE_BACKEND:                 getEnvString("INSTANCE_COMPUTE_BACKEND", ""),
+		ALLOWED_METRIC_NAMES:                     metricsReaders.NewAllowlist(getEnvString("ALLOWED_METRIC_NAMES", "")),
+		ALLOWED_LOG_ANALYTICS_TABLES:             metricsReaders.NewAllowlist(getEnvString("ALLOWED_LOG_ANALYTICS_TABLES", metricsReaders.DEFAULT_LOG_ANALYTICS_TABLE)),
+		ALLOW_USER_DEFINED_QUERIES:               getEnvBool("ALLOW_USER_DEFINED_QUERIES", false),
 	}
 
 	// wire up metrics and compute backends
@@ -436,6 +2213,32 @@ func main() {
 		e.ReplicaCountReader = replicaCountReaders.NewK8sDeploymentReplicaCountReader()
 	}
 
+	if getEnvBool("OTLP_RECEIVER_ENABLED", false) {
+		e.OTLPReceiver, err = metricsReaders.NewOTLPReceiver(metricsReaders.OTLPReceiverConfig{
+			Retention:            time.Duration(getEnvInt("OTLP_RECEIVER_RETENTION_MINUTES", int(metricsReaders.DEFAULT_OTLP_RETENTION/time.Minute))) * time.Minute,
+			MaxSeries:            getEnvInt("OTLP_RECEIVER_MAX_SERIES", metricsReaders.DEFAULT_OTLP_MAX_SERIES),
+			Token:                getEnvString("OTLP_RECEIVER_TOKEN", ""),
+			AllowUnauthenticated: getEnvBool("OTLP_RECEIVER_ALLOW_UNAUTHENTICATED", false),
+		})
+		if err != nil {
+			log.Fatalf("failed to start the OTLP receiver, set OTLP_RECEIVER_TOKEN or OTLP_RECEIVER_ALLOW_UNAUTHENTICATED: %v", err)
+		}
+		serveOTLPReceiver(e.OTLPReceiver, getEnvString("OTLP_RECEIVER_GRPC_PORT", "4317"), getEnvString("OTLP_RECEIVER_HTTP_PORT", "4318"))
+	}
+
+	if getEnvBool("PUSH_RECEIVER_ENABLED", false) {
+		e.PushErrorCounter, err = metricsReaders.NewPushErrorCounter(metricsReaders.PushErrorCounterConfig{
+			Retention:            time.Duration(getEnvInt("PUSH_RECEIVER_RETENTION_MINUTES", int(metricsReaders.DEFAULT_PUSH_RETENTION/time.Minute))) * time.Minute,
+			MaxCounters:          getEnvInt("PUSH_RECEIVER_MAX_COUNTERS", metricsReaders.DEFAULT_PUSH_MAX_COUNTERS),
+			Token:                getEnvString("PUSH_RECEIVER_TOKEN", ""),
+			AllowUnauthenticated: getEnvBool("PUSH_RECEIVER_ALLOW_UNAUTHENTICATED", false),
+		})
+		if err != nil {
+			log.Fatalf("failed to start the push receiver, set PUSH_RECEIVER_TOKEN or PUSH_RECEIVER_ALLOW_UNAUTHENTICATED: %v", err)
+		}
+		servePushReceiver(e.PushErrorCounter, getEnvString("PUSH_RECEIVER_PORT", "8080"))
+	}
+
 	pb.RegisterExternalScalerServer(grpcServer, &e)
 	fmt.Println("listenting on :6000")
 	if err := grpcServer.Serve(lis); err != nil {
@@ -1,8 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	pb "github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/externalscaler"
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/metricsReaders"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestGetRevisedMetricValueErrorsBelowThreshold(t *testing.T) {
@@ -206,3 +218,645 @@ func TestGetRevisedMetricValueErrorsAboveThreshold(t *testing.T) {
 
 	}
 }
+
+func TestPauseScaleUpOnDeadLetterGrowth(t *testing.T) {
+	e := &ExternalScaler{
+		QUEUE_MESSAGE_COUNT_PER_REPLICA: 10,
+		DEAD_LETTER_GROWTH_THRESHOLD:    5,
+	}
+
+	// calls are made in order, each growth is relative to the previous dead letter count of the
+	// same scaled object
+	testCases := []struct {
+		scaledObject         string
+		metricValue          int
+		deadLetterCount      int
+		workloadReplicaCount int
+		expected             int
+	}{
+		// the first call has no previous count to grow from
+		{scaledObject: "default/worker", metricValue: 80, deadLetterCount: 100, workloadReplicaCount: 3, expected: 80},
+		// growth below the threshold
+		{scaledObject: "default/worker", metricValue: 80, deadLetterCount: 104, workloadReplicaCount: 3, expected: 80},
+		// growth at the threshold pauses scale up at the current replicas
+		{scaledObject: "default/worker", metricValue: 80, deadLetterCount: 109, workloadReplicaCount: 3, expected: 30},
+		// scale down is not paused
+		{scaledObject: "default/worker", metricValue: 20, deadLetterCount: 120, workloadReplicaCount: 3, expected: 20},
+		// dead letters being cleared is not growth
+		{scaledObject: "default/worker", metricValue: 80, deadLetterCount: 0, workloadReplicaCount: 3, expected: 80},
+		// another scaled object's counts are not growth
+		{scaledObject: "default/other", metricValue: 80, deadLetterCount: 500, workloadReplicaCount: 3, expected: 80},
+		{scaledObject: "default/worker", metricValue: 80, deadLetterCount: 1, workloadReplicaCount: 3, expected: 80},
+		{scaledObject: "default/other", metricValue: 80, deadLetterCount: 501, workloadReplicaCount: 3, expected: 80},
+	}
+
+	for i, tc := range testCases {
+		result := e.pauseScaleUpOnDeadLetterGrowth(tc.metricValue, e.lastDeadLetterCounts.growth(tc.scaledObject, tc.deadLetterCount), tc.workloadReplicaCount)
+
+		if result != tc.expected {
+			t.Errorf("Expected %d, but got %d (call %d)", tc.expected, result, i)
+		}
+	}
+}
+
+func TestSourceMetadata(t *testing.T) {
+	metadata := map[string]string{
+		"prometheusEndpoint":             "http://shared:9090",
+		"minReplicas":                    "1",
+		"errorSource.prometheusEndpoint": "http://errors:9090",
+		"queueSource.rabbitMQQueueName":  "orders",
+	}
+
+	errorMetadata := sourceMetadata(metadata, ERROR_SOURCE_KEY)
+
+	expected := map[string]string{
+		"prometheusEndpoint":            "http://errors:9090",
+		"minReplicas":                   "1",
+		"queueSource.rabbitMQQueueName": "orders",
+	}
+	if len(errorMetadata) != len(expected) {
+		t.Errorf("Expected %v, but got %v", expected, errorMetadata)
+	}
+	for key, value := range expected {
+		if errorMetadata[key] != value {
+			t.Errorf("Expected %s to be %q, but got %q", key, value, errorMetadata[key])
+		}
+	}
+}
+
+func TestGetMetadataStrings(t *testing.T) {
+	testCases := []struct {
+		name          string
+		value         string
+		expected      []string
+		expectedError bool
+	}{
+		{"not set", "", nil, false},
+		{"empty array", `[]`, []string{}, false},
+		{"commas and whitespace kept", `["pending", "a, b", " padded "]`, []string{"pending", "a, b", " padded "}, false},
+		{"comma separated", `pending,failed`, nil, true},
+		{"not strings", `[1, 2]`, nil, true},
+	}
+
+	for _, tc := range testCases {
+		values, err := getMetadataStrings(map[string]string{"postgresQueryParameters": tc.value}, "postgresQueryParameters")
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Expected error %v, but got %v (%s)", tc.expectedError, err, tc.name)
+			continue
+		}
+		if len(values) != len(tc.expected) {
+			t.Errorf("Expected %q, but got %q (%s)", tc.expected, values, tc.name)
+			continue
+		}
+		for i := range values {
+			if values[i] != tc.expected[i] {
+				t.Errorf("Expected %q, but got %q (%s)", tc.expected[i], values[i], tc.name)
+			}
+		}
+	}
+}
+
+func TestValidateSetRequiredMetadataComposesSources(t *testing.T) {
+	rabbitMQ := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/queues/%2F/orders" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"messages_ready": 42}`)
+	}))
+	defer rabbitMQ.Close()
+
+	prometheus := func(value string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1700000000, "%s"]}]}}`, value)
+		}))
+	}
+	sharedPrometheus := prometheus("1")
+	defer sharedPrometheus.Close()
+	errorPrometheus := prometheus("7")
+	defer errorPrometheus.Close()
+
+	e := &ExternalScaler{
+		METRICS_BACKEND:          METRICS_BACKEND_AZURE,
+		INSTANCE_COMPUTE_BACKEND: INSTANCE_COMPUTE_BACKEND_KUBERNETES,
+	}
+	scaledObject := &pb.ScaledObjectRef{
+		Name:      "worker",
+		Namespace: "default",
+		ScalerMetadata: map[string]string{
+			"queueSource":                    METRICS_BACKEND_RABBITMQ,
+			"errorSource":                    METRICS_BACKEND_PROMETHEUS,
+			"prometheusEndpoint":             sharedPrometheus.URL,
+			"rabbitMQManagementEndpoint":     rabbitMQ.URL,
+			"rabbitMQQueueName":              "orders",
+			"errorSource.prometheusEndpoint": errorPrometheus.URL,
+			"deploymentName":                 "worker",
+			"deploymentNamespace":            "default",
+			"minReplicas":                    "1",
+			"maxReplicas":                    "10",
+		},
+	}
+
+	// the second call validates the metadata against the settings of the first
+	for i := 0; i < 2; i++ {
+		if err := e.ValidateSetRequiredMetadata(context.Background(), scaledObject); err != nil {
+			t.Fatalf("Unexpected error: %v (call %d)", err, i)
+		}
+	}
+
+	queueLength, err := e.MetricsReader.GetQueueLength(context.Background())
+	if err != nil || queueLength != 42 {
+		t.Errorf("Expected 42, but got %d (%v)", queueLength, err)
+	}
+	rate429Errors, err := e.MetricsReader.GetRate429Errors(context.Background())
+	if err != nil || rate429Errors != 7 {
+		t.Errorf("Expected 7, but got %d (%v)", rate429Errors, err)
+	}
+	// without fallbackPolicy failed reads fail the request
+	if e.FALLBACK_POLICY != FALLBACK_POLICY_FAIL {
+		t.Errorf("Expected the %s fallback policy, but got %s", FALLBACK_POLICY_FAIL, e.FALLBACK_POLICY)
+	}
+
+	scaledObject.ScalerMetadata["errorSource"] = METRICS_BACKEND_RABBITMQ
+	err = (&ExternalScaler{METRICS_BACKEND: METRICS_BACKEND_PROMETHEUS}).ValidateSetRequiredMetadata(context.Background(), scaledObject)
+	if err == nil || !strings.Contains(err.Error(), "unsupported errorSource") {
+		t.Errorf("Expected an unsupported errorSource error, but got %v", err)
+	}
+}
+
+func TestValidateSetRequiredMetadataComposesServiceBusDeadLetters(t *testing.T) {
+	deadLetterCount := 3
+	serviceBus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/orders" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<entry xmlns="http://www.w3.org/2005/Atom"><content type="application/xml"><QueueDescription><CountDetails><ActiveMessageCount>80</ActiveMessageCount><DeadLetterMessageCount>%d</DeadLetterMessageCount></CountDetails></QueueDescription></content></entry>`, deadLetterCount)
+	}))
+	defer serviceBus.Close()
+
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1700000000, "0"]}]}}`)
+	}))
+	defer prometheus.Close()
+
+	e := &ExternalScaler{
+		METRICS_BACKEND:                 METRICS_BACKEND_AZURE,
+		INSTANCE_COMPUTE_BACKEND:        INSTANCE_COMPUTE_BACKEND_KUBERNETES,
+		QUEUE_MESSAGE_COUNT_PER_REPLICA: 10,
+		RATE_429_ERROR_THRESHOLD:        5,
+		REPLICA_COUNT_TIMEOUT_SECONDS:   5,
+		QUEUE_LENGTH_TIMEOUT_SECONDS:    5,
+		RATE_429_ERRORS_TIMEOUT_SECONDS: 5,
+		FALLBACK_POLICY:                 FALLBACK_POLICY_FAIL,
+		FALLBACK_MAX_AGE_MINUTES:        5,
+		ReplicaCountReader:              &fakeReplicaCountReader{replicas: fakeRead{value: 3}},
+	}
+	scaledObject := &pb.ScaledObjectRef{
+		Name:      "worker",
+		Namespace: "default",
+		ScalerMetadata: map[string]string{
+			"queueSource":                 METRICS_BACKEND_AZURE,
+			"errorSource":                 METRICS_BACKEND_PROMETHEUS,
+			"serviceBusQueueOrTopicName":  "orders",
+			"serviceBusQueueLengthSource": "dataPlane",
+			"serviceBusConnectionString":  "Endpoint=" + serviceBus.URL + "/;SharedAccessKeyName=RootManageSharedAccessKey;SharedAccessKey=c2VjcmV0LWtleQ==",
+			"prometheusEndpoint":          prometheus.URL,
+			"deadLetterGrowthThreshold":   "5",
+			"deploymentName":              "worker",
+			"deploymentNamespace":         "default",
+			"minReplicas":                 "1",
+			"maxReplicas":                 "10",
+		},
+	}
+
+	if err := e.ValidateSetRequiredMetadata(context.Background(), scaledObject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e.DEAD_LETTER_GROWTH_THRESHOLD != 5 {
+		t.Errorf("Expected 5, but got %d", e.DEAD_LETTER_GROWTH_THRESHOLD)
+	}
+
+	// calls are made in order, the dead letters grow past the threshold before the second
+	testCases := []struct {
+		deadLetterCount int
+		expected        int
+	}{
+		{deadLetterCount: 3, expected: 80},
+		{deadLetterCount: 20, expected: 30},
+	}
+
+	for i, tc := range testCases {
+		deadLetterCount = tc.deadLetterCount
+		metricValue, err := e.getMetricValue(context.Background(), scaledObject)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (call %d)", err, i)
+		}
+		if metricValue != tc.expected {
+			t.Errorf("Expected %d, but got %d (call %d)", tc.expected, metricValue, i)
+		}
+	}
+}
+
+func TestValidateSetRequiredMetadataRejectsStorageQueueDeadLetters(t *testing.T) {
+	e := &ExternalScaler{
+		METRICS_BACKEND:          METRICS_BACKEND_AZURE,
+		INSTANCE_COMPUTE_BACKEND: INSTANCE_COMPUTE_BACKEND_KUBERNETES,
+	}
+	scaledObject := &pb.ScaledObjectRef{
+		Name:      "worker",
+		Namespace: "default",
+		ScalerMetadata: map[string]string{
+			"storageQueueName":          "orders",
+			"storageAccountName":        "aoaiscaler",
+			"logAnalyticsWorkspaceId":   "00000000-0000-0000-0000-000000000000",
+			"deadLetterGrowthThreshold": "5",
+			"deploymentName":            "worker",
+			"deploymentNamespace":       "default",
+			"minReplicas":               "1",
+			"maxReplicas":               "10",
+		},
+	}
+
+	err := e.ValidateSetRequiredMetadata(context.Background(), scaledObject)
+	if err == nil || !strings.Contains(err.Error(), "deadLetterGrowthThreshold is not supported") {
+		t.Errorf("Expected a deadLetterGrowthThreshold error, but got %v", err)
+	}
+}
+
+func TestValidateSetRequiredMetadataValidatesRate429ErrorsMetricName(t *testing.T) {
+	testCases := []struct {
+		name        string
+		metadata    map[string]string
+		expectError bool
+	}{
+		{name: "prometheus name read by prometheus", metadata: map[string]string{"rate429ErrorsMetricName": "rate_429_errors_total"}},
+		{name: "dashed name read by prometheus", metadata: map[string]string{"rate429ErrorsMetricName": "a-b"}, expectError: true},
+		{name: "slashed name read by prometheus", metadata: map[string]string{"rate429ErrorsMetricName": "x/y"}, expectError: true},
+		{name: "dotted name read by prometheus", metadata: map[string]string{"rate429ErrorsMetricName": "subscriber-app.retries"}, expectError: true},
+		{name: "dashed name of the prometheus error source", metadata: map[string]string{"errorSource": METRICS_BACKEND_PROMETHEUS, "errorSource.rate429ErrorsMetricName": "a-b"}, expectError: true},
+		{name: "name of another error source", metadata: map[string]string{"errorSource": METRICS_BACKEND_PUSH, "rate429ErrorsMetricName": "subscriber-app.retries"}},
+	}
+
+	for _, tc := range testCases {
+		metadata := map[string]string{
+			"queueSource":                METRICS_BACKEND_RABBITMQ,
+			"rabbitMQManagementEndpoint": "http://localhost:15672",
+			"rabbitMQQueueName":          "orders",
+			"prometheusEndpoint":         "http://localhost:9090",
+			"deploymentName":             "worker",
+			"deploymentNamespace":        "default",
+			"minReplicas":                "1",
+			"maxReplicas":                "10",
+		}
+		for key, value := range tc.metadata {
+			metadata[key] = value
+		}
+		counter, err := metricsReaders.NewPushErrorCounter(metricsReaders.PushErrorCounterConfig{Token: "secret"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.name)
+		}
+		e := &ExternalScaler{
+			METRICS_BACKEND:          METRICS_BACKEND_PROMETHEUS,
+			INSTANCE_COMPUTE_BACKEND: INSTANCE_COMPUTE_BACKEND_KUBERNETES,
+			PushErrorCounter:         counter,
+		}
+
+		err = e.ValidateSetRequiredMetadata(context.Background(), &pb.ScaledObjectRef{Name: "worker", Namespace: "default", ScalerMetadata: metadata})
+		var metadataRejectedError *metricsReaders.MetadataRejectedError
+		if tc.expectError && !errors.As(err, &metadataRejectedError) {
+			t.Errorf("Expected a rejected metadata error, but got %v (%s)", err, tc.name)
+		}
+		if !tc.expectError && err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+		}
+	}
+}
+
+func TestValidateSetRequiredMetadataPushErrorSource(t *testing.T) {
+	rabbitMQ := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"messages_ready": 42}`)
+	}))
+	defer rabbitMQ.Close()
+
+	scaledObject := &pb.ScaledObjectRef{
+		Name:      "worker",
+		Namespace: "default",
+		ScalerMetadata: map[string]string{
+			"queueSource":                METRICS_BACKEND_RABBITMQ,
+			"errorSource":                METRICS_BACKEND_PUSH,
+			"rabbitMQManagementEndpoint": rabbitMQ.URL,
+			"rabbitMQQueueName":          "orders",
+			"deploymentName":             "worker",
+			"deploymentNamespace":        "default",
+			"minReplicas":                "1",
+			"maxReplicas":                "10",
+		},
+	}
+
+	err := (&ExternalScaler{METRICS_BACKEND: METRICS_BACKEND_PROMETHEUS, INSTANCE_COMPUTE_BACKEND: INSTANCE_COMPUTE_BACKEND_KUBERNETES}).ValidateSetRequiredMetadata(context.Background(), scaledObject)
+	if err == nil || !strings.Contains(err.Error(), "push receiver is not enabled") {
+		t.Errorf("Expected a push receiver not enabled error, but got %v", err)
+	}
+
+	counter, err := metricsReaders.NewPushErrorCounter(metricsReaders.PushErrorCounterConfig{Token: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e := &ExternalScaler{
+		METRICS_BACKEND:          METRICS_BACKEND_PROMETHEUS,
+		INSTANCE_COMPUTE_BACKEND: INSTANCE_COMPUTE_BACKEND_KUBERNETES,
+		PushErrorCounter:         counter,
+	}
+	if err := e.ValidateSetRequiredMetadata(context.Background(), scaledObject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := e.PushErrorCounter.Add("default/worker", 429, 3); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := e.PushErrorCounter.Add("default/other", 429, 5); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rate429Errors, err := e.MetricsReader.GetRate429Errors(context.Background())
+	if err != nil || rate429Errors != 3 {
+		t.Errorf("Expected 3, but got %d (%v)", rate429Errors, err)
+	}
+}
+
+// fakeRead is a read of the fake readers, it takes delay, or until its context is done when
+// block is set, and then returns value or err
+type fakeRead struct {
+	value int
+	err   error
+	delay time.Duration
+	block bool
+}
+
+func (f fakeRead) read(ctx context.Context) (int, error) {
+	if f.block {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	}
+	time.Sleep(f.delay)
+	return f.value, f.err
+}
+
+var fakeScaledObject = &pb.ScaledObjectRef{Name: "worker", Namespace: "default"}
+
+type fakeMetricsReader struct {
+	queueLength   fakeRead
+	rate429Errors fakeRead
+}
+
+func (f *fakeMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	return f.queueLength.read(ctx)
+}
+
+func (f *fakeMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	return f.rate429Errors.read(ctx)
+}
+
+// fakeDeadLetterMetricsReader's dead letter count grows by growth on every read
+type fakeDeadLetterMetricsReader struct {
+	fakeMetricsReader
+
+	mu              sync.Mutex
+	deadLetterCount int
+	growth          int
+}
+
+func (f *fakeDeadLetterMetricsReader) GetDeadLetterCount(ctx context.Context) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deadLetterCount += f.growth
+	return f.deadLetterCount, nil
+}
+
+type fakeReplicaCountReader struct {
+	replicas fakeRead
+}
+
+func (f *fakeReplicaCountReader) GetInstanceCount(ctx context.Context) (int, error) {
+	return f.replicas.read(ctx)
+}
+
+func TestGetMetricValueReadsConcurrently(t *testing.T) {
+	delay := 200 * time.Millisecond
+	e := &ExternalScaler{
+		QUEUE_MESSAGE_COUNT_PER_REPLICA: 10,
+		RATE_429_ERROR_THRESHOLD:        5,
+		REPLICA_COUNT_TIMEOUT_SECONDS:   5,
+		QUEUE_LENGTH_TIMEOUT_SECONDS:    5,
+		RATE_429_ERRORS_TIMEOUT_SECONDS: 5,
+		FALLBACK_POLICY:                 FALLBACK_POLICY_FAIL,
+		FALLBACK_MAX_AGE_MINUTES:        5,
+		MetricsReader: &fakeMetricsReader{
+			queueLength:   fakeRead{value: 42, delay: delay},
+			rate429Errors: fakeRead{value: 0, delay: delay},
+		},
+		ReplicaCountReader: &fakeReplicaCountReader{replicas: fakeRead{value: 3, delay: delay}},
+	}
+
+	start := time.Now()
+	metricValue, err := e.getMetricValue(context.Background(), fakeScaledObject)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if metricValue != 42 {
+		t.Errorf("Expected 42, but got %d", metricValue)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*delay {
+		t.Errorf("Expected the reads to take about %v together, but took %v", delay, elapsed)
+	}
+}
+
+func TestGetMetricValueFallbackPolicy(t *testing.T) {
+	newScaler := func(fallbackPolicy string) (*ExternalScaler, *fakeMetricsReader) {
+		metricsReader := &fakeMetricsReader{queueLength: fakeRead{value: 42}, rate429Errors: fakeRead{value: 0}}
+		return &ExternalScaler{
+			QUEUE_MESSAGE_COUNT_PER_REPLICA: 10,
+			RATE_429_ERROR_THRESHOLD:        5,
+			REPLICA_COUNT_TIMEOUT_SECONDS:   1,
+			QUEUE_LENGTH_TIMEOUT_SECONDS:    1,
+			RATE_429_ERRORS_TIMEOUT_SECONDS: 1,
+			FALLBACK_POLICY:                 fallbackPolicy,
+			FALLBACK_MAX_AGE_MINUTES:        5,
+			MetricsReader:                   metricsReader,
+			ReplicaCountReader:              &fakeReplicaCountReader{replicas: fakeRead{value: 3}},
+		}, metricsReader
+	}
+
+	// the last known queue length replaces a failed read
+	e, metricsReader := newScaler(FALLBACK_POLICY_LAST_KNOWN)
+	if _, err := e.getMetricValue(context.Background(), fakeScaledObject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	metricsReader.queueLength = fakeRead{err: fmt.Errorf("service bus unavailable")}
+	metricValue, err := e.getMetricValue(context.Background(), fakeScaledObject)
+	if err != nil || metricValue != 42 {
+		t.Errorf("Expected 42, but got %d (%v)", metricValue, err)
+	}
+
+	// the last known value is not used once older than fallbackMaxAgeMinutes
+	e.lastKnownReads.readAt["msg_queue_length"] = time.Now().Add(-6 * time.Minute)
+	if _, err := e.getMetricValue(context.Background(), fakeScaledObject); err == nil || !strings.Contains(err.Error(), "service bus unavailable") {
+		t.Errorf("Expected the read error, but got %v", err)
+	}
+
+	// a read that outlasts its timeout falls back as well, within the timeout
+	e, metricsReader = newScaler(FALLBACK_POLICY_LAST_KNOWN)
+	if _, err := e.getMetricValue(context.Background(), fakeScaledObject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	metricsReader.rate429Errors = fakeRead{block: true}
+	start := time.Now()
+	metricValue, err = e.getMetricValue(context.Background(), fakeScaledObject)
+	if err != nil || metricValue != 42 {
+		t.Errorf("Expected 42, but got %d (%v)", metricValue, err)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("Expected the read to time out after 1s, but took %v", elapsed)
+	}
+
+	// the incoming deadline cuts the reads short
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := e.getMetricValue(ctx, fakeScaledObject); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Expected the reads to end at the 100ms deadline, but took %v", elapsed)
+	}
+
+	// the fail policy fails on any failed read
+	e, metricsReader = newScaler(FALLBACK_POLICY_FAIL)
+	if _, err := e.getMetricValue(context.Background(), fakeScaledObject); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	metricsReader.queueLength = fakeRead{err: fmt.Errorf("service bus unavailable")}
+	if _, err := e.getMetricValue(context.Background(), fakeScaledObject); err == nil || !strings.Contains(err.Error(), "failed to get msg_queue_length") {
+		t.Errorf("Expected a msg_queue_length error, but got %v", err)
+	}
+}
+
+func TestGetMetricValueFromPoller(t *testing.T) {
+	metricsReader := &fakeMetricsReader{queueLength: fakeRead{value: 42}, rate429Errors: fakeRead{value: 0}}
+	replicaCountReader := &fakeReplicaCountReader{replicas: fakeRead{value: 3}}
+	e := &ExternalScaler{
+		QUEUE_MESSAGE_COUNT_PER_REPLICA: 10,
+		RATE_429_ERROR_THRESHOLD:        5,
+		REPLICA_COUNT_TIMEOUT_SECONDS:   5,
+		QUEUE_LENGTH_TIMEOUT_SECONDS:    5,
+		RATE_429_ERRORS_TIMEOUT_SECONDS: 5,
+		FALLBACK_POLICY:                 FALLBACK_POLICY_FAIL,
+		FALLBACK_MAX_AGE_MINUTES:        5,
+		POLL_INTERVAL_SECONDS:           1,
+		POLLER_IDLE_TIMEOUT_SECONDS:     1,
+		MetricsReader:                   metricsReader,
+		ReplicaCountReader:              replicaCountReader,
+	}
+	key := pollerKey{metricsReader: metricsReader, replicaCountReader: replicaCountReader}
+
+	// the first request waits for the poller's first readings
+	metricValue, err := e.getMetricValue(context.Background(), fakeScaledObject)
+	if err != nil || metricValue != 42 {
+		t.Fatalf("Expected 42, but got %d (%v)", metricValue, err)
+	}
+
+	// scaled objects read by the same readers share the poller
+	if _, err := e.getMetricValue(context.Background(), &pb.ScaledObjectRef{Name: "other", Namespace: "default"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	e.pollersMu.Lock()
+	pollers := len(e.pollers)
+	e.pollersMu.Unlock()
+	if pollers != 1 {
+		t.Errorf("Expected 1 poller, but got %d", pollers)
+	}
+
+	// later requests answer from the cached readings, without waiting for slow reads
+	e.pollersMu.Lock()
+	poller := e.pollers[key]
+	poller.mu.Lock()
+	poller.readings.msgQueueLength.value = 7
+	poller.mu.Unlock()
+	e.pollersMu.Unlock()
+	start := time.Now()
+	metricValue, err = e.getMetricValue(context.Background(), fakeScaledObject)
+	if err != nil || metricValue != 7 {
+		t.Errorf("Expected 7, but got %d (%v)", metricValue, err)
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected the cached readings right away, but took %v", elapsed)
+	}
+
+	// the poller stops once not used for POLLER_IDLE_TIMEOUT_SECONDS
+	time.Sleep(3 * time.Second)
+	e.pollersMu.Lock()
+	_, ok := e.pollers[key]
+	e.pollersMu.Unlock()
+	if ok {
+		t.Errorf("Expected the idle poller to stop")
+	}
+}
+
+func TestGetMetricValueFromPollerPausesOnDeadLetterGrowth(t *testing.T) {
+	e := &ExternalScaler{
+		QUEUE_MESSAGE_COUNT_PER_REPLICA: 10,
+		RATE_429_ERROR_THRESHOLD:        5,
+		DEAD_LETTER_GROWTH_THRESHOLD:    5,
+		REPLICA_COUNT_TIMEOUT_SECONDS:   5,
+		QUEUE_LENGTH_TIMEOUT_SECONDS:    5,
+		RATE_429_ERRORS_TIMEOUT_SECONDS: 5,
+		FALLBACK_POLICY:                 FALLBACK_POLICY_FAIL,
+		FALLBACK_MAX_AGE_MINUTES:        5,
+		POLL_INTERVAL_SECONDS:           1,
+		POLLER_IDLE_TIMEOUT_SECONDS:     60,
+		MetricsReader: &fakeDeadLetterMetricsReader{
+			fakeMetricsReader: fakeMetricsReader{queueLength: fakeRead{value: 80}, rate429Errors: fakeRead{value: 0}},
+			growth:            10,
+		},
+		ReplicaCountReader: &fakeReplicaCountReader{replicas: fakeRead{value: 3}},
+	}
+
+	// the first poll has no previous dead letter count to grow from
+	metricValue, err := e.getMetricValue(context.Background(), fakeScaledObject)
+	if err != nil || metricValue != 80 {
+		t.Fatalf("Expected 80, but got %d (%v)", metricValue, err)
+	}
+
+	// every request answered from the later polls sees their growth, not 0 between requests
+	time.Sleep(1500 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		metricValue, err = e.getMetricValue(context.Background(), fakeScaledObject)
+		if err != nil || metricValue != 30 {
+			t.Errorf("Expected 30, but got %d (%v) (request %d)", metricValue, err, i)
+		}
+	}
+}
+
+func TestGrpcError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected codes.Code
+	}{
+		{name: "queue not found", err: fmt.Errorf("failed to get msg_queue_length: %w", &metricsReaders.ResponseError{StatusCode: 404}), expected: codes.NotFound},
+		{name: "forbidden", err: &metricsReaders.ResponseError{StatusCode: 403}, expected: codes.PermissionDenied},
+		{name: "unauthorized", err: &metricsReaders.ResponseError{StatusCode: 401}, expected: codes.Unauthenticated},
+		{name: "bad request", err: &metricsReaders.ResponseError{StatusCode: 400}, expected: codes.InvalidArgument},
+		{name: "throttled", err: &metricsReaders.ResponseError{StatusCode: 429}, expected: codes.Unavailable},
+		{name: "read timed out", err: fmt.Errorf("failed to get rate_429_errors: %w", context.DeadlineExceeded), expected: codes.DeadlineExceeded},
+		{name: "failed reads", err: errors.Join(context.DeadlineExceeded, &metricsReaders.ResponseError{StatusCode: 404}), expected: codes.NotFound},
+		{name: "unknown", err: errors.New("could not decode response body"), expected: codes.Unknown},
+	}
+
+	for _, tc := range testCases {
+		actual := status.Code(grpcError(tc.err, codes.Unknown))
+		if actual != tc.expected {
+			t.Errorf("Expected %s, but got %s (%s)", tc.expected, actual, tc.name)
+		}
+	}
+}
@@ -2,47 +2,189 @@ package metricsReaders
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/azureCredentials"
 )
 
+const (
+	DEFAULT_RATE_429_LOOKBACK_WINDOW = time.Minute
+	DEFAULT_LOG_ANALYTICS_TABLE      = "AppMetrics"
+
+	// when ingestion lag detection is enabled, only records ingested within this
+	// period are considered, otherwise the configured ingestion offset is used
+	maxDetectedIngestionLag = 10 * time.Minute
+)
+
+type AzureMetricsReaderConfig struct {
+	ServiceBusResourceID            string
+	ServiceBusQueueOrTopicName      string
+	ServiceBusTopicSubscriptionName string
+	// ServiceBusQueueLengthSource is SERVICE_BUS_QUEUE_LENGTH_SOURCE_ARM (the default), which
+	// gets the queue or subscription entity from ARM, SERVICE_BUS_QUEUE_LENGTH_SOURCE_AZURE_MONITOR
+	// or SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE
+	ServiceBusQueueLengthSource string
+	// ServiceBusConnectionString signs data plane requests, only used with
+	// SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE, which doesn't need ServiceBusResourceID
+	ServiceBusConnectionString *ServiceBusConnectionString
+	// ServiceBusBacklogCounts are the SERVICE_BUS_BACKLOG_COUNT_ counts summed into the queue
+	// length, defaults to DEFAULT_SERVICE_BUS_BACKLOG_COUNTS
+	ServiceBusBacklogCounts []string
+	// StorageQueueName reads the queue length from a storage queue instead of service bus. The
+	// queue service endpoint is StorageQueueEndpoint, e.g. a local emulator's, or else the
+	// StorageAccountName account's endpoint in Cloud
+	StorageQueueName     string
+	StorageAccountName   string
+	StorageQueueEndpoint string
+	// StorageAccountKey or StorageSASToken authorize storage queue requests, when neither is
+	// set a token for Cloud's storage audience is requested from Credential
+	StorageAccountKey       string
+	StorageSASToken         string
+	Error429MetricName      string
+	LogAnalyticsWorkspaceID string
+	// Credential gets the tokens for ARM and Log Analytics requests, defaults to the shared
+	// DefaultAzureCredential
+	Credential TokenProvider
+	// Cloud sets the ARM and Log Analytics endpoints and token audiences, defaults to the
+	// public cloud
+	Cloud azureCredentials.Cloud
+	// HTTPClient makes the ARM and Log Analytics requests, defaults to a new http.Client
+	HTTPClient *http.Client
+	// Rate429Source is RATE_429_SOURCE_LOG_ANALYTICS (the default), which sums Error429MetricName
+	// in LogAnalyticsTable, or RATE_429_SOURCE_AZURE_OPENAI, which reads the 429 responses of
+	// OpenAIResourceID from Azure Monitor
+	Rate429Source string
+	// OpenAIResourceID and OpenAIDeploymentName are the Azure OpenAI resource and optional
+	// deployment read with RATE_429_SOURCE_AZURE_OPENAI, both are expected to have been validated
+	OpenAIResourceID     string
+	OpenAIDeploymentName string
+	// LogAnalyticsTable holds the 429 error metric, defaults to DEFAULT_LOG_ANALYTICS_TABLE.
+	// Both the table and the metric name are expected to have been validated
+	LogAnalyticsTable string
+
+	// Rate429LookbackWindow is the period over which 429 errors are summed, the sum is
+	// normalized to a per minute rate. Defaults to DEFAULT_RATE_429_LOOKBACK_WINDOW
+	Rate429LookbackWindow time.Duration
+	// Rate429IngestionOffset moves the end of the lookback window into the past, to allow
+	// for Log Analytics ingestion latency
+	Rate429IngestionOffset time.Duration
+	// DetectIngestionLag ends the lookback window at the most recently ingested record in
+	// LogAnalyticsTable instead of at the ingestion offset, if one was ingested recently
+	DetectIngestionLag bool
+
+	// user defined KQL queries, rendered before being set here. When set, these are run
+	// against the Log Analytics workspace instead of the built in queue length and 429 queries
+	QueueLengthQuery string
+	ErrorRateQuery   string
+}
+
 type AzureMetricsReader struct {
 	servicebusResourceID           string
 	servBusQueueOrTopicName        string
 	serviceBusTopicSubcriptionName string
+	serviceBusQueueLengthSource    string
+	serviceBusConnectionString     *ServiceBusConnectionString
+	serviceBusBacklogCounts        []string
+	storageQueueName               string
+	storageAccountName             string
+	storageQueueEndpoint           string
+	storageAccountKey              string
+	storageSASToken                string
 	error429MetricName             string
 
 	logAnalyticsWorkspaceID string
+	logAnalyticsTable       string
+
+	rate429Source        string
+	openAIResourceID     string
+	openAIDeploymentName string
+
+	credential TokenProvider
+	cloud      azureCredentials.Cloud
+	httpClient *http.Client
+
+	rate429LookbackWindow  time.Duration
+	rate429IngestionOffset time.Duration
+	detectIngestionLag     bool
+
+	queueLengthQuery string
+	errorRateQuery   string
+
+	recentCountDetails recentServiceBusCountDetails
 }
 
 type TokenProvider interface {
 	GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error)
 }
 
-func NewAzureMetricsReader(servicebusResourceID string, serBusQueueOrTopicName string, serBusTopicSubcriptionName string, error429MetricName string, logAnalyticsWorkspaceID string) *AzureMetricsReader {
+func NewAzureMetricsReader(config AzureMetricsReaderConfig) *AzureMetricsReader {
+	lookbackWindow := config.Rate429LookbackWindow
+	if lookbackWindow <= 0 {
+		lookbackWindow = DEFAULT_RATE_429_LOOKBACK_WINDOW
+	}
+	logAnalyticsTable := config.LogAnalyticsTable
+	if logAnalyticsTable == "" {
+		logAnalyticsTable = DEFAULT_LOG_ANALYTICS_TABLE
+	}
+	cloud := config.Cloud
+	if cloud.Name == "" {
+		cloud, _ = azureCredentials.GetCloud(azureCredentials.AZURE_PUBLIC_CLOUD, azureCredentials.Cloud{})
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	backlogCounts := config.ServiceBusBacklogCounts
+	if len(backlogCounts) == 0 {
+		backlogCounts = DEFAULT_SERVICE_BUS_BACKLOG_COUNTS
+	}
+
 	return &AzureMetricsReader{
-		servicebusResourceID:           servicebusResourceID,
-		servBusQueueOrTopicName:        serBusQueueOrTopicName,
-		serviceBusTopicSubcriptionName: serBusTopicSubcriptionName,
-		error429MetricName:             error429MetricName,
-		logAnalyticsWorkspaceID:        logAnalyticsWorkspaceID,
+		servicebusResourceID:           config.ServiceBusResourceID,
+		servBusQueueOrTopicName:        config.ServiceBusQueueOrTopicName,
+		serviceBusTopicSubcriptionName: config.ServiceBusTopicSubscriptionName,
+		serviceBusQueueLengthSource:    config.ServiceBusQueueLengthSource,
+		serviceBusConnectionString:     config.ServiceBusConnectionString,
+		serviceBusBacklogCounts:        backlogCounts,
+		storageQueueName:               config.StorageQueueName,
+		storageAccountName:             config.StorageAccountName,
+		storageQueueEndpoint:           config.StorageQueueEndpoint,
+		storageAccountKey:              config.StorageAccountKey,
+		storageSASToken:                config.StorageSASToken,
+		error429MetricName:             config.Error429MetricName,
+		logAnalyticsWorkspaceID:        config.LogAnalyticsWorkspaceID,
+		logAnalyticsTable:              logAnalyticsTable,
+		rate429Source:                  config.Rate429Source,
+		openAIResourceID:               config.OpenAIResourceID,
+		openAIDeploymentName:           config.OpenAIDeploymentName,
+		credential:                     config.Credential,
+		cloud:                          cloud,
+		httpClient:                     httpClient,
+		rate429LookbackWindow:          lookbackWindow,
+		rate429IngestionOffset:         config.Rate429IngestionOffset,
+		detectIngestionLag:             config.DetectIngestionLag,
+		queueLengthQuery:               config.QueueLengthQuery,
+		errorRateQuery:                 config.ErrorRateQuery,
 	}
 }
 
-func (a *AzureMetricsReader) getBearerToken(tp TokenProvider) (bearerToken string, err error) {
-	opts := policy.TokenRequestOptions{Scopes: []string{"https://management.azure.com/.default"}}
-	tok, err := tp.GetToken(context.Background(), opts)
+func (a *AzureMetricsReader) getCredential() (TokenProvider, error) {
+	if a.credential != nil {
+		return a.credential, nil
+	}
+	return azureCredentials.GetCredential(azureCredentials.Options{})
+}
+
+func (a *AzureMetricsReader) getBearerToken(ctx context.Context, tp TokenProvider) (bearerToken string, err error) {
+	opts := policy.TokenRequestOptions{Scopes: []string{a.cloud.ResourceManagerScope()}}
+	tok, err := tp.GetToken(ctx, opts)
 	if err != nil {
 		return "", err
 	}
@@ -50,9 +192,9 @@ func (a *AzureMetricsReader) getBearerToken(tp TokenProvider) (bearerToken strin
 	return tok.Token, nil
 }
 
-func (a *AzureMetricsReader) getLogAnalyticsBearerToken(tp TokenProvider) (bearerToken string, err error) {
-	opts := policy.TokenRequestOptions{Scopes: []string{"https://api.loganalytics.io/.default"}}
-	tok, err := tp.GetToken(context.Background(), opts)
+func (a *AzureMetricsReader) getLogAnalyticsBearerToken(ctx context.Context, tp TokenProvider) (bearerToken string, err error) {
+	opts := policy.TokenRequestOptions{Scopes: []string{a.cloud.LogAnalyticsScope()}}
+	tok, err := tp.GetToken(ctx, opts)
 	if err != nil {
 		return "", err
 	}
@@ -60,53 +202,140 @@ func (a *AzureMetricsReader) getLogAnalyticsBearerToken(tp TokenProvider) (beare
 	return tok.Token, nil
 }
 
-func (a *AzureMetricsReader) GetRate429Errors() (int, error) {
-	// Get number of 429s in the last minute
-	// NOTE: this won't be a full minute's worth of data due to the ingestion time for metrics
-	return a.GetLogAnalyticsQueryResult(fmt.Sprintf("AppMetrics | where Name  == '%s' | where TimeGenerated > ago(1m) | summarize rate_429_errors=sum(ItemCount)", a.error429MetricName))
+// kqlTimespan formats a duration as a KQL timespan literal, e.g. 90s
+func kqlTimespan(d time.Duration) string {
+	return fmt.Sprintf("%ds", int64(d.Seconds()))
+}
+
+// GetRate429ErrorsQuery returns the KQL query summing 429 errors over the lookback window. The
+// window ends rate429IngestionOffset ago, or at the latest ingested record when
+// ingestion lag detection is enabled
+func (a *AzureMetricsReader) GetRate429ErrorsQuery() string {
+	endTime := fmt.Sprintf("let endTime = now() - %s;", kqlTimespan(a.rate429IngestionOffset))
+	if a.detectIngestionLag {
+		endTime = fmt.Sprintf("let lastIngested = toscalar(%s | where TimeGenerated > ago(%s) | summarize max(TimeGenerated)); let endTime = iff(isnull(lastIngested), now() - %s, lastIngested);", a.logAnalyticsTable, kqlTimespan(maxDetectedIngestionLag), kqlTimespan(a.rate429IngestionOffset))
+	}
+
+	return fmt.Sprintf("%s %s | where Name == %s | where TimeGenerated > endTime - %s and TimeGenerated <= endTime | summarize rate_429_errors=sum(ItemCount)", endTime, a.logAnalyticsTable, QuoteKQLString(a.error429MetricName), kqlTimespan(a.rate429LookbackWindow))
+}
+
+// normalizeToPerMinuteRate converts a count over the lookback window to a per minute rate, so
+// that RATE_429_ERROR_THRESHOLD keeps its meaning whatever the window
+func (a *AzureMetricsReader) normalizeToPerMinuteRate(count int) int {
+	return int(math.Round(float64(count) / a.rate429LookbackWindow.Minutes()))
+}
+
+func (a *AzureMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	if a.errorRateQuery != "" {
+		// user defined queries are expected to return the rate as is
+		return a.GetLogAnalyticsQueryResult(ctx, a.errorRateQuery)
+	}
+
+	if a.rate429Source == RATE_429_SOURCE_AZURE_OPENAI {
+		return a.getRate429ErrorsFromAzureOpenAI(ctx)
+	}
+
+	// Get number of 429s in the lookback window, offset to allow for the ingestion time for metrics
+	errorCount, err := a.GetLogAnalyticsQueryResult(ctx, a.GetRate429ErrorsQuery())
+	if err != nil {
+		return 0, err
+	}
+
+	return a.normalizeToPerMinuteRate(errorCount), nil
 }
 
 func (a *AzureMetricsReader) GetQueueOrTopicLengthRequestUri() string {
 	if a.serviceBusTopicSubcriptionName == "" {
-		return fmt.Sprintf("https://management.azure.com:443%s/queues/%s?api-version=2023-01-01-preview", a.servicebusResourceID, a.servBusQueueOrTopicName)
+		return fmt.Sprintf("%s%s/queues/%s?api-version=2023-01-01-preview", a.cloud.ResourceManagerEndpoint, a.servicebusResourceID, a.servBusQueueOrTopicName)
 	}
-	return fmt.Sprintf("https://management.azure.com:443%s/topics/%s/subscriptions/%s?api-version=2023-01-01-preview", a.servicebusResourceID, a.servBusQueueOrTopicName, a.serviceBusTopicSubcriptionName)
+	return fmt.Sprintf("%s%s/topics/%s/subscriptions/%s?api-version=2023-01-01-preview", a.cloud.ResourceManagerEndpoint, a.servicebusResourceID, a.servBusQueueOrTopicName, a.serviceBusTopicSubcriptionName)
 }
 
-func (a *AzureMetricsReader) GetQueueLength() (int, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+func (a *AzureMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	if a.queueLengthQuery != "" {
+		return a.GetLogAnalyticsQueryResult(ctx, a.queueLengthQuery)
+	}
+
+	if a.storageQueueName != "" {
+		return a.getStorageQueueLength(ctx)
+	}
+
+	countDetails, err := a.GetServiceBusCountDetails(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get Azure credential: %w", err)
+		return 0, err
+	}
+
+	slog.Debug(fmt.Sprintf("service bus counts: %+v, backlog counts: %v", countDetails, a.serviceBusBacklogCounts))
+	return int(countDetails.Backlog(a.serviceBusBacklogCounts)), nil
+}
+
+// GetDeadLetterCount gets the number of dead lettered messages of the queue or topic
+// subscription, reusing the counts read by GetQueueLength if they were read just before
+func (a *AzureMetricsReader) GetDeadLetterCount(ctx context.Context) (int, error) {
+	if a.servBusQueueOrTopicName == "" {
+		return 0, fmt.Errorf("the dead letter count requires the service bus settings")
 	}
 
-	startTime := time.Now().Add(-time.Minute * 5).UTC()
+	countDetails, ok := a.recentCountDetails.get()
+	if !ok {
+		var err error
+		countDetails, err = a.GetServiceBusCountDetails(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	startTimeString := startTime.Format("2006-01-02T15:04:05")
-	startTimeString = fmt.Sprintf("%s.000Z", startTimeString)
+	return int(countDetails.DeadLetters()), nil
+}
 
-	endTime := time.Now().UTC()
-	endTimeString := endTime.Format("2006-01-02T15:04:05")
-	endTimeString = fmt.Sprintf("%s.000Z", endTimeString)
+// SupportsDeadLetterCount reports whether the queue is a service bus queue or topic subscription,
+// storage queues have no dead letter count
+func (a *AzureMetricsReader) SupportsDeadLetterCount() bool {
+	return a.servBusQueueOrTopicName != ""
+}
 
-	timespan := fmt.Sprintf("%s/%s", startTimeString, endTimeString)
+// GetServiceBusCountDetails gets the message counts of the queue or topic subscription from
+// the configured source
+func (a *AzureMetricsReader) GetServiceBusCountDetails(ctx context.Context) (ServiceBusCountDetails, error) {
+	var countDetails ServiceBusCountDetails
+	var err error
+	switch a.serviceBusQueueLengthSource {
+	case SERVICE_BUS_QUEUE_LENGTH_SOURCE_AZURE_MONITOR:
+		countDetails, err = a.getServiceBusCountDetailsFromAzureMonitor(ctx)
+	case SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE:
+		countDetails, err = a.getServiceBusCountDetailsFromDataPlane(ctx)
+	default:
+		countDetails, err = a.getServiceBusCountDetailsFromARM(ctx)
+	}
+	if err != nil {
+		return ServiceBusCountDetails{}, err
+	}
+
+	a.recentCountDetails.set(countDetails)
+	return countDetails, nil
+}
+
+func (a *AzureMetricsReader) getServiceBusCountDetailsFromARM(ctx context.Context) (ServiceBusCountDetails, error) {
+	cred, err := a.getCredential()
+	if err != nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("failed to get Azure credential: %w", err)
+	}
 
-	timespan = strings.Trim(timespan, " ")
-	fmt.Printf("Time span: %s\n", timespan)
 	requestUri := a.GetQueueOrTopicLengthRequestUri()
 
 	// fmt.Printf("Request URI: %s\n", requestUri)
 	slog.Debug(fmt.Sprintf("Request URI: %s\n", requestUri))
 
-	bearerToken, err := a.getBearerToken(cred)
+	bearerToken, err := a.getBearerToken(ctx, cred)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get bearer token: %w", err)
+		return ServiceBusCountDetails{}, fmt.Errorf("failed to get bearer token: %w", err)
 	}
 
-	client := &http.Client{}
+	client := a.httpClient
 
-	req, err := http.NewRequest("GET", requestUri, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUri, nil)
 	if err != nil {
-		return 0, err
+		return ServiceBusCountDetails{}, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -119,54 +348,33 @@ func (a *AzureMetricsReader) GetQueueLength() (int, error) {
 	// make request
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, err
+		return ServiceBusCountDetails{}, err
 	}
 
-	// read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, err
-	}
 	defer resp.Body.Close()
 
-	// parse response body
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
-	if err != nil {
-		return 0, err
-	}
-
-	// fmt.Printf("Response body: %v", result)
-	// slog.Debug(fmt.Sprintf("Response body: %v", result))
-
-	// print result json
-
-	// get metric value
-
-	queueOrTopicLength := result["properties"].(map[string]interface{})["countDetails"].(map[string]interface{})["activeMessageCount"].(float64)
-
-	return int(queueOrTopicLength), nil
+	return parseServiceBusEntityResponse(resp)
 }
 
-func (a *AzureMetricsReader) GetLogAnalyticsQueryResult(query string) (int, error) {
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
+func (a *AzureMetricsReader) GetLogAnalyticsQueryResult(ctx context.Context, query string) (int, error) {
+	cred, err := a.getCredential()
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("failed to get Azure credential: %w", err)
 	}
 
-	bearerToken, err := a.getLogAnalyticsBearerToken(cred)
+	bearerToken, err := a.getLogAnalyticsBearerToken(ctx, cred)
 	if err != nil {
 		return 0, err
 	}
 
-	client := &http.Client{}
+	client := a.httpClient
 
-	queryUri := fmt.Sprintf("https://api.loganalytics.io/v1/workspaces/%s/query?query=%s", a.logAnalyticsWorkspaceID, url.QueryEscape(query))
+	queryUri := fmt.Sprintf("%s/v1/workspaces/%s/query?query=%s", a.cloud.LogAnalyticsEndpoint, a.logAnalyticsWorkspaceID, url.QueryEscape(query))
 	slog.Debug(fmt.Sprintf("QueryURI: %s \n", queryUri))
 
 	// fmt.Printf("Query URI: %s\n", queryUri)
 
-	req, err := http.NewRequest("GET", queryUri, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", queryUri, nil)
 	if err != nil {
 		return 0, fmt.Errorf("could not create get request: %w", err)
 	}
@@ -184,48 +392,14 @@ func (a *AzureMetricsReader) GetLogAnalyticsQueryResult(query string) (int, erro
 		return 0, fmt.Errorf("could not make request: %w", err)
 	}
 
-	// read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0, fmt.Errorf("could not read log analytics workspace response body: %w", err)
-	}
 	defer resp.Body.Close()
 
-	slog.Debug(fmt.Sprintf("Response body: %s\n", string(body)))
-
-	// parse body to get query result
-	var result map[string]interface{}
-	err = json.Unmarshal(body, &result)
+	queryResult, err := parseLogAnalyticsQueryResponse(resp)
 	if err != nil {
-		return 0, fmt.Errorf("could not unmarshall log analytics workspace query response body %w", err)
-	}
-	// fmt.Printf("Result: %v\n", result)
-
-	tables := result["tables"].([]interface{})
-	// fmt.Printf("Tables: %v\n", tables)
-
-	// fmt.Printf("Tables[0]: %v\n", tables[0])
-
-	rows := tables[0].(map[string]interface{})["rows"].([]interface{})
-
-	// fmt.Printf("Rows: %v\n", rows)
-	if len(rows) == 0 {
-		// this implies no 429 error data exists
-		return 0, nil
+		return 0, err
 	}
-	row := rows[0]
-	// fmt.Printf("Row: %v\n", row)
-
-	//row is [15] , we need to get value 15 from it
-	res := row.([]interface{})[0]
-	// fmt.Printf("Res: %v\n", res)
-
-	// res := rows[0]
 
-	queryResult, err := strconv.Atoi(fmt.Sprintf("%v", res))
-	if err != nil {
-		return 0, fmt.Errorf("could not parse  log analytics workspace query response: %w", err)
-	}
+	slog.Debug(fmt.Sprintf("Query result: %d", queryResult))
 
 	return queryResult, nil
 }
@@ -0,0 +1,170 @@
+package metricsReaders
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/azureCredentials"
+)
+
+// fakeTokenProvider returns the requested scope as the token
+type fakeTokenProvider struct{}
+
+func (f fakeTokenProvider) GetToken(ctx context.Context, opts policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	return azcore.AccessToken{Token: opts.Scopes[0], ExpiresOn: time.Now().Add(time.Hour)}, nil
+}
+
+// newFakeAzureServer stands in for ARM and Log Analytics, serving testdata fixtures by path and
+// checking requests carry a token for the expected audience
+func newFakeAzureServer(t *testing.T, fixtures map[string]string, audience string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+audience+"/.default" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error": {"code": "InvalidAuthenticationToken", "message": "wrong audience"}}`))
+			return
+		}
+		fixture, ok := fixtures[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Errorf("Failed to read fixture %s: %v", fixture, err)
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestAzureMetricsReaderAgainstFakeServer(t *testing.T) {
+	const (
+		serviceBusResourceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-aoaiscaler/providers/Microsoft.ServiceBus/namespaces/aoaiscaler"
+		armAudience          = "https://management.usgovcloudapi.net"
+		logAnalyticsAudience = "https://api.loganalytics.us"
+	)
+
+	armServer := newFakeAzureServer(t, map[string]string{
+		serviceBusResourceID + "/topics/embeddings/subscriptions/subscriber-app": "servicebus_subscription.json",
+	}, armAudience)
+	logAnalyticsServer := newFakeAzureServer(t, map[string]string{
+		"/v1/workspaces/workspace-id/query": "loganalytics_query.json",
+	}, logAnalyticsAudience)
+
+	cloud, err := azureCredentials.GetCloud(azureCredentials.AZURE_US_GOVERNMENT_CLOUD, azureCredentials.Cloud{
+		ResourceManagerEndpoint: armServer.URL,
+		LogAnalyticsEndpoint:    logAnalyticsServer.URL,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	a := NewAzureMetricsReader(AzureMetricsReaderConfig{
+		ServiceBusResourceID:            serviceBusResourceID,
+		ServiceBusQueueOrTopicName:      "embeddings",
+		ServiceBusTopicSubscriptionName: "subscriber-app",
+		Error429MetricName:              "rate_429_errors",
+		LogAnalyticsWorkspaceID:         "workspace-id",
+		Credential:                      fakeTokenProvider{},
+		Cloud:                           cloud,
+		HTTPClient:                      armServer.Client(),
+	})
+
+	queueLength, err := a.GetQueueLength(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if queueLength != 42 {
+		t.Errorf("Expected queue length 42, but got %d", queueLength)
+	}
+
+	rate429Errors, err := a.GetRate429Errors(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rate429Errors != 15 {
+		t.Errorf("Expected 15 errors, but got %d", rate429Errors)
+	}
+}
+
+func TestGetRate429ErrorsQuery(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   AzureMetricsReaderConfig
+		expected string
+	}{
+		{
+			name:     "defaults to the last minute",
+			config:   AzureMetricsReaderConfig{Error429MetricName: "rate_429_errors"},
+			expected: "let endTime = now() - 0s; AppMetrics | where Name == 'rate_429_errors' | where TimeGenerated > endTime - 60s and TimeGenerated <= endTime | summarize rate_429_errors=sum(ItemCount)",
+		},
+		{
+			name: "3 minutes ending 2 minutes ago",
+			config: AzureMetricsReaderConfig{
+				Error429MetricName:     "rate_429_errors",
+				Rate429LookbackWindow:  3 * time.Minute,
+				Rate429IngestionOffset: 2 * time.Minute,
+			},
+			expected: "let endTime = now() - 120s; AppMetrics | where Name == 'rate_429_errors' | where TimeGenerated > endTime - 180s and TimeGenerated <= endTime | summarize rate_429_errors=sum(ItemCount)",
+		},
+		{
+			name: "detect ingestion lag",
+			config: AzureMetricsReaderConfig{
+				Error429MetricName:     "rate_429_errors",
+				Rate429LookbackWindow:  3 * time.Minute,
+				Rate429IngestionOffset: 2 * time.Minute,
+				DetectIngestionLag:     true,
+			},
+			expected: "let lastIngested = toscalar(AppMetrics | where TimeGenerated > ago(600s) | summarize max(TimeGenerated)); let endTime = iff(isnull(lastIngested), now() - 120s, lastIngested); AppMetrics | where Name == 'rate_429_errors' | where TimeGenerated > endTime - 180s and TimeGenerated <= endTime | summarize rate_429_errors=sum(ItemCount)",
+		},
+		{
+			name: "custom table and quoted metric name",
+			config: AzureMetricsReaderConfig{
+				Error429MetricName: `it's\`,
+				LogAnalyticsTable:  "AppMetricsArchive",
+			},
+			expected: `let endTime = now() - 0s; AppMetricsArchive | where Name == 'it\'s\\' | where TimeGenerated > endTime - 60s and TimeGenerated <= endTime | summarize rate_429_errors=sum(ItemCount)`,
+		},
+	}
+
+	for _, tc := range testCases {
+		result := NewAzureMetricsReader(tc.config).GetRate429ErrorsQuery()
+
+		if result != tc.expected {
+			t.Errorf("Expected %q, but got %q (%q)", tc.expected, result, tc.name)
+		}
+	}
+}
+
+func TestNormalizeToPerMinuteRate(t *testing.T) {
+	testCases := []struct {
+		lookbackWindow time.Duration
+		count          int
+		expected       int
+	}{
+		{lookbackWindow: time.Minute, count: 7, expected: 7},
+		{lookbackWindow: 3 * time.Minute, count: 18, expected: 6},
+		{lookbackWindow: 3 * time.Minute, count: 17, expected: 6},
+		{lookbackWindow: 3 * time.Minute, count: 1, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		a := NewAzureMetricsReader(AzureMetricsReaderConfig{Rate429LookbackWindow: tc.lookbackWindow})
+		result := a.normalizeToPerMinuteRate(tc.count)
+
+		if result != tc.expected {
+			t.Errorf("Expected %d, but got %d (count %d over %v)", tc.expected, result, tc.count, tc.lookbackWindow)
+		}
+	}
+}
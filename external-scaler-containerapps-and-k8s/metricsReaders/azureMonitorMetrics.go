@@ -0,0 +1,118 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const AZURE_MONITOR_METRICS_API_VERSION = "2023-10-01"
+
+type azureMonitorMetricsResponse struct {
+	Value []azureMonitorMetric `json:"value"`
+}
+
+type azureMonitorMetric struct {
+	Name struct {
+		Value string `json:"value"`
+	} `json:"name"`
+	Timeseries []azureMonitorTimeseries `json:"timeseries"`
+}
+
+type azureMonitorTimeseries struct {
+	MetadataValues []struct {
+		Name struct {
+			Value string `json:"value"`
+		} `json:"name"`
+		Value string `json:"value"`
+	} `json:"metadatavalues"`
+	Data []struct {
+		TimeStamp string   `json:"timeStamp"`
+		Average   *float64 `json:"average"`
+		Total     *float64 `json:"total"`
+	} `json:"data"`
+}
+
+// dimensionValue returns the value of a dimension of the timeseries, dimension names are
+// compared case insensitively as Azure Monitor returns them lower cased
+func (t azureMonitorTimeseries) dimensionValue(dimension string) string {
+	for _, metadataValue := range t.MetadataValues {
+		if strings.EqualFold(metadataValue.Name.Value, dimension) {
+			return metadataValue.Value
+		}
+	}
+	return ""
+}
+
+// latestAverage returns the most recent average in the timeseries, Azure Monitor returns
+// datapoints that have no data yet without an average
+func (t azureMonitorTimeseries) latestAverage() (float64, bool) {
+	for i := len(t.Data) - 1; i >= 0; i-- {
+		if t.Data[i].Average != nil {
+			return *t.Data[i].Average, true
+		}
+	}
+	return 0, false
+}
+
+// sumTotal sums the totals of the timeseries' datapoints, datapoints without data are skipped
+func (t azureMonitorTimeseries) sumTotal() float64 {
+	var sum float64
+	for _, data := range t.Data {
+		if data.Total != nil {
+			sum += *data.Total
+		}
+	}
+	return sum
+}
+
+// azureMonitorTimespan returns the ISO 8601 interval for the period ending offset ago
+func azureMonitorTimespan(period time.Duration, offset time.Duration) string {
+	endTime := time.Now().UTC().Add(-offset)
+	startTime := endTime.Add(-period)
+	return fmt.Sprintf("%s/%s", startTime.Format(time.RFC3339), endTime.Format(time.RFC3339))
+}
+
+// GetAzureMonitorMetrics queries the Azure Monitor metrics of the resource, query holds the
+// metricnames, aggregation, interval, timespan and $filter parameters
+func (a *AzureMetricsReader) GetAzureMonitorMetrics(ctx context.Context, resourceID string, query url.Values) (*azureMonitorMetricsResponse, error) {
+	cred, err := a.getCredential()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Azure credential: %w", err)
+	}
+
+	bearerToken, err := a.getBearerToken(ctx, cred)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bearer token: %w", err)
+	}
+
+	query.Set("api-version", AZURE_MONITOR_METRICS_API_VERSION)
+	requestUri := fmt.Sprintf("%s%s/providers/Microsoft.Insights/metrics?%s", a.cloud.ResourceManagerEndpoint, resourceID, query.Encode())
+	slog.Debug(fmt.Sprintf("Request URI: %s\n", requestUri))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create get request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "Go HTTP Client")
+	req.Header.Add("Authorization", "Bearer "+bearerToken)
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var metrics azureMonitorMetricsResponse
+	if err := decodeResponse(resp, &metrics); err != nil {
+		return nil, fmt.Errorf("could not get azure monitor metrics: %w", err)
+	}
+
+	return &metrics, nil
+}
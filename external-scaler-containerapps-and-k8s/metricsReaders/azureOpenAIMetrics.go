@@ -0,0 +1,110 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+const (
+	RATE_429_SOURCE_LOG_ANALYTICS = "logAnalytics"
+	RATE_429_SOURCE_AZURE_OPENAI  = "azureOpenAI"
+
+	azureOpenAIRequestsMetric = "AzureOpenAIRequests"
+	// Processed Inference Tokens, prompt and generated tokens together
+	azureOpenAIProcessedTokensMetric = "TokenTransaction"
+	// Provisioned-managed Utilization V2, the percentage of the provisioned throughput used
+	azureOpenAIProvisionedUtilizationMetric = "AzureOpenAIProvisionedManagedUtilizationV2"
+)
+
+// AzureOpenAIMetrics are an Azure OpenAI resource's own metrics over the lookback window.
+// Counts are per minute rates
+type AzureOpenAIMetrics struct {
+	Rate429Errors   int
+	ProcessedTokens int
+	// ProvisionedUtilization is the latest utilization percentage, only reported for
+	// provisioned deployments
+	ProvisionedUtilization    float64
+	HasProvisionedUtilization bool
+}
+
+// azureOpenAIFilter returns the $filter for the metrics, limited to the configured deployment.
+// The deployment name is expected to have been validated
+func (a *AzureMetricsReader) azureOpenAIFilter(filters ...string) string {
+	if a.openAIDeploymentName != "" {
+		filters = append(filters, fmt.Sprintf("ModelDeploymentName eq '%s'", a.openAIDeploymentName))
+	}
+	return strings.Join(filters, " and ")
+}
+
+// azureOpenAIQuery returns the query parameters for metrics of the lookback window, ending
+// rate429IngestionOffset ago to allow for the metrics' latency
+func (a *AzureMetricsReader) azureOpenAIQuery(metricNames string, aggregation string, filter string) url.Values {
+	query := url.Values{}
+	query.Set("metricnames", metricNames)
+	query.Set("aggregation", aggregation)
+	query.Set("interval", "PT1M")
+	query.Set("timespan", azureMonitorTimespan(a.rate429LookbackWindow, a.rate429IngestionOffset))
+	if filter != "" {
+		query.Set("$filter", filter)
+	}
+	return query
+}
+
+// GetAzureOpenAIMetrics gets the 429 responses, processed tokens and provisioned utilization
+// from the Azure OpenAI resource's Azure Monitor metrics. The 429s are read separately, as
+// the StatusCode dimension only exists on AzureOpenAIRequests
+func (a *AzureMetricsReader) GetAzureOpenAIMetrics(ctx context.Context) (AzureOpenAIMetrics, error) {
+	requests, err := a.GetAzureMonitorMetrics(ctx, a.openAIResourceID, a.azureOpenAIQuery(azureOpenAIRequestsMetric, "Total", a.azureOpenAIFilter("StatusCode eq '429'")))
+	if err != nil {
+		return AzureOpenAIMetrics{}, fmt.Errorf("could not get azure openai requests: %w", err)
+	}
+
+	var errorCount float64
+	for _, metric := range requests.Value {
+		for _, timeseries := range metric.Timeseries {
+			errorCount += timeseries.sumTotal()
+		}
+	}
+
+	usage, err := a.GetAzureMonitorMetrics(ctx, a.openAIResourceID, a.azureOpenAIQuery(azureOpenAIProcessedTokensMetric+","+azureOpenAIProvisionedUtilizationMetric, "Total,Average", a.azureOpenAIFilter()))
+	if err != nil {
+		return AzureOpenAIMetrics{}, fmt.Errorf("could not get azure openai usage: %w", err)
+	}
+
+	metrics := AzureOpenAIMetrics{Rate429Errors: a.normalizeToPerMinuteRate(int(errorCount))}
+	var processedTokens float64
+	for _, metric := range usage.Value {
+		for _, timeseries := range metric.Timeseries {
+			switch metric.Name.Value {
+			case azureOpenAIProcessedTokensMetric:
+				processedTokens += timeseries.sumTotal()
+			case azureOpenAIProvisionedUtilizationMetric:
+				if utilization, ok := timeseries.latestAverage(); ok && utilization >= metrics.ProvisionedUtilization {
+					metrics.ProvisionedUtilization = utilization
+					metrics.HasProvisionedUtilization = true
+				}
+			}
+		}
+	}
+	metrics.ProcessedTokens = a.normalizeToPerMinuteRate(int(processedTokens))
+
+	return metrics, nil
+}
+
+func (a *AzureMetricsReader) getRate429ErrorsFromAzureOpenAI(ctx context.Context) (int, error) {
+	metrics, err := a.GetAzureOpenAIMetrics(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if metrics.HasProvisionedUtilization {
+		slog.Info(fmt.Sprintf("azure openai rate_429_errors: %d, processed tokens per minute: %d, provisioned utilization: %.1f%%", metrics.Rate429Errors, metrics.ProcessedTokens, metrics.ProvisionedUtilization))
+	} else {
+		slog.Info(fmt.Sprintf("azure openai rate_429_errors: %d, processed tokens per minute: %d", metrics.Rate429Errors, metrics.ProcessedTokens))
+	}
+
+	return metrics.Rate429Errors, nil
+}
@@ -0,0 +1,86 @@
+package metricsReaders
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/azureCredentials"
+)
+
+func TestGetAzureOpenAIMetrics(t *testing.T) {
+	const openAIResourceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-aoaiscaler/providers/Microsoft.CognitiveServices/accounts/aoai-scaler"
+
+	// the 429s and the usage are read with separate requests, as only AzureOpenAIRequests has
+	// the StatusCode dimension
+	fixtures := map[string]struct {
+		filter  string
+		fixture string
+	}{
+		"AzureOpenAIRequests": {
+			filter:  "StatusCode eq '429' and ModelDeploymentName eq 'text-embedding-3-small'",
+			fixture: "openai_requests_429.json",
+		},
+		"TokenTransaction,AzureOpenAIProvisionedManagedUtilizationV2": {
+			filter:  "ModelDeploymentName eq 'text-embedding-3-small'",
+			fixture: "openai_usage.json",
+		},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != openAIResourceID+"/providers/Microsoft.Insights/metrics" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		f, ok := fixtures[r.URL.Query().Get("metricnames")]
+		if !ok {
+			t.Errorf("Unexpected metricnames %s", r.URL.Query().Get("metricnames"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("$filter") != f.filter {
+			t.Errorf("Expected filter %q, but got %q", f.filter, r.URL.Query().Get("$filter"))
+		}
+		body, err := os.ReadFile(filepath.Join("testdata", f.fixture))
+		if err != nil {
+			t.Errorf("Failed to read fixture %s: %v", f.fixture, err)
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	cloud, err := azureCredentials.GetCloud(azureCredentials.AZURE_PUBLIC_CLOUD, azureCredentials.Cloud{ResourceManagerEndpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	a := NewAzureMetricsReader(AzureMetricsReaderConfig{
+		Rate429Source:         RATE_429_SOURCE_AZURE_OPENAI,
+		OpenAIResourceID:      openAIResourceID,
+		OpenAIDeploymentName:  "text-embedding-3-small",
+		Rate429LookbackWindow: 3 * time.Minute,
+		Credential:            fakeTokenProvider{},
+		Cloud:                 cloud,
+	})
+
+	metrics, err := a.GetAzureOpenAIMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := AzureOpenAIMetrics{Rate429Errors: 3, ProcessedTokens: 3000, ProvisionedUtilization: 71, HasProvisionedUtilization: true}
+	if metrics != expected {
+		t.Errorf("Expected %+v, but got %+v", expected, metrics)
+	}
+
+	rate429Errors, err := a.GetRate429Errors(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if rate429Errors != 3 {
+		t.Errorf("Expected 3 errors, but got %d", rate429Errors)
+	}
+}
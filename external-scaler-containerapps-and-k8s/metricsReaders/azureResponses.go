@@ -0,0 +1,169 @@
+package metricsReaders
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// error bodies are only read to be surfaced in errors, so don't read more than this
+const maxErrorBodyBytes = 64 * 1024
+
+// ResponseError is returned when an Azure API responds with a non success status code. ARM,
+// Log Analytics and Azure Monitor all return errors as {"error": {"code": "", "message": ""}}
+type ResponseError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	// RetryAfter is how long the API asked to wait before retrying, 0 when it did not
+	RetryAfter time.Duration
+}
+
+func (r *ResponseError) Error() string {
+	if r.Code == "" && r.Message == "" {
+		return fmt.Sprintf("request failed with status %d", r.StatusCode)
+	}
+	return fmt.Sprintf("request failed with status %d: %s: %s", r.StatusCode, r.Code, r.Message)
+}
+
+type azureErrorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ServiceBusCountDetails are the message counts of a queue or topic subscription
+type ServiceBusCountDetails struct {
+	ActiveMessageCount             int64 `json:"activeMessageCount"`
+	DeadLetterMessageCount         int64 `json:"deadLetterMessageCount"`
+	ScheduledMessageCount          int64 `json:"scheduledMessageCount"`
+	TransferMessageCount           int64 `json:"transferMessageCount"`
+	TransferDeadLetterMessageCount int64 `json:"transferDeadLetterMessageCount"`
+}
+
+type serviceBusEntityResponse struct {
+	Properties struct {
+		CountDetails *ServiceBusCountDetails `json:"countDetails"`
+	} `json:"properties"`
+}
+
+type logAnalyticsQueryResponse struct {
+	Tables []struct {
+		Name    string `json:"name"`
+		Columns []struct {
+			Name string `json:"name"`
+			Type string `json:"type"`
+		} `json:"columns"`
+		Rows [][]interface{} `json:"rows"`
+	} `json:"tables"`
+}
+
+// checkResponseStatus returns a ResponseError, with the error code and message from the body
+// when there is one, for non 2xx responses
+func checkResponseStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	responseError := &ResponseError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header)}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	if err != nil {
+		return responseError
+	}
+	var errorResponse azureErrorResponse
+	if json.Unmarshal(body, &errorResponse) == nil && (errorResponse.Error.Code != "" || errorResponse.Error.Message != "") {
+		responseError.Code = errorResponse.Error.Code
+		responseError.Message = errorResponse.Error.Message
+	} else {
+		responseError.Message = string(body)
+	}
+
+	return responseError
+}
+
+// parseRetryAfter returns how long a response asks to wait before retrying, from the
+// retry-after-ms and x-ms-retry-after-ms headers of Azure APIs or the standard Retry-After, in
+// seconds or as a date. It returns 0 when there is no valid header
+func parseRetryAfter(header http.Header) time.Duration {
+	for _, name := range []string{"retry-after-ms", "x-ms-retry-after-ms"} {
+		if milliseconds, err := strconv.Atoi(header.Get(name)); err == nil && milliseconds > 0 {
+			return time.Duration(milliseconds) * time.Millisecond
+		}
+	}
+
+	retryAfter := header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// decodeResponse checks the response status and decodes a success response body into v
+func decodeResponse(resp *http.Response, v interface{}) error {
+	if err := checkResponseStatus(resp); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	return nil
+}
+
+func parseServiceBusEntityResponse(resp *http.Response) (ServiceBusCountDetails, error) {
+	var entity serviceBusEntityResponse
+	if err := decodeResponse(resp, &entity); err != nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("could not get service bus entity: %w", err)
+	}
+
+	if entity.Properties.CountDetails == nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("service bus entity response has no countDetails")
+	}
+
+	return *entity.Properties.CountDetails, nil
+}
+
+// parseLogAnalyticsQueryResponse returns the first column of the first row of the first table,
+// which is how scaler queries are expected to return their result
+func parseLogAnalyticsQueryResponse(resp *http.Response) (int, error) {
+	var result logAnalyticsQueryResponse
+	if err := decodeResponse(resp, &result); err != nil {
+		return 0, fmt.Errorf("could not query log analytics workspace: %w", err)
+	}
+
+	if len(result.Tables) == 0 {
+		return 0, fmt.Errorf("log analytics workspace query response has no tables")
+	}
+
+	rows := result.Tables[0].Rows
+	if len(rows) == 0 || len(rows[0]) == 0 || rows[0][0] == nil {
+		// this implies no data exists, e.g. no 429 errors were recorded
+		return 0, nil
+	}
+
+	number, ok := rows[0][0].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("log analytics workspace query result %v is not a number", rows[0][0])
+	}
+	if queryResult, err := number.Int64(); err == nil {
+		return int(queryResult), nil
+	}
+	queryResult, err := number.Float64()
+	if err != nil {
+		return 0, fmt.Errorf("could not parse log analytics workspace query result: %w", err)
+	}
+
+	return int(math.Round(queryResult)), nil
+}
@@ -0,0 +1,174 @@
+package metricsReaders
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// getFixtureResponse serves a recorded response body from testdata with the given status code
+func getFixtureResponse(t *testing.T, statusCode int, fixture string) *http.Response {
+	t.Helper()
+
+	body, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("Failed to read fixture %s: %v", fixture, err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to get fixture %s: %v", fixture, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func TestParseServiceBusEntityResponse(t *testing.T) {
+	resp := getFixtureResponse(t, http.StatusOK, "servicebus_subscription.json")
+
+	countDetails, err := parseServiceBusEntityResponse(resp)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := ServiceBusCountDetails{ActiveMessageCount: 42, DeadLetterMessageCount: 3, ScheduledMessageCount: 12}
+	if countDetails != expected {
+		t.Errorf("Expected %+v, but got %+v", expected, countDetails)
+	}
+}
+
+func TestParseLogAnalyticsQueryResponse(t *testing.T) {
+	testCases := []struct {
+		fixture  string
+		expected int
+	}{
+		{fixture: "loganalytics_query.json", expected: 15},
+		{fixture: "loganalytics_query_null.json", expected: 0},
+		{fixture: "loganalytics_query_real.json", expected: 7},
+	}
+
+	for _, tc := range testCases {
+		result, err := parseLogAnalyticsQueryResponse(getFixtureResponse(t, http.StatusOK, tc.fixture))
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.fixture)
+		}
+		if result != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, result, tc.fixture)
+		}
+	}
+}
+
+func TestParseAzureErrorResponses(t *testing.T) {
+	testCases := []struct {
+		name         string
+		statusCode   int
+		fixture      string
+		parse        func(resp *http.Response) error
+		expectedCode string
+	}{
+		{
+			name:         "service bus forbidden",
+			statusCode:   http.StatusForbidden,
+			fixture:      "arm_authorization_failed.json",
+			parse:        func(resp *http.Response) error { _, err := parseServiceBusEntityResponse(resp); return err },
+			expectedCode: "AuthorizationFailed",
+		},
+		{
+			name:         "service bus entity not found",
+			statusCode:   http.StatusNotFound,
+			fixture:      "arm_entity_not_found.json",
+			parse:        func(resp *http.Response) error { _, err := parseServiceBusEntityResponse(resp); return err },
+			expectedCode: "MessagingGatewayNotFound",
+		},
+		{
+			name:         "service bus entity without count details",
+			statusCode:   http.StatusOK,
+			fixture:      "loganalytics_query.json",
+			parse:        func(resp *http.Response) error { _, err := parseServiceBusEntityResponse(resp); return err },
+			expectedCode: "",
+		},
+		{
+			name:         "log analytics bad query",
+			statusCode:   http.StatusBadRequest,
+			fixture:      "loganalytics_bad_argument.json",
+			parse:        func(resp *http.Response) error { _, err := parseLogAnalyticsQueryResponse(resp); return err },
+			expectedCode: "BadArgumentError",
+		},
+		{
+			name:         "log analytics throttled",
+			statusCode:   http.StatusTooManyRequests,
+			fixture:      "loganalytics_throttled.json",
+			parse:        func(resp *http.Response) error { _, err := parseLogAnalyticsQueryResponse(resp); return err },
+			expectedCode: "ThrottledError",
+		},
+		{
+			name:         "log analytics response without tables",
+			statusCode:   http.StatusOK,
+			fixture:      "servicebus_subscription.json",
+			parse:        func(resp *http.Response) error { _, err := parseLogAnalyticsQueryResponse(resp); return err },
+			expectedCode: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		err := tc.parse(getFixtureResponse(t, tc.statusCode, tc.fixture))
+		if err == nil {
+			t.Errorf("Expected an error (%q)", tc.name)
+			continue
+		}
+
+		var responseError *ResponseError
+		if tc.expectedCode == "" {
+			if errors.As(err, &responseError) {
+				t.Errorf("Expected a parse error, but got %v (%q)", err, tc.name)
+			}
+			continue
+		}
+		if !errors.As(err, &responseError) {
+			t.Errorf("Expected a ResponseError, but got %v (%q)", err, tc.name)
+			continue
+		}
+		if responseError.StatusCode != tc.statusCode || responseError.Code != tc.expectedCode {
+			t.Errorf("Expected status %d code %s, but got status %d code %s (%q)", tc.statusCode, tc.expectedCode, responseError.StatusCode, responseError.Code, tc.name)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		header   http.Header
+		expected time.Duration
+	}{
+		{name: "no header", header: http.Header{}, expected: 0},
+		{name: "seconds", header: http.Header{"Retry-After": {"7"}}, expected: 7 * time.Second},
+		{name: "azure milliseconds take precedence", header: http.Header{"Retry-After": {"7"}, "X-Ms-Retry-After-Ms": {"1500"}}, expected: 1500 * time.Millisecond},
+		{name: "retry-after-ms", header: http.Header{"Retry-After-Ms": {"250"}}, expected: 250 * time.Millisecond},
+		{name: "date in the past", header: http.Header{"Retry-After": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, expected: 0},
+		{name: "invalid", header: http.Header{"Retry-After": {"soon"}}, expected: 0},
+	}
+
+	for _, tc := range testCases {
+		actual := parseRetryAfter(tc.header)
+		if actual != tc.expected {
+			t.Errorf("Expected %v, but got %v (%s)", tc.expected, actual, tc.name)
+		}
+	}
+
+	future := parseRetryAfter(http.Header{"Retry-After": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}})
+	if future <= 50*time.Second || future > time.Minute {
+		t.Errorf("Expected about a minute, but got %v (date in the future)", future)
+	}
+}
@@ -0,0 +1,285 @@
+package metricsReaders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	DEFAULT_HTTP_JSON_TIMEOUT            = 10 * time.Second
+	DEFAULT_HTTP_JSON_MAX_RESPONSE_BYTES = 1024 * 1024
+)
+
+type HTTPJSONMetricsReaderConfig struct {
+	URL string
+	// Method is GET or POST, defaults to GET, or POST when Body is set
+	Method string
+	// Body is sent as application/json with POST requests
+	Body string
+	// Headers are added to every request, e.g. Authorization or X-Api-Key
+	Headers http.Header
+	// QueueLengthPath and Rate429ErrorsPath are JSONPath expressions, e.g. {.queue.depth} or
+	// $.queue.depth, selecting a single number, or numeric string, in the response. A reader
+	// without a path returns an error for that signal
+	QueueLengthPath   string
+	Rate429ErrorsPath string
+	// Timeout limits each request including reading the response, defaults to
+	// DEFAULT_HTTP_JSON_TIMEOUT
+	Timeout time.Duration
+	// MaxResponseBytes fails responses with larger bodies, defaults to
+	// DEFAULT_HTTP_JSON_MAX_RESPONSE_BYTES
+	MaxResponseBytes int64
+	// HTTPClient makes the requests, defaults to a new http.Client
+	HTTPClient *http.Client
+}
+
+type HTTPJSONMetricsReader struct {
+	url               string
+	method            string
+	body              string
+	headers           http.Header
+	queueLengthPath   *httpJSONPath
+	rate429ErrorsPath *httpJSONPath
+	timeout           time.Duration
+	maxResponseBytes  int64
+	httpClient        *http.Client
+}
+
+func NewHTTPJSONMetricsReader(config HTTPJSONMetricsReaderConfig) (*HTTPJSONMetricsReader, error) {
+	// the URL can hold credentials, so it is not included in errors
+	endpoint, err := url.Parse(config.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid HTTP JSON endpoint, expected a URL such as https://service/metrics")
+	}
+
+	method := strings.ToUpper(config.Method)
+	if method == "" && config.Body != "" {
+		method = http.MethodPost
+	}
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != http.MethodGet && method != http.MethodPost {
+		return nil, fmt.Errorf("unsupported HTTP JSON method %q, supported methods are GET and POST", config.Method)
+	}
+	if method == http.MethodGet && config.Body != "" {
+		return nil, fmt.Errorf("a HTTP JSON body can only be sent with POST requests")
+	}
+	if config.QueueLengthPath == "" && config.Rate429ErrorsPath == "" {
+		return nil, fmt.Errorf("a HTTP JSON queue length or rate 429 errors path is required")
+	}
+
+	queueLengthPath, err := parseJSONPath("queueLength", config.QueueLengthPath)
+	if err != nil {
+		return nil, err
+	}
+	rate429ErrorsPath, err := parseJSONPath("rate429Errors", config.Rate429ErrorsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_HTTP_JSON_TIMEOUT
+	}
+	maxResponseBytes := config.MaxResponseBytes
+	if maxResponseBytes <= 0 {
+		maxResponseBytes = DEFAULT_HTTP_JSON_MAX_RESPONSE_BYTES
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	return &HTTPJSONMetricsReader{
+		url:               config.URL,
+		method:            method,
+		body:              config.Body,
+		headers:           config.Headers.Clone(),
+		queueLengthPath:   queueLengthPath,
+		rate429ErrorsPath: rate429ErrorsPath,
+		timeout:           timeout,
+		maxResponseBytes:  maxResponseBytes,
+		httpClient:        httpClient,
+	}, nil
+}
+
+// ParseHTTPHeaders parses newline separated "Name: value" headers, e.g.
+// "X-Api-Key: secret\nX-Tenant: a". Header values are not included in errors
+func ParseHTTPHeaders(lines string) (http.Header, error) {
+	headers := http.Header{}
+	for i, line := range strings.Split(lines, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid HTTP header on line %d, expected Name: value", i+1)
+		}
+		headers.Add(name, strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// httpJSONPath is a parsed JSONPath with its expression, for errors
+type httpJSONPath struct {
+	expression string
+	path       *jsonpath.JSONPath
+}
+
+// parseJSONPath parses a JSONPath expression in the kubectl {.a.b} template syntax, expressions
+// such as $.a.b are wrapped in braces. An empty expression returns nil
+func parseJSONPath(name string, expression string) (*httpJSONPath, error) {
+	if expression == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(expression, "{") {
+		expression = "{" + strings.TrimPrefix(expression, "$") + "}"
+	}
+
+	path := jsonpath.New(name)
+	if err := path.Parse(expression); err != nil {
+		return nil, fmt.Errorf("invalid %s JSONPath %q: %w", name, expression, err)
+	}
+	return &httpJSONPath{expression: expression, path: path}, nil
+}
+
+func (h *HTTPJSONMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	if h.queueLengthPath == nil {
+		return 0, fmt.Errorf("no HTTP JSON queue length path is set")
+	}
+	return h.getMetricValue(ctx, h.queueLengthPath)
+}
+
+func (h *HTTPJSONMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	if h.rate429ErrorsPath == nil {
+		return 0, fmt.Errorf("no HTTP JSON rate 429 errors path is set")
+	}
+	return h.getMetricValue(ctx, h.rate429ErrorsPath)
+}
+
+func (h *HTTPJSONMetricsReader) getMetricValue(ctx context.Context, path *httpJSONPath) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if h.body != "" {
+		body = strings.NewReader(h.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, h.method, h.url, body)
+	if err != nil {
+		return 0, fmt.Errorf("could not create %s request: %w", strings.ToLower(h.method), err)
+	}
+	for name, values := range h.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	if h.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "Go HTTP Client")
+	}
+
+	resp, err := h.httpClient.Do(req)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 0, fmt.Errorf("HTTP JSON request timed out after %v: %w", h.timeout, err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponseStatus(resp); err != nil {
+		return 0, fmt.Errorf("could not get HTTP JSON metrics: %w", err)
+	}
+
+	// one byte more than the limit is read to tell a body at the limit from a larger one
+	responseBody, err := io.ReadAll(io.LimitReader(resp.Body, h.maxResponseBytes+1))
+	if err != nil {
+		return 0, fmt.Errorf("could not read response body: %w", err)
+	}
+	if int64(len(responseBody)) > h.maxResponseBytes {
+		return 0, fmt.Errorf("HTTP JSON response body is larger than %d bytes", h.maxResponseBytes)
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(responseBody))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return 0, fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	value, err := findJSONPathNumber(path, document)
+	if err != nil {
+		return 0, err
+	}
+	slog.Debug(fmt.Sprintf("HTTP JSON metric value: %v", value))
+	return int(math.Round(value)), nil
+}
+
+// findJSONPathNumber returns the single number selected by path, numeric strings such as
+// "12" are accepted as some services quote their counters. NaN, infinities and numbers out of
+// the int range are errors, as they don't convert to an int
+func findJSONPathNumber(path *httpJSONPath, document interface{}) (float64, error) {
+	results, err := path.path.FindResults(document)
+	if err != nil {
+		return 0, fmt.Errorf("could not find JSONPath %s in response: %w", path.expression, err)
+	}
+
+	var values []interface{}
+	for _, result := range results {
+		for _, value := range result {
+			values = append(values, value.Interface())
+		}
+	}
+	if len(values) != 1 {
+		return 0, fmt.Errorf("JSONPath %s selected %d values in response, expected 1", path.expression, len(values))
+	}
+
+	var number float64
+	switch value := values[0].(type) {
+	case json.Number:
+		number, err = value.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("JSONPath %s value %s is out of range", path.expression, value)
+		}
+	case string:
+		number, err = strconv.ParseFloat(value, 64)
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("JSONPath %s value %q is out of range", path.expression, value)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("JSONPath %s value %q is not a number", path.expression, value)
+		}
+	case nil:
+		// a null counter implies no data, like an empty log analytics result
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("JSONPath %s value %v is not a number", path.expression, value)
+	}
+
+	if math.IsNaN(number) {
+		return 0, fmt.Errorf("JSONPath %s value is not a number", path.expression)
+	}
+	// float64(math.MaxInt) rounds up to a power of two, which is out of the int range
+	if rounded := math.Round(number); math.IsInf(number, 0) || rounded >= float64(math.MaxInt) || rounded < float64(math.MinInt) {
+		return 0, fmt.Errorf("JSONPath %s value %v is out of range", path.expression, number)
+	}
+	return number, nil
+}
@@ -0,0 +1,233 @@
+package metricsReaders
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeHTTPJSONServer serves the http_json_metrics.json fixture to requests with the API key
+// header, and to POST requests only when they send the expected body. /slow responds after the
+// test is done and /large responds with a body over 1KiB
+func newFakeHTTPJSONServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"Unauthorized","message":"missing api key"}}`))
+			return
+		}
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			if r.Header.Get("Content-Type") != "application/json" || string(body) != `{"queue":"embeddings"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+
+		switch r.URL.Path {
+		case "/slow":
+			<-done
+			return
+		case "/large":
+			_, _ = w.Write([]byte(`{"queue":{"depth":1},"padding":"` + strings.Repeat("x", 2048) + `"}`))
+			return
+		}
+
+		body, err := os.ReadFile(filepath.Join("testdata", "http_json_metrics.json"))
+		if err != nil {
+			t.Errorf("Failed to read fixture: %v", err)
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(func() {
+		close(done)
+		server.Close()
+	})
+
+	return server
+}
+
+func TestHTTPJSONMetricsReader(t *testing.T) {
+	server := newFakeHTTPJSONServer(t)
+	headers := http.Header{"X-Api-Key": []string{"secret"}}
+
+	testCases := []struct {
+		name               string
+		config             HTTPJSONMetricsReaderConfig
+		rate429Errors      bool
+		expected           int
+		expectedError      string
+		expectedStatusCode int
+	}{
+		{
+			name:     "queue length",
+			config:   HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.queue.depth}"},
+			expected: 18,
+		},
+		{
+			name:          "numeric string with a $ path",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, Rate429ErrorsPath: "$.throttling.requests429"},
+			rate429Errors: true,
+			expected:      4,
+		},
+		{
+			name:     "filtered array element",
+			config:   HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.partitions[?(@.name==\"b\")].depth}"},
+			expected: 8,
+		},
+		{
+			name:     "null value",
+			config:   HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.lastError}"},
+			expected: 0,
+		},
+		{
+			name:     "post with body",
+			config:   HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, Body: `{"queue":"embeddings"}`, QueueLengthPath: "{.queue.inFlight}"},
+			expected: 4,
+		},
+		{
+			name:               "missing api key",
+			config:             HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", QueueLengthPath: "{.queue.depth}"},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:          "missing key",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.queue.backlog}"},
+			expectedError: "backlog is not found",
+		},
+		{
+			name:          "multiple values",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.partitions[*].depth}"},
+			expectedError: "selected 2 values",
+		},
+		{
+			name:          "not a number",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.service}"},
+			expectedError: "is not a number",
+		},
+		{
+			name:          "NaN string",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.invalid.nan}"},
+			expectedError: "is not a number",
+		},
+		{
+			name:          "infinity string",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.invalid.inf}"},
+			expectedError: "is out of range",
+		},
+		{
+			name:          "negative infinity string",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.invalid.negativeInfinity}"},
+			expectedError: "is out of range",
+		},
+		{
+			name:          "number overflowing float64",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.invalid.overflowingNumber}"},
+			expectedError: "is out of range",
+		},
+		{
+			name:          "string overflowing float64",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.invalid.overflowingString}"},
+			expectedError: "is out of range",
+		},
+		{
+			name:          "number out of the int range",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.invalid.outOfIntRange}"},
+			expectedError: "is out of range",
+		},
+		{
+			name:          "path not set",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/metrics", Headers: headers, QueueLengthPath: "{.queue.depth}"},
+			rate429Errors: true,
+			expectedError: "no HTTP JSON rate 429 errors path",
+		},
+		{
+			name:          "response too large",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/large", Headers: headers, QueueLengthPath: "{.queue.depth}", MaxResponseBytes: 1024},
+			expectedError: "larger than 1024 bytes",
+		},
+		{
+			name:          "timeout",
+			config:        HTTPJSONMetricsReaderConfig{URL: server.URL + "/slow", Headers: headers, QueueLengthPath: "{.queue.depth}", Timeout: 50 * time.Millisecond},
+			expectedError: "timed out after 50ms",
+		},
+	}
+
+	for _, tc := range testCases {
+		reader, err := NewHTTPJSONMetricsReader(tc.config)
+		if err != nil {
+			t.Fatalf("Failed to create reader: %v (%s)", err, tc.name)
+		}
+
+		var result int
+		if tc.rate429Errors {
+			result, err = reader.GetRate429Errors(context.Background())
+		} else {
+			result, err = reader.GetQueueLength(context.Background())
+		}
+
+		if tc.expectedStatusCode != 0 {
+			var responseError *ResponseError
+			if !errors.As(err, &responseError) || responseError.StatusCode != tc.expectedStatusCode {
+				t.Errorf("Expected a ResponseError with status %d, but got %v (%s)", tc.expectedStatusCode, err, tc.name)
+			}
+			continue
+		}
+		if tc.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected error containing %q, but got %v (%s)", tc.expectedError, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, result, tc.name)
+		}
+	}
+}
+
+func TestNewHTTPJSONMetricsReaderValidatesConfig(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config HTTPJSONMetricsReaderConfig
+	}{
+		{name: "not a http url", config: HTTPJSONMetricsReaderConfig{URL: "ftp://service/metrics", QueueLengthPath: "{.depth}"}},
+		{name: "unsupported method", config: HTTPJSONMetricsReaderConfig{URL: "http://service/metrics", Method: "DELETE", QueueLengthPath: "{.depth}"}},
+		{name: "body with get", config: HTTPJSONMetricsReaderConfig{URL: "http://service/metrics", Method: "GET", Body: "{}", QueueLengthPath: "{.depth}"}},
+		{name: "no paths", config: HTTPJSONMetricsReaderConfig{URL: "http://service/metrics"}},
+		{name: "invalid path", config: HTTPJSONMetricsReaderConfig{URL: "http://service/metrics", QueueLengthPath: "{.depth[}"}},
+	}
+
+	for _, tc := range testCases {
+		if _, err := NewHTTPJSONMetricsReader(tc.config); err == nil {
+			t.Errorf("Expected an error (%s)", tc.name)
+		}
+	}
+}
+
+func TestParseHTTPHeaders(t *testing.T) {
+	headers, err := ParseHTTPHeaders("X-Api-Key: secret\n\n  X-Tenant:a:b  \nX-Tenant: c")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if headers.Get("X-Api-Key") != "secret" || strings.Join(headers.Values("X-Tenant"), ",") != "a:b,c" {
+		t.Errorf("Unexpected headers %v", headers)
+	}
+
+	if _, err := ParseHTTPHeaders("X-Api-Key secret"); err == nil || strings.Contains(err.Error(), "secret") {
+		t.Errorf("Expected an error without the header value, but got %v", err)
+	}
+}
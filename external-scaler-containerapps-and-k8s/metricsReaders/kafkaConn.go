@@ -0,0 +1,333 @@
+package metricsReaders
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	KAFKA_SASL_MECHANISM_PLAIN         = "PLAIN"
+	KAFKA_SASL_MECHANISM_SCRAM_SHA_256 = "SCRAM-SHA-256"
+	KAFKA_SASL_MECHANISM_SCRAM_SHA_512 = "SCRAM-SHA-512"
+
+	kafkaApiKeyListOffsets   int16 = 2
+	kafkaApiKeyOffsetFetch   int16 = 9
+	kafkaApiKeySaslHandshake int16 = 17
+	kafkaApiKeyApiVersions   int16 = 18
+
+	// ListOffsets timestamps asking for the latest and earliest offsets of a partition
+	kafkaLatestOffsetTimestamp   int64 = -1
+	kafkaEarliestOffsetTimestamp int64 = -2
+
+	kafkaErrorUnsupportedVersion int16 = 35
+
+	// responses larger than this are treated as a protocol error rather than allocated
+	kafkaMaxResponseBytes = 64 * 1024 * 1024
+
+	kafkaClientSoftwareVersion = "1.0.0"
+)
+
+// kafkaMinVersions are the oldest request versions the lag can be read with, other requests
+// can use any version the broker supports
+var kafkaMinVersions = map[int16]int16{
+	// v0 returns a list of offsets instead of the offset
+	kafkaApiKeyListOffsets: 1,
+	// v0 reads the offsets committed to ZooKeeper
+	kafkaApiKeyOffsetFetch: 1,
+	// v0 sends the SASL messages without SaslAuthenticate framing
+	kafkaApiKeySaslHandshake: 1,
+}
+
+var kafkaErrorNames = map[int16]string{
+	1:  "OFFSET_OUT_OF_RANGE",
+	3:  "UNKNOWN_TOPIC_OR_PARTITION",
+	5:  "LEADER_NOT_AVAILABLE",
+	6:  "NOT_LEADER_OR_FOLLOWER",
+	7:  "REQUEST_TIMED_OUT",
+	14: "COORDINATOR_LOAD_IN_PROGRESS",
+	15: "COORDINATOR_NOT_AVAILABLE",
+	16: "NOT_COORDINATOR",
+	29: "TOPIC_AUTHORIZATION_FAILED",
+	30: "GROUP_AUTHORIZATION_FAILED",
+	33: "UNSUPPORTED_SASL_MECHANISM",
+	34: "ILLEGAL_SASL_STATE",
+	35: "UNSUPPORTED_VERSION",
+	58: "SASL_AUTHENTICATION_FAILED",
+}
+
+// KafkaError is an error code returned by a broker
+type KafkaError struct {
+	Code int16
+}
+
+func (k *KafkaError) Error() string {
+	if name, ok := kafkaErrorNames[k.Code]; ok {
+		return fmt.Sprintf("kafka error %d: %s", k.Code, name)
+	}
+	return fmt.Sprintf("kafka error %d", k.Code)
+}
+
+func kafkaErrorFromCode(code int16) error {
+	if code == 0 {
+		return nil
+	}
+	return &KafkaError{Code: code}
+}
+
+// kafkaConn is a connection to a single broker. It implements kmsg.Requestor, requests are
+// made one at a time, at the highest version both kmsg and the broker support
+type kafkaConn struct {
+	conn          net.Conn
+	formatter     *kmsg.RequestFormatter
+	timeout       time.Duration
+	correlationID int32
+	// versions are the broker's supported versions by api key, from ApiVersions
+	versions map[int16]kmsg.ApiVersionsResponseApiKey
+}
+
+// newKafkaConn negotiates the request versions on netConn, and runs the SASL exchange when
+// saslMechanism is set
+func newKafkaConn(ctx context.Context, netConn net.Conn, timeout time.Duration, saslMechanism string, username string, password string) (*kafkaConn, error) {
+	c := &kafkaConn{
+		conn:      netConn,
+		formatter: kmsg.NewRequestFormatter(kmsg.FormatterClientID(kafkaClientID)),
+		timeout:   timeout,
+	}
+	if err := c.apiVersions(ctx); err != nil {
+		return nil, err
+	}
+	if saslMechanism != "" {
+		if err := c.authenticate(ctx, saslMechanism, username, password); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *kafkaConn) Close() error {
+	return c.conn.Close()
+}
+
+// apiVersions reads the request versions the broker supports, retrying with v0 when the broker
+// does not support the latest ApiVersions version
+func (c *kafkaConn) apiVersions(ctx context.Context) error {
+	request := kmsg.NewPtrApiVersionsRequest()
+	request.Version = request.MaxVersion()
+	request.ClientSoftwareName = kafkaClientID
+	request.ClientSoftwareVersion = kafkaClientSoftwareVersion
+
+	response, err := request.RequestWith(ctx, c)
+	var kafkaError *KafkaError
+	if errors.As(err, &kafkaError) && kafkaError.Code == kafkaErrorUnsupportedVersion {
+		request.Version = 0
+		response, err = request.RequestWith(ctx, c)
+	}
+	if err == nil {
+		err = kafkaErrorFromCode(response.ErrorCode)
+	}
+	if err != nil {
+		return fmt.Errorf("could not get the kafka broker's api versions: %w", err)
+	}
+
+	c.versions = map[int16]kmsg.ApiVersionsResponseApiKey{}
+	for _, apiKey := range response.ApiKeys {
+		c.versions[apiKey.ApiKey] = apiKey
+	}
+	return nil
+}
+
+// negotiateVersion sets the request to the highest version both kmsg and the broker support
+func (c *kafkaConn) negotiateVersion(request kmsg.Request) error {
+	brokerVersions, ok := c.versions[request.Key()]
+	if !ok {
+		return fmt.Errorf("the kafka broker does not support %s: %w", kmsg.NameForKey(request.Key()), &KafkaError{Code: kafkaErrorUnsupportedVersion})
+	}
+	minVersion := max(brokerVersions.MinVersion, kafkaMinVersions[request.Key()])
+	maxVersion := min(brokerVersions.MaxVersion, request.MaxVersion())
+	if maxVersion < minVersion {
+		return fmt.Errorf("the kafka broker supports %s v%d to v%d, the scaler v%d to v%d: %w", kmsg.NameForKey(request.Key()), brokerVersions.MinVersion, brokerVersions.MaxVersion, kafkaMinVersions[request.Key()], request.MaxVersion(), &KafkaError{Code: kafkaErrorUnsupportedVersion})
+	}
+	request.SetVersion(maxVersion)
+	return nil
+}
+
+// Request sends a request and reads its response, within the connection's timeout and ctx's
+// deadline
+func (c *kafkaConn) Request(ctx context.Context, request kmsg.Request) (kmsg.Response, error) {
+	// ApiVersions is sent before the versions are known, at the version set by the caller
+	if request.Key() != kafkaApiKeyApiVersions {
+		if err := c.negotiateVersion(request); err != nil {
+			return nil, err
+		}
+	}
+
+	c.correlationID++
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	name := kmsg.NameForKey(request.Key())
+	if _, err := c.conn.Write(c.formatter.AppendRequest(nil, request, c.correlationID)); err != nil {
+		return nil, fmt.Errorf("could not send kafka %s request: %w", name, err)
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(c.conn, size[:]); err != nil {
+		return nil, fmt.Errorf("could not read kafka %s response: %w", name, err)
+	}
+	responseSize := int32(binary.BigEndian.Uint32(size[:]))
+	if responseSize < 4 || responseSize > kafkaMaxResponseBytes {
+		return nil, fmt.Errorf("invalid kafka %s response size %d", name, responseSize)
+	}
+	body := make([]byte, responseSize)
+	if _, err := io.ReadFull(c.conn, body); err != nil {
+		return nil, fmt.Errorf("could not read kafka %s response: %w", name, err)
+	}
+
+	if correlationID := int32(binary.BigEndian.Uint32(body)); correlationID != c.correlationID {
+		return nil, fmt.Errorf("kafka response correlation ID %d does not match request %d", correlationID, c.correlationID)
+	}
+	body = body[4:]
+	// brokers answer ApiVersions versions they don't support with a v0 body
+	if request.Key() == kafkaApiKeyApiVersions && request.GetVersion() > 0 && len(body) >= 2 && int16(binary.BigEndian.Uint16(body)) == kafkaErrorUnsupportedVersion {
+		return nil, &KafkaError{Code: kafkaErrorUnsupportedVersion}
+	}
+	// flexible response headers end with tagged fields, except ApiVersions', whose header stays
+	// v0 so that brokers can answer versions they don't support
+	if request.IsFlexible() && request.Key() != kafkaApiKeyApiVersions {
+		tags := &kafkaTagReader{buf: body}
+		kmsg.SkipTags(tags)
+		if tags.truncated {
+			return nil, fmt.Errorf("kafka %s response is truncated", name)
+		}
+		body = tags.buf
+	}
+
+	response := request.ResponseKind()
+	response.SetVersion(request.GetVersion())
+	if err := response.ReadFrom(body); err != nil {
+		return nil, fmt.Errorf("could not decode kafka %s response: %w", name, err)
+	}
+	return response, nil
+}
+
+// kafkaTagReader is the kmsg.TagReader of a response header
+type kafkaTagReader struct {
+	buf       []byte
+	truncated bool
+}
+
+func (r *kafkaTagReader) Uvarint() uint32 {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 || v > uint64(^uint32(0)) {
+		r.truncated = true
+		r.buf = nil
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return uint32(v)
+}
+
+func (r *kafkaTagReader) Span(n int) []byte {
+	if n < 0 || n > len(r.buf) {
+		r.truncated = true
+		r.buf = nil
+		return nil
+	}
+	span := r.buf[:n]
+	r.buf = r.buf[n:]
+	return span
+}
+
+// kafkaSASLStep returns the client message answering a broker challenge, starting with an
+// empty challenge, and whether the exchange is done
+type kafkaSASLStep func(challenge string) (response string, done bool, err error)
+
+func newKafkaSASLStep(mechanism string, username string, password string) (kafkaSASLStep, error) {
+	var hash scram.HashGeneratorFcn
+	switch mechanism {
+	case KAFKA_SASL_MECHANISM_PLAIN:
+		sent := false
+		return func(challenge string) (string, bool, error) {
+			if sent {
+				return "", true, nil
+			}
+			sent = true
+			return "\x00" + username + "\x00" + password, false, nil
+		}, nil
+	case KAFKA_SASL_MECHANISM_SCRAM_SHA_256:
+		hash = scram.SHA256
+	case KAFKA_SASL_MECHANISM_SCRAM_SHA_512:
+		hash = scram.SHA512
+	default:
+		return nil, fmt.Errorf("unsupported kafka SASL mechanism %q, supported mechanisms are %s, %s and %s", mechanism, KAFKA_SASL_MECHANISM_PLAIN, KAFKA_SASL_MECHANISM_SCRAM_SHA_256, KAFKA_SASL_MECHANISM_SCRAM_SHA_512)
+	}
+
+	client, err := hash.NewClient(username, password, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka SASL credentials: %w", err)
+	}
+	conversation := client.NewConversation()
+	return func(challenge string) (string, bool, error) {
+		response, err := conversation.Step(challenge)
+		if err != nil {
+			return "", false, fmt.Errorf("SCRAM authentication failed: %w", err)
+		}
+		return response, conversation.Done(), nil
+	}, nil
+}
+
+// authenticate runs the SASL exchange, see https://kafka.apache.org/protocol#sasl_handshake
+func (c *kafkaConn) authenticate(ctx context.Context, mechanism string, username string, password string) error {
+	step, err := newKafkaSASLStep(mechanism, username, password)
+	if err != nil {
+		return err
+	}
+
+	handshake := kmsg.NewPtrSASLHandshakeRequest()
+	handshake.Mechanism = mechanism
+	handshakeResponse, err := handshake.RequestWith(ctx, c)
+	if err != nil {
+		return err
+	}
+	if err := kafkaErrorFromCode(handshakeResponse.ErrorCode); err != nil {
+		return fmt.Errorf("SASL mechanism %s is not enabled, the broker supports %v: %w", mechanism, handshakeResponse.SupportedMechanisms, err)
+	}
+
+	var challenge string
+	for {
+		message, done, err := step(challenge)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		authenticate := kmsg.NewPtrSASLAuthenticateRequest()
+		authenticate.SASLAuthBytes = []byte(message)
+		response, err := authenticate.RequestWith(ctx, c)
+		if err != nil {
+			return err
+		}
+		if err := kafkaErrorFromCode(response.ErrorCode); err != nil {
+			if response.ErrorMessage != nil {
+				return fmt.Errorf("SASL authentication failed: %s: %w", *response.ErrorMessage, err)
+			}
+			return fmt.Errorf("SASL authentication failed: %w", err)
+		}
+		challenge = string(response.SASLAuthBytes)
+	}
+}
@@ -0,0 +1,445 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+const (
+	KAFKA_LAG_AGGREGATION_TOTAL         = "total"
+	KAFKA_LAG_AGGREGATION_MAX_PARTITION = "maxPartition"
+
+	// KAFKA_OFFSET_RESET_LATEST and KAFKA_OFFSET_RESET_EARLIEST match the consumers'
+	// auto.offset.reset, which decides the lag of partitions the group has not committed to
+	KAFKA_OFFSET_RESET_LATEST   = "latest"
+	KAFKA_OFFSET_RESET_EARLIEST = "earliest"
+
+	DEFAULT_KAFKA_TIMEOUT = 10 * time.Second
+
+	kafkaClientID = "keda-queue-length-error-rate-scaler"
+)
+
+type KafkaMetricsReaderConfig struct {
+	// BootstrapServers are host:port addresses of brokers, the first reachable one is used to
+	// discover the topic's partition leaders and the group's coordinator
+	BootstrapServers []string
+	Topic            string
+	ConsumerGroup    string
+	// LagAggregation is KAFKA_LAG_AGGREGATION_TOTAL (the default), the sum of the lag of all
+	// partitions, or KAFKA_LAG_AGGREGATION_MAX_PARTITION, the lag of the furthest behind partition
+	LagAggregation string
+	// OffsetResetPolicy is KAFKA_OFFSET_RESET_LATEST (the default), for which partitions without
+	// a committed offset have no lag, or KAFKA_OFFSET_RESET_EARLIEST, for which all their
+	// messages are lag
+	OffsetResetPolicy string
+
+	// SASLMechanism is one of the KAFKA_SASL_MECHANISM_ constants, or empty for no SASL
+	SASLMechanism string
+	Username      string
+	Password      string
+	// TLSConfig enables TLS when set
+	TLSConfig *tls.Config
+	// Timeout applies to connecting and to each request, defaults to DEFAULT_KAFKA_TIMEOUT
+	Timeout time.Duration
+}
+
+type KafkaMetricsReader struct {
+	bootstrapServers  []string
+	topic             string
+	consumerGroup     string
+	lagAggregation    string
+	offsetResetPolicy string
+	saslMechanism     string
+	username          string
+	password          string
+	tlsConfig         *tls.Config
+	timeout           time.Duration
+}
+
+func NewKafkaMetricsReader(config KafkaMetricsReaderConfig) (*KafkaMetricsReader, error) {
+	if len(config.BootstrapServers) == 0 || config.Topic == "" || config.ConsumerGroup == "" {
+		return nil, fmt.Errorf("kafka bootstrap servers, topic and consumer group are required")
+	}
+
+	lagAggregation := config.LagAggregation
+	if lagAggregation == "" {
+		lagAggregation = KAFKA_LAG_AGGREGATION_TOTAL
+	}
+	if lagAggregation != KAFKA_LAG_AGGREGATION_TOTAL && lagAggregation != KAFKA_LAG_AGGREGATION_MAX_PARTITION {
+		return nil, fmt.Errorf("unsupported kafka lag aggregation %q, supported aggregations are %s and %s", lagAggregation, KAFKA_LAG_AGGREGATION_TOTAL, KAFKA_LAG_AGGREGATION_MAX_PARTITION)
+	}
+	offsetResetPolicy := config.OffsetResetPolicy
+	if offsetResetPolicy == "" {
+		offsetResetPolicy = KAFKA_OFFSET_RESET_LATEST
+	}
+	if offsetResetPolicy != KAFKA_OFFSET_RESET_LATEST && offsetResetPolicy != KAFKA_OFFSET_RESET_EARLIEST {
+		return nil, fmt.Errorf("unsupported kafka offset reset policy %q, supported policies are %s and %s", offsetResetPolicy, KAFKA_OFFSET_RESET_LATEST, KAFKA_OFFSET_RESET_EARLIEST)
+	}
+	if config.SASLMechanism != "" {
+		if _, err := newKafkaSASLStep(config.SASLMechanism, config.Username, config.Password); err != nil {
+			return nil, err
+		}
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_KAFKA_TIMEOUT
+	}
+
+	return &KafkaMetricsReader{
+		bootstrapServers:  config.BootstrapServers,
+		topic:             config.Topic,
+		consumerGroup:     config.ConsumerGroup,
+		lagAggregation:    lagAggregation,
+		offsetResetPolicy: offsetResetPolicy,
+		saslMechanism:     config.SASLMechanism,
+		username:          config.Username,
+		password:          config.Password,
+		tlsConfig:         config.TLSConfig,
+		timeout:           timeout,
+	}, nil
+}
+
+// kafkaConns holds the connections made while reading the lag, by broker address
+type kafkaConns map[string]*kafkaConn
+
+func (c kafkaConns) closeAll() {
+	for _, conn := range c {
+		conn.Close()
+	}
+}
+
+// getConn returns a connection to address, connecting and authenticating on first use
+func (k *KafkaMetricsReader) getConn(ctx context.Context, conns kafkaConns, address string) (*kafkaConn, error) {
+	if conn, ok := conns[address]; ok {
+		return conn, nil
+	}
+
+	dialer := &net.Dialer{Timeout: k.timeout}
+	var netConn net.Conn
+	var err error
+	if k.tlsConfig != nil {
+		netConn, err = (&tls.Dialer{NetDialer: dialer, Config: k.tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to kafka broker %s: %w", address, err)
+	}
+
+	conn, err := newKafkaConn(ctx, netConn, k.timeout, k.saslMechanism, k.username, k.password)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("could not set up the connection to kafka broker %s: %w", address, err)
+	}
+
+	conns[address] = conn
+	return conn, nil
+}
+
+func (k *KafkaMetricsReader) getBootstrapConn(ctx context.Context, conns kafkaConns) (*kafkaConn, error) {
+	var errs []error
+	for _, address := range k.bootstrapServers {
+		conn, err := k.getConn(ctx, conns, address)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// getTopicMetadata returns the addresses of the brokers by node ID, and the partitions of the topic
+func (k *KafkaMetricsReader) getTopicMetadata(ctx context.Context, conn *kafkaConn) (map[int32]string, []kmsg.MetadataResponseTopicPartition, error) {
+	request := kmsg.NewPtrMetadataRequest()
+	topic := kmsg.NewMetadataRequestTopic()
+	topic.Topic = kmsg.StringPtr(k.topic)
+	request.Topics = append(request.Topics, topic)
+
+	response, err := request.RequestWith(ctx, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	brokers := map[int32]string{}
+	for _, broker := range response.Brokers {
+		brokers[broker.NodeID] = net.JoinHostPort(broker.Host, strconv.Itoa(int(broker.Port)))
+	}
+	for _, topic := range response.Topics {
+		if topic.Topic == nil || *topic.Topic != k.topic {
+			continue
+		}
+		if err := kafkaErrorFromCode(topic.ErrorCode); err != nil {
+			return nil, nil, fmt.Errorf("could not get metadata of topic %s: %w", k.topic, err)
+		}
+		return brokers, topic.Partitions, nil
+	}
+	return nil, nil, fmt.Errorf("kafka topic %s is not in the metadata", k.topic)
+}
+
+// getCoordinator returns the address of the broker coordinating the consumer group
+func (k *KafkaMetricsReader) getCoordinator(ctx context.Context, conn *kafkaConn) (string, error) {
+	// v4 and later look up a batch of groups, earlier versions a single one
+	request := kmsg.NewPtrFindCoordinatorRequest()
+	request.CoordinatorKey = k.consumerGroup
+	request.CoordinatorKeys = []string{k.consumerGroup}
+
+	response, err := request.RequestWith(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+
+	errorCode, host, port := response.ErrorCode, response.Host, response.Port
+	for _, coordinator := range response.Coordinators {
+		if coordinator.Key == k.consumerGroup {
+			errorCode, host, port = coordinator.ErrorCode, coordinator.Host, coordinator.Port
+		}
+	}
+	if err := kafkaErrorFromCode(errorCode); err != nil {
+		return "", fmt.Errorf("could not find coordinator of group %s: %w", k.consumerGroup, err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+}
+
+// getCommittedOffsets returns the group's committed offsets of the topic's partitions, -1 for
+// partitions without a committed offset
+func (k *KafkaMetricsReader) getCommittedOffsets(ctx context.Context, conn *kafkaConn, partitions []int32) (map[int32]int64, error) {
+	// v8 and later fetch the offsets of a batch of groups, earlier versions of a single one
+	request := kmsg.NewPtrOffsetFetchRequest()
+	request.Group = k.consumerGroup
+	topic := kmsg.NewOffsetFetchRequestTopic()
+	topic.Topic = k.topic
+	topic.Partitions = partitions
+	request.Topics = append(request.Topics, topic)
+	group := kmsg.NewOffsetFetchRequestGroup()
+	group.Group = k.consumerGroup
+	groupTopic := kmsg.NewOffsetFetchRequestGroupTopic()
+	groupTopic.Topic = k.topic
+	groupTopic.Partitions = partitions
+	group.Topics = append(group.Topics, groupTopic)
+	request.Groups = append(request.Groups, group)
+
+	response, err := request.RequestWith(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	errorCode := response.ErrorCode
+	offsets := map[int32]int64{}
+	var partitionErr error
+	addOffset := func(partition int32, offset int64, partitionErrorCode int16) {
+		if err := kafkaErrorFromCode(partitionErrorCode); err != nil && partitionErr == nil {
+			partitionErr = fmt.Errorf("could not fetch committed offset of partition %d: %w", partition, err)
+		}
+		offsets[partition] = offset
+	}
+	for _, topic := range response.Topics {
+		for _, partition := range topic.Partitions {
+			addOffset(partition.Partition, partition.Offset, partition.ErrorCode)
+		}
+	}
+	for _, group := range response.Groups {
+		if group.Group != k.consumerGroup {
+			continue
+		}
+		errorCode = group.ErrorCode
+		for _, topic := range group.Topics {
+			for _, partition := range topic.Partitions {
+				addOffset(partition.Partition, partition.Offset, partition.ErrorCode)
+			}
+		}
+	}
+	if err := kafkaErrorFromCode(errorCode); err != nil {
+		return nil, fmt.Errorf("could not fetch committed offsets of group %s: %w", k.consumerGroup, err)
+	}
+	if partitionErr != nil {
+		return nil, partitionErr
+	}
+
+	return offsets, nil
+}
+
+// listOffsets returns the offsets at timestamp of the topic's partitions led by the broker
+func (k *KafkaMetricsReader) listOffsets(ctx context.Context, conn *kafkaConn, partitions []int32, timestamp int64) (map[int32]int64, error) {
+	request := kmsg.NewPtrListOffsetsRequest()
+	topic := kmsg.NewListOffsetsRequestTopic()
+	topic.Topic = k.topic
+	for _, partition := range partitions {
+		requestPartition := kmsg.NewListOffsetsRequestTopicPartition()
+		requestPartition.Partition = partition
+		requestPartition.Timestamp = timestamp
+		topic.Partitions = append(topic.Partitions, requestPartition)
+	}
+	request.Topics = append(request.Topics, topic)
+
+	response, err := request.RequestWith(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := map[int32]int64{}
+	for _, topic := range response.Topics {
+		for _, partition := range topic.Partitions {
+			if err := kafkaErrorFromCode(partition.ErrorCode); err != nil {
+				return nil, fmt.Errorf("could not list offsets of partition %d: %w", partition.Partition, err)
+			}
+			offsets[partition.Partition] = partition.Offset
+		}
+	}
+
+	return offsets, nil
+}
+
+// GetPartitionLags returns the consumer group's lag on each partition of the topic
+func (k *KafkaMetricsReader) GetPartitionLags(ctx context.Context) (map[int32]int64, error) {
+	conns := kafkaConns{}
+	defer conns.closeAll()
+
+	bootstrapConn, err := k.getBootstrapConn(ctx, conns)
+	if err != nil {
+		return nil, err
+	}
+
+	brokers, partitions, err := k.getTopicMetadata(ctx, bootstrapConn)
+	if err != nil {
+		return nil, err
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("kafka topic %s has no partitions", k.topic)
+	}
+
+	// offsets are listed by each partition's leader
+	partitionsByLeader := map[int32][]int32{}
+	var partitionIDs []int32
+	for _, partition := range partitions {
+		if partition.Leader < 0 {
+			errorCode := partition.ErrorCode
+			if errorCode == 0 {
+				errorCode = 5 // LEADER_NOT_AVAILABLE
+			}
+			return nil, fmt.Errorf("kafka partition %d of topic %s has no leader: %w", partition.Partition, k.topic, &KafkaError{Code: errorCode})
+		}
+		partitionsByLeader[partition.Leader] = append(partitionsByLeader[partition.Leader], partition.Partition)
+		partitionIDs = append(partitionIDs, partition.Partition)
+	}
+
+	coordinator, err := k.getCoordinator(ctx, bootstrapConn)
+	if err != nil {
+		return nil, err
+	}
+	coordinatorConn, err := k.getConn(ctx, conns, coordinator)
+	if err != nil {
+		return nil, err
+	}
+	committedOffsets, err := k.getCommittedOffsets(ctx, coordinatorConn, partitionIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	latestOffsets := map[int32]int64{}
+	earliestOffsets := map[int32]int64{}
+	for leader, leaderPartitions := range partitionsByLeader {
+		address, ok := brokers[leader]
+		if !ok {
+			return nil, fmt.Errorf("kafka partition leader %d is not in the topic metadata", leader)
+		}
+		leaderConn, err := k.getConn(ctx, conns, address)
+		if err != nil {
+			return nil, err
+		}
+
+		offsets, err := k.listOffsets(ctx, leaderConn, leaderPartitions, kafkaLatestOffsetTimestamp)
+		if err != nil {
+			return nil, err
+		}
+		for partition, offset := range offsets {
+			latestOffsets[partition] = offset
+		}
+
+		if k.offsetResetPolicy == KAFKA_OFFSET_RESET_EARLIEST {
+			offsets, err := k.listOffsets(ctx, leaderConn, leaderPartitions, kafkaEarliestOffsetTimestamp)
+			if err != nil {
+				return nil, err
+			}
+			for partition, offset := range offsets {
+				earliestOffsets[partition] = offset
+			}
+		}
+	}
+
+	lags := map[int32]int64{}
+	for _, partition := range partitionIDs {
+		latestOffset, ok := latestOffsets[partition]
+		if !ok {
+			return nil, fmt.Errorf("no latest offset for kafka partition %d of topic %s", partition, k.topic)
+		}
+
+		committedOffset, ok := committedOffsets[partition]
+		if !ok || committedOffset < 0 {
+			// the group has not committed to the partition, its consumers start from the
+			// offset reset policy
+			if k.offsetResetPolicy == KAFKA_OFFSET_RESET_EARLIEST {
+				committedOffset = earliestOffsets[partition]
+			} else {
+				committedOffset = latestOffset
+			}
+		}
+
+		lag := latestOffset - committedOffset
+		if lag < 0 {
+			// committed offsets can be ahead of the listed offsets while leadership moves
+			lag = 0
+		}
+		lags[partition] = lag
+	}
+
+	return lags, nil
+}
+
+func (k *KafkaMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	lags, err := k.GetPartitionLags(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	partitions := make([]int32, 0, len(lags))
+	for partition := range lags {
+		partitions = append(partitions, partition)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+
+	var totalLag, maxLag int64
+	for _, partition := range partitions {
+		slog.Debug(fmt.Sprintf("kafka topic %s partition %d lag: %d", k.topic, partition, lags[partition]))
+		if totalLag > math.MaxInt64-lags[partition] {
+			totalLag = math.MaxInt64
+		} else {
+			totalLag += lags[partition]
+		}
+		if lags[partition] > maxLag {
+			maxLag = lags[partition]
+		}
+	}
+
+	if k.lagAggregation == KAFKA_LAG_AGGREGATION_MAX_PARTITION {
+		return clampKafkaLag(maxLag), nil
+	}
+	return clampKafkaLag(totalLag), nil
+}
+
+// clampKafkaLag converts lag to an int, clamped to math.MaxInt on 32-bit platforms
+func clampKafkaLag(lag int64) int {
+	if lag > math.MaxInt {
+		return math.MaxInt
+	}
+	return int(lag)
+}
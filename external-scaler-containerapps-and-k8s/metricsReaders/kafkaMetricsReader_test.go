@@ -0,0 +1,503 @@
+package metricsReaders
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"github.com/xdg-go/scram"
+)
+
+type fakeKafkaPartition struct {
+	earliest  int64
+	latest    int64
+	committed int64
+}
+
+// fakeKafkaApiVersions are the request versions of a Kafka 4 broker, which no longer supports
+// Metadata v0 to v3 (KIP-896)
+var fakeKafkaApiVersions = map[int16][2]int16{
+	2:  {1, 9},  // ListOffsets
+	3:  {4, 13}, // Metadata
+	9:  {1, 9},  // OffsetFetch
+	10: {1, 6},  // FindCoordinator
+	17: {1, 1},  // SaslHandshake
+	18: {0, 4},  // ApiVersions
+	36: {0, 2},  // SaslAuthenticate
+}
+
+// fakeLegacyKafkaApiVersions are the request versions of a broker that predates flexible
+// versions and the batched FindCoordinator and OffsetFetch
+var fakeLegacyKafkaApiVersions = map[int16][2]int16{
+	2:  {0, 1},
+	3:  {0, 1},
+	9:  {0, 1},
+	10: {0, 0},
+	17: {0, 1},
+	18: {0, 0},
+	36: {0, 0},
+}
+
+// fakeKafkaBroker is a single broker that leads every partition of one topic and coordinates
+// one consumer group, answering the requests KafkaMetricsReader makes
+type fakeKafkaBroker struct {
+	t           *testing.T
+	listener    net.Listener
+	topic       string
+	group       string
+	partitions  map[int32]fakeKafkaPartition
+	apiVersions map[int16][2]int16
+	// when set, connections have to authenticate with SASL PLAIN or SCRAM-SHA-256 before other
+	// requests
+	username string
+	password string
+}
+
+func newFakeKafkaBroker(t *testing.T, apiVersions map[int16][2]int16, topic string, group string, partitions map[int32]fakeKafkaPartition, username string, password string) *fakeKafkaBroker {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	b := &fakeKafkaBroker{t: t, listener: listener, topic: topic, group: group, partitions: partitions, apiVersions: apiVersions, username: username, password: password}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+
+	return b
+}
+
+func (b *fakeKafkaBroker) address() string {
+	return b.listener.Addr().String()
+}
+
+func (b *fakeKafkaBroker) hostPort() (string, int32) {
+	host, port, _ := net.SplitHostPort(b.address())
+	p, _ := strconv.Atoi(port)
+	return host, int32(p)
+}
+
+// readRequest reads a request, see https://kafka.apache.org/protocol#protocol_messages
+func (b *fakeKafkaBroker) readRequest(conn net.Conn) (kmsg.Request, int32, bool) {
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, 0, false
+	}
+	frame := make([]byte, binary.BigEndian.Uint32(size[:]))
+	if _, err := io.ReadFull(conn, frame); err != nil {
+		return nil, 0, false
+	}
+
+	key, version := int16(binary.BigEndian.Uint16(frame)), int16(binary.BigEndian.Uint16(frame[2:]))
+	correlationID := int32(binary.BigEndian.Uint32(frame[4:]))
+	clientIDLength := int16(binary.BigEndian.Uint16(frame[8:]))
+	body := frame[10+max(clientIDLength, 0):]
+
+	request := kmsg.RequestForKey(key)
+	if request == nil {
+		b.t.Errorf("Unexpected kafka request %d", key)
+		return nil, 0, false
+	}
+	request.SetVersion(version)
+	if request.IsFlexible() {
+		tags := &kafkaTagReader{buf: body}
+		kmsg.SkipTags(tags)
+		body = tags.buf
+	}
+	if err := request.ReadFrom(body); err != nil {
+		b.t.Errorf("Failed to decode kafka request %s v%d: %v", kmsg.NameForKey(key), version, err)
+		return nil, 0, false
+	}
+	return request, correlationID, true
+}
+
+func (b *fakeKafkaBroker) serve(conn net.Conn) {
+	defer conn.Close()
+
+	authenticated := b.username == ""
+	var mechanism string
+	var scramConversation *scram.ServerConversation
+	for {
+		request, correlationID, ok := b.readRequest(conn)
+		if !ok {
+			return
+		}
+
+		key, version := request.Key(), request.GetVersion()
+		if versions, ok := b.apiVersions[key]; !ok || version < versions[0] || version > versions[1] {
+			if key != 18 {
+				b.t.Errorf("Unsupported kafka request %s v%d", kmsg.NameForKey(key), version)
+				return
+			}
+			// ApiVersions versions the broker doesn't support are answered with a v0 body
+			request.SetVersion(0)
+			response := kmsg.NewPtrApiVersionsResponse()
+			response.ErrorCode = 35
+			b.writeResponse(conn, request, correlationID, response)
+			continue
+		}
+		if !authenticated && key != 17 && key != 18 && key != 36 {
+			// brokers close connections that skip authentication
+			return
+		}
+
+		var response kmsg.Response
+		switch request := request.(type) {
+		case *kmsg.ApiVersionsRequest:
+			apiVersionsResponse := kmsg.NewPtrApiVersionsResponse()
+			for apiKey, versions := range b.apiVersions {
+				version := kmsg.NewApiVersionsResponseApiKey()
+				version.ApiKey, version.MinVersion, version.MaxVersion = apiKey, versions[0], versions[1]
+				apiVersionsResponse.ApiKeys = append(apiVersionsResponse.ApiKeys, version)
+			}
+			response = apiVersionsResponse
+		case *kmsg.MetadataRequest:
+			response = b.metadata(request)
+		case *kmsg.FindCoordinatorRequest:
+			response = b.findCoordinator(request)
+		case *kmsg.OffsetFetchRequest:
+			response = b.offsetFetch(request)
+		case *kmsg.ListOffsetsRequest:
+			response = b.listOffsets(request)
+		case *kmsg.SASLHandshakeRequest:
+			handshakeResponse := kmsg.NewPtrSASLHandshakeResponse()
+			handshakeResponse.SupportedMechanisms = []string{KAFKA_SASL_MECHANISM_PLAIN, KAFKA_SASL_MECHANISM_SCRAM_SHA_256}
+			mechanism = request.Mechanism
+			switch mechanism {
+			case KAFKA_SASL_MECHANISM_PLAIN:
+			case KAFKA_SASL_MECHANISM_SCRAM_SHA_256:
+				scramConversation = b.newSCRAMConversation()
+			default:
+				handshakeResponse.ErrorCode = 33
+			}
+			response = handshakeResponse
+		case *kmsg.SASLAuthenticateRequest:
+			authenticateResponse := kmsg.NewPtrSASLAuthenticateResponse()
+			if mechanism == KAFKA_SASL_MECHANISM_PLAIN {
+				credentials := strings.Split(string(request.SASLAuthBytes), "\x00")
+				authenticated = len(credentials) == 3 && credentials[1] == b.username && credentials[2] == b.password
+				if !authenticated {
+					authenticateResponse.ErrorCode = 58
+				}
+			} else {
+				reply, err := scramConversation.Step(string(request.SASLAuthBytes))
+				authenticated = err == nil && scramConversation.Done()
+				if err != nil {
+					authenticateResponse.ErrorCode = 58
+				}
+				authenticateResponse.SASLAuthBytes = []byte(reply)
+			}
+			if authenticateResponse.ErrorCode != 0 {
+				authenticateResponse.ErrorMessage = kmsg.StringPtr("Authentication failed: Invalid username or password")
+			}
+			response = authenticateResponse
+		default:
+			b.t.Errorf("Unexpected kafka request %s", kmsg.NameForKey(key))
+			return
+		}
+
+		if !b.writeResponse(conn, request, correlationID, response) {
+			return
+		}
+	}
+}
+
+func (b *fakeKafkaBroker) writeResponse(conn net.Conn, request kmsg.Request, correlationID int32, response kmsg.Response) bool {
+	response.SetVersion(request.GetVersion())
+	frame := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(correlationID))
+	if response.IsFlexible() && response.Key() != 18 {
+		frame = append(frame, 0) // no header tags
+	}
+	frame = response.AppendTo(frame)
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	_, err := conn.Write(frame)
+	return err == nil
+}
+
+func (b *fakeKafkaBroker) newSCRAMConversation() *scram.ServerConversation {
+	client, err := scram.SHA256.NewClient(b.username, b.password, "")
+	if err != nil {
+		b.t.Fatalf("Failed to create SCRAM client: %v", err)
+	}
+	credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: "fake-kafka-salt", Iters: 4096})
+	server, err := scram.SHA256.NewServer(func(username string) (scram.StoredCredentials, error) {
+		if username != b.username {
+			return scram.StoredCredentials{}, errors.New("unknown user")
+		}
+		return credentials, nil
+	})
+	if err != nil {
+		b.t.Fatalf("Failed to create SCRAM server: %v", err)
+	}
+	return server.NewConversation()
+}
+
+func (b *fakeKafkaBroker) metadata(request *kmsg.MetadataRequest) kmsg.Response {
+	host, port := b.hostPort()
+	response := kmsg.NewPtrMetadataResponse()
+	broker := kmsg.NewMetadataResponseBroker()
+	broker.NodeID, broker.Host, broker.Port = 0, host, port
+	response.Brokers = append(response.Brokers, broker)
+
+	for _, requestTopic := range request.Topics {
+		topic := kmsg.NewMetadataResponseTopic()
+		topic.Topic = requestTopic.Topic
+		if requestTopic.Topic == nil || *requestTopic.Topic != b.topic {
+			topic.ErrorCode = 3 // UNKNOWN_TOPIC_OR_PARTITION
+			response.Topics = append(response.Topics, topic)
+			continue
+		}
+		for id := range b.partitions {
+			partition := kmsg.NewMetadataResponseTopicPartition()
+			partition.Partition, partition.Leader = id, 0
+			partition.Replicas, partition.ISR = []int32{0}, []int32{0}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		response.Topics = append(response.Topics, topic)
+	}
+	return response
+}
+
+func (b *fakeKafkaBroker) findCoordinator(request *kmsg.FindCoordinatorRequest) kmsg.Response {
+	host, port := b.hostPort()
+	response := kmsg.NewPtrFindCoordinatorResponse()
+	if request.Version >= 4 {
+		for _, group := range request.CoordinatorKeys {
+			if group != b.group {
+				b.t.Errorf("Unexpected group %s", group)
+			}
+			coordinator := kmsg.NewFindCoordinatorResponseCoordinator()
+			coordinator.Key, coordinator.NodeID, coordinator.Host, coordinator.Port = group, 0, host, port
+			response.Coordinators = append(response.Coordinators, coordinator)
+		}
+		return response
+	}
+	if request.CoordinatorKey != b.group {
+		b.t.Errorf("Unexpected group %s", request.CoordinatorKey)
+	}
+	response.NodeID, response.Host, response.Port = 0, host, port
+	return response
+}
+
+func (b *fakeKafkaBroker) offsetFetch(request *kmsg.OffsetFetchRequest) kmsg.Response {
+	response := kmsg.NewPtrOffsetFetchResponse()
+	if request.Version >= 8 {
+		for _, requestGroup := range request.Groups {
+			group := kmsg.NewOffsetFetchResponseGroup()
+			group.Group = requestGroup.Group
+			for _, requestTopic := range requestGroup.Topics {
+				topic := kmsg.NewOffsetFetchResponseGroupTopic()
+				topic.Topic = requestTopic.Topic
+				for _, id := range requestTopic.Partitions {
+					partition := kmsg.NewOffsetFetchResponseGroupTopicPartition()
+					partition.Partition, partition.Offset = id, b.partitions[id].committed
+					topic.Partitions = append(topic.Partitions, partition)
+				}
+				group.Topics = append(group.Topics, topic)
+			}
+			response.Groups = append(response.Groups, group)
+		}
+		return response
+	}
+	for _, requestTopic := range request.Topics {
+		topic := kmsg.NewOffsetFetchResponseTopic()
+		topic.Topic = requestTopic.Topic
+		for _, id := range requestTopic.Partitions {
+			partition := kmsg.NewOffsetFetchResponseTopicPartition()
+			partition.Partition, partition.Offset = id, b.partitions[id].committed
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		response.Topics = append(response.Topics, topic)
+	}
+	return response
+}
+
+func (b *fakeKafkaBroker) listOffsets(request *kmsg.ListOffsetsRequest) kmsg.Response {
+	response := kmsg.NewPtrListOffsetsResponse()
+	for _, requestTopic := range request.Topics {
+		topic := kmsg.NewListOffsetsResponseTopic()
+		topic.Topic = requestTopic.Topic
+		for _, requestPartition := range requestTopic.Partitions {
+			partition := kmsg.NewListOffsetsResponseTopicPartition()
+			partition.Partition, partition.Offset = requestPartition.Partition, b.partitions[requestPartition.Partition].latest
+			if requestPartition.Timestamp == kafkaEarliestOffsetTimestamp {
+				partition.Offset = b.partitions[requestPartition.Partition].earliest
+			}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		response.Topics = append(response.Topics, topic)
+	}
+	return response
+}
+
+func TestKafkaGetQueueLength(t *testing.T) {
+	partitions := map[int32]fakeKafkaPartition{
+		0: {earliest: 0, latest: 100, committed: 90},
+		1: {earliest: 10, latest: 50, committed: 20},
+		// not committed to by the group yet
+		2: {earliest: 5, latest: 25, committed: -1},
+	}
+	broker := newFakeKafkaBroker(t, fakeKafkaApiVersions, "embeddings", "subscriber-app", partitions, "", "")
+	legacyBroker := newFakeKafkaBroker(t, fakeLegacyKafkaApiVersions, "embeddings", "subscriber-app", partitions, "", "")
+	saslBroker := newFakeKafkaBroker(t, fakeKafkaApiVersions, "embeddings", "subscriber-app", partitions, "scaler", "secret")
+	legacySASLBroker := newFakeKafkaBroker(t, fakeLegacyKafkaApiVersions, "embeddings", "subscriber-app", partitions, "scaler", "secret")
+	// the summed lag of these partitions overflows an int64
+	overflowingBroker := newFakeKafkaBroker(t, fakeKafkaApiVersions, "embeddings", "subscriber-app", map[int32]fakeKafkaPartition{
+		0: {earliest: 0, latest: math.MaxInt64, committed: 0},
+		1: {earliest: 0, latest: math.MaxInt64, committed: 1},
+	}, "", "")
+
+	testCases := []struct {
+		name          string
+		config        KafkaMetricsReaderConfig
+		expected      int
+		expectedError int16
+	}{
+		{
+			name:     "total lag",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{broker.address()}},
+			expected: 40,
+		},
+		{
+			name:     "max partition lag",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{broker.address()}, LagAggregation: KAFKA_LAG_AGGREGATION_MAX_PARTITION},
+			expected: 30,
+		},
+		{
+			name:     "uncommitted partitions from the earliest offset",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{broker.address()}, OffsetResetPolicy: KAFKA_OFFSET_RESET_EARLIEST},
+			expected: 60,
+		},
+		{
+			name:     "total lag clamped",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{overflowingBroker.address()}},
+			expected: math.MaxInt,
+		},
+		{
+			name:     "legacy broker",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{legacyBroker.address()}, OffsetResetPolicy: KAFKA_OFFSET_RESET_EARLIEST},
+			expected: 60,
+		},
+		{
+			name:     "unreachable bootstrap server is skipped",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{"127.0.0.1:1", broker.address()}},
+			expected: 40,
+		},
+		{
+			name:     "SASL PLAIN",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{saslBroker.address()}, SASLMechanism: KAFKA_SASL_MECHANISM_PLAIN, Username: "scaler", Password: "secret"},
+			expected: 40,
+		},
+		{
+			name:     "SASL PLAIN legacy broker",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{legacySASLBroker.address()}, SASLMechanism: KAFKA_SASL_MECHANISM_PLAIN, Username: "scaler", Password: "secret"},
+			expected: 40,
+		},
+		{
+			name:     "SASL SCRAM-SHA-256",
+			config:   KafkaMetricsReaderConfig{BootstrapServers: []string{saslBroker.address()}, SASLMechanism: KAFKA_SASL_MECHANISM_SCRAM_SHA_256, Username: "scaler", Password: "secret"},
+			expected: 40,
+		},
+		{
+			name:          "SASL PLAIN wrong password",
+			config:        KafkaMetricsReaderConfig{BootstrapServers: []string{saslBroker.address()}, SASLMechanism: KAFKA_SASL_MECHANISM_PLAIN, Username: "scaler", Password: "wrong"},
+			expectedError: 58,
+		},
+		{
+			name:          "SASL SCRAM-SHA-256 wrong password",
+			config:        KafkaMetricsReaderConfig{BootstrapServers: []string{saslBroker.address()}, SASLMechanism: KAFKA_SASL_MECHANISM_SCRAM_SHA_256, Username: "scaler", Password: "wrong"},
+			expectedError: 58,
+		},
+		{
+			name:          "unsupported SASL mechanism",
+			config:        KafkaMetricsReaderConfig{BootstrapServers: []string{saslBroker.address()}, SASLMechanism: KAFKA_SASL_MECHANISM_SCRAM_SHA_512, Username: "scaler", Password: "secret"},
+			expectedError: 33,
+		},
+		{
+			name:          "unknown topic",
+			config:        KafkaMetricsReaderConfig{BootstrapServers: []string{broker.address()}, Topic: "missing"},
+			expectedError: 3,
+		},
+	}
+
+	for _, tc := range testCases {
+		if tc.config.Topic == "" {
+			tc.config.Topic = "embeddings"
+		}
+		tc.config.ConsumerGroup = "subscriber-app"
+
+		k, err := NewKafkaMetricsReader(tc.config)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.name)
+		}
+
+		queueLength, err := k.GetQueueLength(context.Background())
+		if tc.expectedError != 0 {
+			var kafkaError *KafkaError
+			if !errors.As(err, &kafkaError) || kafkaError.Code != tc.expectedError {
+				t.Errorf("Expected kafka error %d, but got %v (%s)", tc.expectedError, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if queueLength != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, queueLength, tc.name)
+		}
+	}
+}
+
+func TestKafkaNegotiateVersion(t *testing.T) {
+	testCases := []struct {
+		name           string
+		brokerVersions [2]int16
+		request        kmsg.Request
+		expected       int16
+		expectedError  bool
+	}{
+		{name: "highest version both support", brokerVersions: [2]int16{4, 13}, request: kmsg.NewPtrMetadataRequest(), expected: 12},
+		{name: "broker maximum", brokerVersions: [2]int16{0, 1}, request: kmsg.NewPtrMetadataRequest(), expected: 1},
+		{name: "ListOffsets v0 has no single offset", brokerVersions: [2]int16{0, 0}, request: kmsg.NewPtrListOffsetsRequest(), expectedError: true},
+		{name: "broker minimum above kmsg", brokerVersions: [2]int16{20, 21}, request: kmsg.NewPtrMetadataRequest(), expectedError: true},
+	}
+
+	for _, tc := range testCases {
+		apiKey := kmsg.NewApiVersionsResponseApiKey()
+		apiKey.ApiKey, apiKey.MinVersion, apiKey.MaxVersion = tc.request.Key(), tc.brokerVersions[0], tc.brokerVersions[1]
+		c := &kafkaConn{versions: map[int16]kmsg.ApiVersionsResponseApiKey{apiKey.ApiKey: apiKey}}
+
+		err := c.negotiateVersion(tc.request)
+		if tc.expectedError {
+			var kafkaError *KafkaError
+			if !errors.As(err, &kafkaError) || kafkaError.Code != kafkaErrorUnsupportedVersion {
+				t.Errorf("Expected an unsupported version error, but got %v (%s)", err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if tc.request.GetVersion() != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, tc.request.GetVersion(), tc.name)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const DEFAULT_NATS_TIMEOUT = 10 * time.Second
+
+type NATSMetricsReaderConfig struct {
+	// URL is one or more comma separated server URLs, e.g. nats://nats:4222
+	URL string
+	// Domain is the JetStream domain of the stream, empty for the local domain
+	Domain   string
+	Stream   string
+	Consumer string
+
+	// Username and Password, Token, or CredentialsFile (a JWT and NKey .creds file)
+	// authenticate the connection
+	Username        string
+	Password        string
+	Token           string
+	CredentialsFile string
+	// TLSConfig enables TLS when set
+	TLSConfig *tls.Config
+	// Timeout applies to connecting and to each JetStream API request, defaults to
+	// DEFAULT_NATS_TIMEOUT
+	Timeout time.Duration
+}
+
+type NATSMetricsReader struct {
+	url      string
+	domain   string
+	stream   string
+	consumer string
+	options  []nats.Option
+	timeout  time.Duration
+
+	// the connection is made on first use and reconnects by itself after that
+	mu sync.Mutex
+	nc *nats.Conn
+	js jetstream.JetStream
+}
+
+func NewNATSMetricsReader(config NATSMetricsReaderConfig) (*NATSMetricsReader, error) {
+	if config.URL == "" || config.Stream == "" || config.Consumer == "" {
+		return nil, fmt.Errorf("nats URL, stream and consumer are required")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_NATS_TIMEOUT
+	}
+
+	options := []nats.Option{
+		nats.Name("keda-queue-length-error-rate-scaler"),
+		nats.Timeout(timeout),
+		nats.MaxReconnects(-1),
+	}
+	switch {
+	case config.CredentialsFile != "":
+		options = append(options, nats.UserCredentials(config.CredentialsFile))
+	case config.Token != "":
+		options = append(options, nats.Token(config.Token))
+	case config.Username != "":
+		options = append(options, nats.UserInfo(config.Username, config.Password))
+	}
+	if config.TLSConfig != nil {
+		options = append(options, nats.Secure(config.TLSConfig))
+	}
+
+	return &NATSMetricsReader{
+		url:      config.URL,
+		domain:   config.Domain,
+		stream:   config.Stream,
+		consumer: config.Consumer,
+		options:  options,
+		timeout:  timeout,
+	}, nil
+}
+
+func (n *NATSMetricsReader) getJetStream() (jetstream.JetStream, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.js != nil {
+		return n.js, nil
+	}
+
+	nc, err := nats.Connect(n.url, n.options...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to nats: %w", err)
+	}
+	var js jetstream.JetStream
+	if n.domain != "" {
+		js, err = jetstream.NewWithDomain(nc, n.domain)
+	} else {
+		js, err = jetstream.New(nc)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("could not create jetstream context: %w", err)
+	}
+
+	n.nc, n.js = nc, js
+	return js, nil
+}
+
+// Close closes the connection, if one was made
+func (n *NATSMetricsReader) Close() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.nc != nil {
+		n.nc.Close()
+		n.nc, n.js = nil, nil
+	}
+}
+
+// GetQueueLength returns the consumer's messages not yet delivered plus those delivered but
+// not yet acknowledged
+func (n *NATSMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	js, err := n.getJetStream()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	consumer, err := js.Consumer(ctx, n.stream, n.consumer)
+	if err != nil {
+		return 0, fmt.Errorf("could not get jetstream consumer %s of stream %s: %w", n.consumer, n.stream, err)
+	}
+	info := consumer.CachedInfo()
+
+	slog.Debug(fmt.Sprintf("jetstream stream %s consumer %s num_pending: %d, num_ack_pending: %d", n.stream, n.consumer, info.NumPending, info.NumAckPending))
+	return int(info.NumPending) + info.NumAckPending, nil
+}
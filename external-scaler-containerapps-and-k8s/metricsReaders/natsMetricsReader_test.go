@@ -0,0 +1,190 @@
+package metricsReaders
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fakeNATSServer speaks enough of the NATS client protocol to answer JetStream consumer info
+// requests with testdata fixtures, keyed by API subject
+type fakeNATSServer struct {
+	t        *testing.T
+	listener net.Listener
+	username string
+	password string
+	fixtures map[string]string
+}
+
+func newFakeNATSServer(t *testing.T, username string, password string, fixtures map[string]string) *fakeNATSServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeNATSServer{t: t, listener: listener, username: username, password: password, fixtures: fixtures}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeNATSServer) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *fakeNATSServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	info := fmt.Sprintf(`{"server_id":"FAKE","server_name":"fake","version":"2.10.20","proto":1,"headers":true,"max_payload":1048576,"jetstream":true,"auth_required":%t}`, s.username != "")
+	if _, err := io.WriteString(conn, "INFO "+info+"\r\n"); err != nil {
+		return
+	}
+
+	// subscriptions by subject, with the trailing * of the client's response inbox
+	subscriptions := map[string]string{}
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "CONNECT":
+			var connect struct {
+				User string `json:"user"`
+				Pass string `json:"pass"`
+			}
+			_ = json.Unmarshal([]byte(strings.TrimSpace(line[len("CONNECT "):])), &connect)
+			if s.username != "" && (connect.User != s.username || connect.Pass != s.password) {
+				_, _ = io.WriteString(conn, "-ERR 'Authorization Violation'\r\n")
+				return
+			}
+		case "PING":
+			_, _ = io.WriteString(conn, "PONG\r\n")
+		case "SUB":
+			subscriptions[fields[1]] = fields[len(fields)-1]
+		case "PUB", "HPUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			subject, reply := fields[1], fields[2]
+
+			fixture, ok := s.fixtures[subject]
+			if !ok {
+				s.t.Errorf("Unexpected request to %s", subject)
+				continue
+			}
+			body, err := os.ReadFile(filepath.Join("testdata", fixture))
+			if err != nil {
+				s.t.Errorf("Failed to read fixture %s: %v", fixture, err)
+			}
+
+			sid, ok := subscriptions[reply]
+			if !ok {
+				sid = subscriptions[reply[:strings.LastIndex(reply, ".")+1]+"*"]
+			}
+			_, _ = fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", reply, sid, len(body), body)
+		}
+	}
+}
+
+func TestNATSGetQueueLength(t *testing.T) {
+	fixtures := map[string]string{
+		"$JS.API.CONSUMER.INFO.EMBEDDINGS.workers":      "nats_consumer_info.json",
+		"$JS.API.CONSUMER.INFO.EMBEDDINGS.missing":      "nats_consumer_not_found.json",
+		"$JS.API.CONSUMER.INFO.MISSING.workers":         "nats_stream_not_found.json",
+		"$JS.edge.API.CONSUMER.INFO.EMBEDDINGS.workers": "nats_consumer_info.json",
+	}
+	server := newFakeNATSServer(t, "", "", fixtures)
+	authServer := newFakeNATSServer(t, "scaler", "secret", fixtures)
+
+	testCases := []struct {
+		name          string
+		config        NATSMetricsReaderConfig
+		expected      int
+		expectedError error
+	}{
+		{
+			name:     "pending plus ack pending",
+			config:   NATSMetricsReaderConfig{URL: server.url(), Stream: "EMBEDDINGS", Consumer: "workers"},
+			expected: 42,
+		},
+		{
+			name:     "domain",
+			config:   NATSMetricsReaderConfig{URL: server.url(), Domain: "edge", Stream: "EMBEDDINGS", Consumer: "workers"},
+			expected: 42,
+		},
+		{
+			name:     "user and password",
+			config:   NATSMetricsReaderConfig{URL: authServer.url(), Stream: "EMBEDDINGS", Consumer: "workers", Username: "scaler", Password: "secret"},
+			expected: 42,
+		},
+		{
+			name:          "consumer not found",
+			config:        NATSMetricsReaderConfig{URL: server.url(), Stream: "EMBEDDINGS", Consumer: "missing"},
+			expectedError: jetstream.ErrConsumerNotFound,
+		},
+		{
+			name:          "stream not found",
+			config:        NATSMetricsReaderConfig{URL: server.url(), Stream: "MISSING", Consumer: "workers"},
+			expectedError: jetstream.ErrStreamNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		n, err := NewNATSMetricsReader(tc.config)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.name)
+		}
+
+		queueLength, err := n.GetQueueLength(context.Background())
+		n.Close()
+		if tc.expectedError != nil {
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Expected %v, but got %v (%s)", tc.expectedError, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if queueLength != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, queueLength, tc.name)
+		}
+	}
+
+	n, _ := NewNATSMetricsReader(NATSMetricsReaderConfig{URL: authServer.url(), Stream: "EMBEDDINGS", Consumer: "workers", Username: "scaler", Password: "wrong"})
+	if _, err := n.GetQueueLength(context.Background()); err == nil || !strings.Contains(err.Error(), "Authorization Violation") {
+		t.Errorf("Expected an authorization error, but got %v", err)
+	}
+}
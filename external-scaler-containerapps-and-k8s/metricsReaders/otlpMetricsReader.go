@@ -0,0 +1,103 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+)
+
+type OTLPMetricsReaderConfig struct {
+	// Receiver holds the metrics pushed to the scaler
+	Receiver *OTLPReceiver
+	// QueueLengthMetric and Rate429ErrorsMetric are OTLP metric names, e.g.
+	// subscriber-app.openai.embeddings.retries, summed over the series having all of the
+	// attributes. A reader without a metric returns an error for that signal
+	QueueLengthMetric       string
+	QueueLengthAttributes   map[string]string
+	Rate429ErrorsMetric     string
+	Rate429ErrorsAttributes map[string]string
+	// Window is the sliding window events are summed over, defaults to the receiver's
+	// retention
+	Window time.Duration
+}
+
+type OTLPMetricsReader struct {
+	receiver                *OTLPReceiver
+	queueLengthMetric       string
+	queueLengthAttributes   map[string]string
+	rate429ErrorsMetric     string
+	rate429ErrorsAttributes map[string]string
+	window                  time.Duration
+}
+
+func NewOTLPMetricsReader(config OTLPMetricsReaderConfig) (*OTLPMetricsReader, error) {
+	if config.Receiver == nil {
+		return nil, fmt.Errorf("the OTLP receiver is not enabled on the scaler")
+	}
+	if config.QueueLengthMetric == "" && config.Rate429ErrorsMetric == "" {
+		return nil, fmt.Errorf("an OTLP queue length or rate 429 errors metric is required")
+	}
+
+	return &OTLPMetricsReader{
+		receiver:                config.Receiver,
+		queueLengthMetric:       config.QueueLengthMetric,
+		queueLengthAttributes:   config.QueueLengthAttributes,
+		rate429ErrorsMetric:     config.Rate429ErrorsMetric,
+		rate429ErrorsAttributes: config.Rate429ErrorsAttributes,
+		window:                  config.Window,
+	}, nil
+}
+
+// ParseOTLPAttributes parses comma separated key=value attribute filters, e.g.
+// "status=429,service.name=subscriber-app"
+func ParseOTLPAttributes(commaSeparatedAttributes string) (map[string]string, error) {
+	attributes := map[string]string{}
+	for _, attribute := range strings.Split(commaSeparatedAttributes, ",") {
+		attribute = strings.TrimSpace(attribute)
+		if attribute == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(attribute, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid OTLP attribute %q, expected key=value", attribute)
+		}
+		attributes[key] = strings.TrimSpace(value)
+	}
+	return attributes, nil
+}
+
+func (o *OTLPMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	if o.queueLengthMetric == "" {
+		return 0, fmt.Errorf("no OTLP queue length metric is set")
+	}
+	value, err := o.getMetricValue(o.queueLengthMetric, o.queueLengthAttributes)
+	return int(math.Round(value)), err
+}
+
+func (o *OTLPMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	if o.rate429ErrorsMetric == "" {
+		return 0, fmt.Errorf("no OTLP rate 429 errors metric is set")
+	}
+	value, err := o.getMetricValue(o.rate429ErrorsMetric, o.rate429ErrorsAttributes)
+	if err != nil {
+		return 0, err
+	}
+	// the events within the window are normalized to a per minute rate, so that
+	// RATE_429_ERROR_THRESHOLD keeps its meaning whatever the window
+	return int(math.Round(value / o.receiver.aggregateWindow(o.window).Minutes())), nil
+}
+
+func (o *OTLPMetricsReader) getMetricValue(metric string, attributes map[string]string) (float64, error) {
+	value, ok := o.receiver.Aggregate(metric, attributes, o.window)
+	if !ok {
+		// this implies nothing was pushed yet, e.g. no 429 errors were recorded
+		slog.Debug(fmt.Sprintf("no OTLP series of %s with attributes %v", metric, attributes))
+		return 0, nil
+	}
+	slog.Debug(fmt.Sprintf("OTLP %s with attributes %v: %v", metric, attributes, value))
+	return value, nil
+}
@@ -0,0 +1,449 @@
+package metricsReaders
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	DEFAULT_OTLP_RETENTION         = 10 * time.Minute
+	DEFAULT_OTLP_MAX_SERIES        = 10000
+	DEFAULT_OTLP_MAX_REQUEST_BYTES = 4 * 1024 * 1024
+	OTLP_HTTP_METRICS_PATH         = "/v1/metrics"
+
+	otlpContentTypeProtobuf = "application/x-protobuf"
+	otlpContentTypeJSON     = "application/json"
+
+	otlpSeriesKindGauge  = "gauge"
+	otlpSeriesKindEvents = "events"
+)
+
+type OTLPReceiverConfig struct {
+	// Retention is how long samples are kept, the longest window readers can aggregate over,
+	// defaults to DEFAULT_OTLP_RETENTION
+	Retention time.Duration
+	// MaxSeries limits the series, metric and attribute set pairs, kept in memory, data points
+	// of further series are rejected. Defaults to DEFAULT_OTLP_MAX_SERIES
+	MaxSeries int
+	// MaxRequestBytes fails OTLP/HTTP requests with larger bodies, after decompression.
+	// Defaults to DEFAULT_OTLP_MAX_REQUEST_BYTES
+	MaxRequestBytes int64
+	// Token is required as a bearer token on exports, in the Authorization header over HTTP
+	// and the authorization metadata over gRPC. Without one the receiver is only created with
+	// AllowUnauthenticated, as exports drive scaling
+	Token string
+	// AllowUnauthenticated accepts exports without a token when Token is not set, for ports
+	// only reachable by trusted workers
+	AllowUnauthenticated bool
+}
+
+// OTLPReceiver receives OTLP metrics over gRPC and HTTP and keeps sliding window aggregates
+// per metric and attribute set in memory, for OTLPMetricsReader. Sums and histograms are kept
+// as the events recorded, the increase of a sum or the number of histogram measurements, and
+// gauges as their last value only, so their memory doesn't grow with the export rate
+type OTLPReceiver struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+
+	retention            time.Duration
+	maxSeries            int
+	maxRequestBytes      int64
+	token                string
+	allowUnauthenticated bool
+	// now is the receive time samples are recorded at, as worker clocks may be skewed
+	now func() time.Time
+
+	mu     sync.Mutex
+	series map[string]*otlpSeries
+}
+
+// otlpSeries is a metric with one set of resource and data point attributes
+type otlpSeries struct {
+	metric     string
+	attributes map[string]string
+	kind       string
+	samples    []otlpSample
+	lastSeen   time.Time
+
+	// cumulative sums and histograms are turned into events by their increase since the
+	// previous data point of the series
+	hasCumulative   bool
+	lastCumulative  float64
+	lastStartTimeNs uint64
+}
+
+type otlpSample struct {
+	time  time.Time
+	value float64
+}
+
+func NewOTLPReceiver(config OTLPReceiverConfig) (*OTLPReceiver, error) {
+	if config.Token == "" && !config.AllowUnauthenticated {
+		return nil, fmt.Errorf("an OTLP receiver token is required, unless unauthenticated exports are allowed")
+	}
+	retention := config.Retention
+	if retention <= 0 {
+		retention = DEFAULT_OTLP_RETENTION
+	}
+	maxSeries := config.MaxSeries
+	if maxSeries <= 0 {
+		maxSeries = DEFAULT_OTLP_MAX_SERIES
+	}
+	maxRequestBytes := config.MaxRequestBytes
+	if maxRequestBytes <= 0 {
+		maxRequestBytes = DEFAULT_OTLP_MAX_REQUEST_BYTES
+	}
+
+	return &OTLPReceiver{
+		retention:            retention,
+		maxSeries:            maxSeries,
+		maxRequestBytes:      maxRequestBytes,
+		token:                config.Token,
+		allowUnauthenticated: config.AllowUnauthenticated,
+		now:                  time.Now,
+		series:               map[string]*otlpSeries{},
+	}, nil
+}
+
+// Export implements the OTLP gRPC metrics service
+func (o *OTLPReceiver) Export(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	authorization := md.Get("authorization")
+	if len(authorization) > 1 || !isAuthorized(strings.Join(authorization, ""), o.token, o.allowUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return o.record(request), nil
+}
+
+// ServeHTTP implements OTLP/HTTP, binary protobuf or JSON encoded, optionally gzip compressed
+func (o *OTLPReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAuthorized(r.Header.Get("Authorization"), o.token, o.allowUnauthenticated) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlpContentTypeProtobuf && contentType != otlpContentTypeJSON {
+		http.Error(w, fmt.Sprintf("unsupported content type %q, supported types are %s and %s", contentType, otlpContentTypeProtobuf, otlpContentTypeJSON), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "invalid gzip body", http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	default:
+		http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// one byte more than the limit is read to tell a body at the limit from a larger one
+	requestBody, err := io.ReadAll(io.LimitReader(body, o.maxRequestBytes+1))
+	if err != nil {
+		http.Error(w, "could not read request body", http.StatusBadRequest)
+		return
+	}
+	if int64(len(requestBody)) > o.maxRequestBytes {
+		http.Error(w, fmt.Sprintf("request body is larger than %d bytes", o.maxRequestBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	request := &colmetricspb.ExportMetricsServiceRequest{}
+	if contentType == otlpContentTypeJSON {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(requestBody, request)
+	} else {
+		err = proto.Unmarshal(requestBody, request)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("could not decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := o.record(request)
+	var responseBody []byte
+	if contentType == otlpContentTypeJSON {
+		responseBody, err = protojson.Marshal(response)
+	} else {
+		responseBody, err = proto.Marshal(response)
+	}
+	if err != nil {
+		http.Error(w, "could not encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(responseBody)
+}
+
+// record adds the request's data points to their series, data points of new series beyond
+// MaxSeries and of unsupported types are rejected as a partial success
+func (o *OTLPReceiver) record(request *colmetricspb.ExportMetricsServiceRequest) *colmetricspb.ExportMetricsServiceResponse {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.now()
+	o.evictExpiredSeries(now)
+
+	rejected := int64(0)
+	rejectedReason := ""
+	for _, resourceMetrics := range request.GetResourceMetrics() {
+		resourceAttributes := resourceMetrics.GetResource().GetAttributes()
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				for _, point := range getOTLPDataPoints(metric) {
+					if point.kind == "" {
+						rejected++
+						rejectedReason = fmt.Sprintf("metric %s: only gauges, sums and histograms are supported", metric.GetName())
+						continue
+					}
+					series := o.getSeries(metric.GetName(), point.kind, resourceAttributes, point.attributes, now)
+					if series == nil {
+						rejected++
+						rejectedReason = fmt.Sprintf("the receiver keeps at most %d series", o.maxSeries)
+						continue
+					}
+					series.add(point, now, o.retention)
+				}
+			}
+		}
+	}
+
+	response := &colmetricspb.ExportMetricsServiceResponse{}
+	if rejected > 0 {
+		slog.Warn(fmt.Sprintf("rejected %d OTLP data points: %s", rejected, rejectedReason))
+		response.PartialSuccess = &colmetricspb.ExportMetricsPartialSuccess{
+			RejectedDataPoints: rejected,
+			ErrorMessage:       rejectedReason,
+		}
+	}
+	return response
+}
+
+// otlpDataPoint is a gauge, sum or histogram data point reduced to the value kept by the
+// receiver
+type otlpDataPoint struct {
+	kind        string
+	attributes  []*commonpb.KeyValue
+	value       float64
+	cumulative  bool
+	startTimeNs uint64
+}
+
+func getOTLPDataPoints(metric *metricspb.Metric) []otlpDataPoint {
+	points := []otlpDataPoint{}
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, point := range data.Gauge.GetDataPoints() {
+			points = append(points, otlpDataPoint{kind: otlpSeriesKindGauge, attributes: point.GetAttributes(), value: getOTLPNumberValue(point)})
+		}
+	case *metricspb.Metric_Sum:
+		// non monotonic sums, such as up down counters, are kept like gauges
+		kind := otlpSeriesKindEvents
+		if !data.Sum.GetIsMonotonic() {
+			kind = otlpSeriesKindGauge
+		}
+		cumulative := data.Sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, point := range data.Sum.GetDataPoints() {
+			points = append(points, otlpDataPoint{kind: kind, attributes: point.GetAttributes(), value: getOTLPNumberValue(point), cumulative: cumulative && kind == otlpSeriesKindEvents, startTimeNs: point.GetStartTimeUnixNano()})
+		}
+	case *metricspb.Metric_Histogram:
+		cumulative := data.Histogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, point := range data.Histogram.GetDataPoints() {
+			points = append(points, otlpDataPoint{kind: otlpSeriesKindEvents, attributes: point.GetAttributes(), value: float64(point.GetCount()), cumulative: cumulative, startTimeNs: point.GetStartTimeUnixNano()})
+		}
+	case *metricspb.Metric_ExponentialHistogram:
+		cumulative := data.ExponentialHistogram.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+		for _, point := range data.ExponentialHistogram.GetDataPoints() {
+			points = append(points, otlpDataPoint{kind: otlpSeriesKindEvents, attributes: point.GetAttributes(), value: float64(point.GetCount()), cumulative: cumulative, startTimeNs: point.GetStartTimeUnixNano()})
+		}
+	default:
+		// summaries are not aggregatable across series
+		points = append(points, otlpDataPoint{})
+	}
+	return points
+}
+
+func getOTLPNumberValue(point *metricspb.NumberDataPoint) float64 {
+	if value, ok := point.GetValue().(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(value.AsInt)
+	}
+	return point.GetAsDouble()
+}
+
+// getSeries returns the series of the metric and attributes, creating it unless MaxSeries is
+// reached, in which case nil is returned
+func (o *OTLPReceiver) getSeries(metric string, kind string, resourceAttributes []*commonpb.KeyValue, pointAttributes []*commonpb.KeyValue, now time.Time) *otlpSeries {
+	attributes := map[string]string{}
+	for _, attribute := range resourceAttributes {
+		attributes[attribute.GetKey()] = getOTLPAttributeValue(attribute.GetValue())
+	}
+	// data point attributes take precedence over resource attributes with the same key
+	for _, attribute := range pointAttributes {
+		attributes[attribute.GetKey()] = getOTLPAttributeValue(attribute.GetValue())
+	}
+
+	key := getOTLPSeriesKey(metric, kind, attributes)
+	if series, ok := o.series[key]; ok {
+		return series
+	}
+	if len(o.series) >= o.maxSeries {
+		return nil
+	}
+	series := &otlpSeries{metric: metric, attributes: attributes, kind: kind, lastSeen: now}
+	o.series[key] = series
+	return series
+}
+
+func getOTLPSeriesKey(metric string, kind string, attributes map[string]string) string {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// attribute values can hold any character but NUL
+	var seriesKey strings.Builder
+	seriesKey.WriteString(metric + "\x00" + kind)
+	for _, key := range keys {
+		seriesKey.WriteString("\x00" + key + "=" + attributes[key])
+	}
+	return seriesKey.String()
+}
+
+func getOTLPAttributeValue(value *commonpb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return strconv.FormatBool(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return strconv.FormatInt(v.IntValue, 10)
+	case *commonpb.AnyValue_DoubleValue:
+		return strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+	default:
+		// arrays, maps and bytes can't be matched by attribute filters
+		return ""
+	}
+}
+
+func (s *otlpSeries) add(point otlpDataPoint, now time.Time, retention time.Duration) {
+	s.lastSeen = now
+	value := point.value
+
+	if point.cumulative {
+		previous, hasPrevious := s.lastCumulative, s.hasCumulative
+		restarted := hasPrevious && (value < previous || point.startTimeNs != s.lastStartTimeNs)
+		s.hasCumulative, s.lastCumulative, s.lastStartTimeNs = true, value, point.startTimeNs
+
+		switch {
+		case restarted:
+			// a lower value or a new start time means the process restarted, its count
+			// starts over
+		case hasPrevious:
+			value -= previous
+		case point.startTimeNs == 0 || now.Sub(time.Unix(0, int64(point.startTimeNs))) > retention:
+			// the first data point of a series is only a baseline, unless the count started
+			// within the retention, e.g. a worker that just started
+			return
+		}
+	}
+
+	if s.kind == otlpSeriesKindEvents && value == 0 {
+		return
+	}
+	if s.kind == otlpSeriesKindGauge {
+		// only the last value of a gauge is read
+		s.samples = append(s.samples[:0], otlpSample{time: now, value: value})
+		return
+	}
+	s.samples = append(s.samples, otlpSample{time: now, value: value})
+}
+
+// evictExpiredSeries drops samples older than the retention and series without samples
+// received within it
+func (o *OTLPReceiver) evictExpiredSeries(now time.Time) {
+	cutoff := now.Add(-o.retention)
+	for key, series := range o.series {
+		if series.lastSeen.Before(cutoff) {
+			delete(o.series, key)
+			continue
+		}
+		i := sort.Search(len(series.samples), func(i int) bool { return !series.samples[i].time.Before(cutoff) })
+		series.samples = series.samples[i:]
+	}
+}
+
+// aggregateWindow caps the window at the retention, the longest window samples are kept for
+func (o *OTLPReceiver) aggregateWindow(window time.Duration) time.Duration {
+	if window <= 0 || window > o.retention {
+		return o.retention
+	}
+	return window
+}
+
+// Aggregate returns the sum, over the series of the metric with all of the attributes, of the
+// events within the window, or of the last gauge values received within it. ok is false when
+// no series matched
+func (o *OTLPReceiver) Aggregate(metric string, attributes map[string]string, window time.Duration) (value float64, ok bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.now()
+	cutoff := now.Add(-o.aggregateWindow(window))
+
+	for _, series := range o.series {
+		if series.metric != metric || !matchesOTLPAttributes(series.attributes, attributes) {
+			continue
+		}
+		ok = true
+		if series.kind == otlpSeriesKindGauge {
+			if n := len(series.samples); n > 0 && series.samples[n-1].time.After(cutoff) {
+				value += series.samples[n-1].value
+			}
+			continue
+		}
+		for _, sample := range series.samples {
+			if sample.time.After(cutoff) {
+				value += sample.value
+			}
+		}
+	}
+	return value, ok
+}
+
+func matchesOTLPAttributes(seriesAttributes map[string]string, attributes map[string]string) bool {
+	for key, value := range attributes {
+		if seriesValue, ok := seriesAttributes[key]; !ok || seriesValue != value {
+			return false
+		}
+	}
+	return true
+}
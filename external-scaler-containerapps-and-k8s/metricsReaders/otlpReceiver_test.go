@@ -0,0 +1,291 @@
+package metricsReaders
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// the fixture's start time, within the retention of newFakeClockOTLPReceiver's clock
+var otlpTestStart = time.Unix(1700000000, 0)
+
+// newFakeClockOTLPReceiver returns a receiver whose clock is moved with the returned function,
+// receivers without a token accept unauthenticated exports
+func newFakeClockOTLPReceiver(config OTLPReceiverConfig) (*OTLPReceiver, func(time.Duration)) {
+	config.AllowUnauthenticated = config.Token == ""
+	receiver, _ := NewOTLPReceiver(config)
+	now := otlpTestStart.Add(time.Minute)
+	receiver.now = func() time.Time { return now }
+	return receiver, func(d time.Duration) { now = now.Add(d) }
+}
+
+func newOTLPStringAttribute(key string, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+// newOTLPHistogramRequest is a cumulative histogram data point of the retries histogram the
+// subscriber app records, for one worker
+func newOTLPHistogramRequest(instance string, startTime time.Time, count uint64) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				newOTLPStringAttribute("service.name", "subscriber-app"),
+				newOTLPStringAttribute("service.instance.id", instance),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "subscriber-app.openai.embeddings.retries",
+					Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+						DataPoints: []*metricspb.HistogramDataPoint{{
+							StartTimeUnixNano: uint64(startTime.UnixNano()),
+							Count:             count,
+						}},
+					}},
+				}},
+			}},
+		}},
+	}
+}
+
+func TestOTLPReceiverCumulativeHistogram(t *testing.T) {
+	receiver, advance := newFakeClockOTLPReceiver(OTLPReceiverConfig{})
+	retries := "subscriber-app.openai.embeddings.retries"
+
+	// calls are made in order, each sample is recorded a minute after the previous one
+	testCases := []struct {
+		name      string
+		instance  string
+		startTime time.Time
+		count     uint64
+		window    time.Duration
+		expected  float64
+	}{
+		{name: "worker started within the retention", instance: "a", startTime: otlpTestStart, count: 3, window: time.Minute, expected: 3},
+		{name: "increase since the previous data point", instance: "a", startTime: otlpTestStart, count: 5, window: time.Minute, expected: 2},
+		{name: "window over both data points", instance: "a", startTime: otlpTestStart, count: 5, window: 5 * time.Minute, expected: 5},
+		{name: "restarted worker counts from zero", instance: "a", startTime: otlpTestStart.Add(3 * time.Minute), count: 1, window: time.Minute, expected: 1},
+		{name: "long running worker is a baseline", instance: "b", startTime: otlpTestStart.Add(-time.Hour), count: 100, window: time.Minute, expected: 0},
+		{name: "workers are summed", instance: "b", startTime: otlpTestStart.Add(-time.Hour), count: 104, window: 3 * time.Minute, expected: 5},
+	}
+
+	for _, tc := range testCases {
+		advance(time.Minute)
+		receiver.record(newOTLPHistogramRequest(tc.instance, tc.startTime, tc.count))
+
+		value, ok := receiver.Aggregate(retries, map[string]string{"service.name": "subscriber-app"}, tc.window)
+		if !ok || value != tc.expected {
+			t.Errorf("Expected %v, but got %v (matched: %t) (%s)", tc.expected, value, ok, tc.name)
+		}
+	}
+
+	// samples older than the retention are evicted with their series
+	advance(DEFAULT_OTLP_RETENTION + time.Minute)
+	receiver.record(&colmetricspb.ExportMetricsServiceRequest{})
+	if _, ok := receiver.Aggregate(retries, nil, 0); ok {
+		t.Errorf("Expected the expired series to be evicted")
+	}
+}
+
+// newOTLPGaugeRequest is a gauge data point of the queue depth a worker reports
+func newOTLPGaugeRequest(instance string, depth int64) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				newOTLPStringAttribute("service.instance.id", instance),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "queue.depth",
+					Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{
+						DataPoints: []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: depth}}},
+					}},
+				}},
+			}},
+		}},
+	}
+}
+
+func TestOTLPReceiverGaugeKeepsLastValue(t *testing.T) {
+	receiver, advance := newFakeClockOTLPReceiver(OTLPReceiverConfig{})
+
+	for depth := int64(1); depth <= 100; depth++ {
+		advance(time.Second)
+		receiver.record(newOTLPGaugeRequest("a", depth))
+	}
+	receiver.record(newOTLPGaugeRequest("b", 5))
+
+	if value, ok := receiver.Aggregate("queue.depth", nil, time.Minute); !ok || value != 105 {
+		t.Errorf("Expected 105, but got %v (matched: %t)", value, ok)
+	}
+	for _, series := range receiver.series {
+		if len(series.samples) != 1 {
+			t.Errorf("Expected 1 sample kept, but got %d (%v)", len(series.samples), series.attributes)
+		}
+	}
+}
+
+func TestNewOTLPReceiverRequiresToken(t *testing.T) {
+	if _, err := NewOTLPReceiver(OTLPReceiverConfig{}); err == nil {
+		t.Errorf("Expected an error creating a receiver without a token")
+	}
+	if _, err := NewOTLPReceiver(OTLPReceiverConfig{AllowUnauthenticated: true}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestOTLPReceiverHTTP(t *testing.T) {
+	receiver, _ := newFakeClockOTLPReceiver(OTLPReceiverConfig{MaxRequestBytes: 4096, Token: "secret"})
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	jsonBody, err := os.ReadFile(filepath.Join("testdata", "otlp_metrics.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	protobufBody, err := proto.Marshal(newOTLPHistogramRequest("a", otlpTestStart, 2))
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	var gzipBody bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipBody)
+	_, _ = gzipWriter.Write(protobufBody)
+	gzipWriter.Close()
+
+	testCases := []struct {
+		name               string
+		contentType        string
+		contentEncoding    string
+		authorization      string
+		body               []byte
+		expectedStatusCode int
+	}{
+		{name: "json", contentType: "application/json", authorization: "Bearer secret", body: jsonBody, expectedStatusCode: http.StatusOK},
+		{name: "gzip protobuf", contentType: "application/x-protobuf", contentEncoding: "gzip", authorization: "Bearer secret", body: gzipBody.Bytes(), expectedStatusCode: http.StatusOK},
+		{name: "missing token", contentType: "application/json", body: jsonBody, expectedStatusCode: http.StatusUnauthorized},
+		{name: "wrong token", contentType: "application/json", authorization: "Bearer other", body: jsonBody, expectedStatusCode: http.StatusUnauthorized},
+		{name: "unsupported content type", contentType: "text/plain", authorization: "Bearer secret", body: jsonBody, expectedStatusCode: http.StatusUnsupportedMediaType},
+		{name: "invalid body", contentType: "application/x-protobuf", authorization: "Bearer secret", body: []byte("not protobuf"), expectedStatusCode: http.StatusBadRequest},
+		{name: "body too large", contentType: "application/json", authorization: "Bearer secret", body: bytes.Repeat([]byte(" "), 5000), expectedStatusCode: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		req, _ := http.NewRequest("POST", server.URL+OTLP_HTTP_METRICS_PATH, bytes.NewReader(tc.body))
+		req.Header.Set("Content-Type", tc.contentType)
+		if tc.contentEncoding != "" {
+			req.Header.Set("Content-Encoding", tc.contentEncoding)
+		}
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v (%s)", err, tc.name)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.expectedStatusCode {
+			t.Errorf("Expected status %d, but got %d (%s)", tc.expectedStatusCode, resp.StatusCode, tc.name)
+		}
+	}
+
+	reader, err := NewOTLPMetricsReader(OTLPMetricsReaderConfig{
+		Receiver:                receiver,
+		QueueLengthMetric:       "queue.depth",
+		Rate429ErrorsMetric:     "subscriber-app.openai.embeddings.requests",
+		Rate429ErrorsAttributes: map[string]string{"status": "429"},
+		Window:                  time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	if queueLength, err := reader.GetQueueLength(context.Background()); err != nil || queueLength != 12 {
+		t.Errorf("Expected a queue length of 12, but got %d (%v)", queueLength, err)
+	}
+	if rate429Errors, err := reader.GetRate429Errors(context.Background()); err != nil || rate429Errors != 4 {
+		t.Errorf("Expected 4 rate 429 errors, but got %d (%v)", rate429Errors, err)
+	}
+	// the 429 errors are normalized to a per minute rate over the window
+	reader, err = NewOTLPMetricsReader(OTLPMetricsReaderConfig{
+		Receiver:                receiver,
+		Rate429ErrorsMetric:     "subscriber-app.openai.embeddings.requests",
+		Rate429ErrorsAttributes: map[string]string{"status": "429"},
+		Window:                  2 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	if rate429Errors, err := reader.GetRate429Errors(context.Background()); err != nil || rate429Errors != 2 {
+		t.Errorf("Expected 2 rate 429 errors per minute, but got %d (%v)", rate429Errors, err)
+	}
+	if value, ok := receiver.Aggregate("subscriber-app.openai.embeddings.retries", nil, time.Minute); !ok || value != 2 {
+		t.Errorf("Expected 2 retries from the gzip request, but got %v (matched: %t)", value, ok)
+	}
+}
+
+func TestOTLPReceiverGRPC(t *testing.T) {
+	receiver, _ := newFakeClockOTLPReceiver(OTLPReceiverConfig{MaxSeries: 1, Token: "secret"})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(grpcServer, receiver)
+	go func() { _ = grpcServer.Serve(listener) }()
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	client := colmetricspb.NewMetricsServiceClient(conn)
+
+	if _, err := client.Export(context.Background(), newOTLPHistogramRequest("a", otlpTestStart, 3)); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected %v without a token, but got %v", codes.Unauthenticated, err)
+	}
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	response, err := client.Export(ctx, newOTLPHistogramRequest("a", otlpTestStart, 3))
+	if err != nil || response.GetPartialSuccess() != nil {
+		t.Fatalf("Unexpected export result: %v %v", response, err)
+	}
+
+	// the second worker's series is over MaxSeries
+	response, err = client.Export(ctx, newOTLPHistogramRequest("b", otlpTestStart, 7))
+	if err != nil || response.GetPartialSuccess().GetRejectedDataPoints() != 1 {
+		t.Errorf("Expected 1 rejected data point, but got %v %v", response, err)
+	}
+
+	if value, ok := receiver.Aggregate("subscriber-app.openai.embeddings.retries", nil, time.Minute); !ok || value != 3 {
+		t.Errorf("Expected 3, but got %v (matched: %t)", value, ok)
+	}
+}
+
+func TestParseOTLPAttributes(t *testing.T) {
+	attributes, err := ParseOTLPAttributes(" status=429, service.name = subscriber-app ,")
+	if err != nil || len(attributes) != 2 || attributes["status"] != "429" || attributes["service.name"] != "subscriber-app" {
+		t.Errorf("Unexpected attributes %v (%v)", attributes, err)
+	}
+	if _, err := ParseOTLPAttributes("status"); err == nil {
+		t.Errorf("Expected an error for an attribute without a value")
+	}
+}
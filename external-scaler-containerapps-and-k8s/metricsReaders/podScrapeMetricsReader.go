@@ -0,0 +1,339 @@
+package metricsReaders
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	SCRAPE_REDUCER_SUM = "sum"
+	SCRAPE_REDUCER_MAX = "max"
+	SCRAPE_REDUCER_MIN = "min"
+	SCRAPE_REDUCER_AVG = "avg"
+
+	DEFAULT_SCRAPE_PATH               = "/metrics"
+	DEFAULT_SCRAPE_TIMEOUT            = 5 * time.Second
+	DEFAULT_SCRAPE_MAX_RESPONSE_BYTES = 10 * 1024 * 1024
+
+	// the pod annotations of the prometheus.io scrape convention
+	scrapePortAnnotation = "prometheus.io/port"
+	scrapePathAnnotation = "prometheus.io/path"
+)
+
+type PodScrapeMetricsReaderConfig struct {
+	// Namespace and Deployment select the pods scraped, through the deployment's selector
+	Namespace  string
+	Deployment string
+	// Port and Path of the pods' Prometheus exposition endpoint. Without them the
+	// prometheus.io/port and prometheus.io/path pod annotations are used, then the first
+	// container port and DEFAULT_SCRAPE_PATH
+	Port int
+	Path string
+	// MsgQueueLengthMetricName and Rate429ErrorsMetricName are the metric families read,
+	// samples of a family with several label sets are summed per pod
+	MsgQueueLengthMetricName string
+	Rate429ErrorsMetricName  string
+	// Reducer aggregates the pods' values, one of the SCRAPE_REDUCER_ constants, defaults to
+	// SCRAPE_REDUCER_MAX as pods usually report the same shared queue
+	Reducer string
+	// Timeout limits each scrape, defaults to DEFAULT_SCRAPE_TIMEOUT
+	Timeout time.Duration
+	// MaxResponseBytes fails scrapes with larger bodies, defaults to
+	// DEFAULT_SCRAPE_MAX_RESPONSE_BYTES
+	MaxResponseBytes int64
+	// Clientset lists the deployment's pods, defaults to an in cluster clientset
+	Clientset kubernetes.Interface
+	// HTTPClient makes the scrapes, defaults to a new http.Client
+	HTTPClient *http.Client
+}
+
+type PodScrapeMetricsReader struct {
+	namespace                string
+	deployment               string
+	port                     int
+	path                     string
+	msgQueueLengthMetricName string
+	rate429ErrorsMetricName  string
+	reducer                  string
+	timeout                  time.Duration
+	maxResponseBytes         int64
+	clientset                kubernetes.Interface
+	httpClient               *http.Client
+}
+
+func NewPodScrapeMetricsReader(config PodScrapeMetricsReaderConfig) (*PodScrapeMetricsReader, error) {
+	if config.Namespace == "" || config.Deployment == "" {
+		return nil, fmt.Errorf("the namespace and deployment of the scraped pods are required")
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid scrape port %d", config.Port)
+	}
+	if config.Path != "" && !strings.HasPrefix(config.Path, "/") {
+		return nil, fmt.Errorf("invalid scrape path %q, expected an absolute path such as /metrics", config.Path)
+	}
+
+	reducer := config.Reducer
+	if reducer == "" {
+		reducer = SCRAPE_REDUCER_MAX
+	}
+	switch reducer {
+	case SCRAPE_REDUCER_SUM, SCRAPE_REDUCER_MAX, SCRAPE_REDUCER_MIN, SCRAPE_REDUCER_AVG:
+	default:
+		return nil, fmt.Errorf("unsupported scrape reducer %q, supported reducers are %s, %s, %s and %s", reducer, SCRAPE_REDUCER_SUM, SCRAPE_REDUCER_MAX, SCRAPE_REDUCER_MIN, SCRAPE_REDUCER_AVG)
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_SCRAPE_TIMEOUT
+	}
+	maxResponseBytes := config.MaxResponseBytes
+	if maxResponseBytes <= 0 {
+		maxResponseBytes = DEFAULT_SCRAPE_MAX_RESPONSE_BYTES
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	clientset := config.Clientset
+	if clientset == nil {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get in cluster config: %w", err)
+		}
+		clientset, err = kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create clientset: %w", err)
+		}
+	}
+
+	return &PodScrapeMetricsReader{
+		namespace:                config.Namespace,
+		deployment:               config.Deployment,
+		port:                     config.Port,
+		path:                     config.Path,
+		msgQueueLengthMetricName: config.MsgQueueLengthMetricName,
+		rate429ErrorsMetricName:  config.Rate429ErrorsMetricName,
+		reducer:                  reducer,
+		timeout:                  timeout,
+		maxResponseBytes:         maxResponseBytes,
+		clientset:                clientset,
+		httpClient:               httpClient,
+	}, nil
+}
+
+func (p *PodScrapeMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	return p.getMetricValue(ctx, p.msgQueueLengthMetricName)
+}
+
+func (p *PodScrapeMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	return p.getMetricValue(ctx, p.rate429ErrorsMetricName)
+}
+
+// getMetricValue scrapes the deployment's ready pods concurrently and reduces their values of
+// the metric. Pods that fail to be scraped are skipped, unless all of them do. Without ready
+// pods nothing reports the metric, so it is an error rather than 0: a deployment scaled to zero
+// could otherwise never scale back up
+func (p *PodScrapeMetricsReader) getMetricValue(ctx context.Context, metricName string) (int, error) {
+	targets, err := p.getScrapeTargets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(targets) == 0 {
+		return 0, fmt.Errorf("no ready pods of deployment %s/%s to scrape %s from", p.namespace, p.deployment, metricName)
+	}
+
+	values := make([]float64, len(targets))
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values[i], errs[i] = p.scrape(ctx, target, metricName)
+		}()
+	}
+	wg.Wait()
+
+	scraped := []float64{}
+	var lastErr error
+	for i, err := range errs {
+		if err != nil {
+			slog.Warn(fmt.Sprintf("could not scrape %s: %v", targets[i], err))
+			lastErr = err
+			continue
+		}
+		scraped = append(scraped, values[i])
+	}
+	if len(scraped) == 0 {
+		return 0, fmt.Errorf("could not scrape any of the %d pods of deployment %s/%s: %w", len(targets), p.namespace, p.deployment, lastErr)
+	}
+
+	value := reduceScrapedValues(p.reducer, scraped)
+	slog.Debug(fmt.Sprintf("%s %s of %d pods: %v", p.reducer, metricName, len(scraped), value))
+	return int(math.Round(value)), nil
+}
+
+// getScrapeTargets returns the URLs of the ready pods selected by the deployment
+func (p *PodScrapeMetricsReader) getScrapeTargets(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	deployment, err := p.clientset.AppsV1().Deployments(p.namespace).Get(ctx, p.deployment, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of deployment %s/%s: %w", p.namespace, p.deployment, err)
+	}
+	pods, err := p.clientset.CoreV1().Pods(p.namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods of deployment %s/%s: %w", p.namespace, p.deployment, err)
+	}
+
+	targets := []string{}
+	for _, pod := range pods.Items {
+		if !isPodReady(pod) || pod.Status.PodIP == "" {
+			continue
+		}
+		port, path := p.port, p.path
+		if port == 0 {
+			port = getPodScrapePort(pod)
+		}
+		if port == 0 {
+			slog.Warn(fmt.Sprintf("pod %s/%s has no scrape port, set one with the %s annotation", pod.Namespace, pod.Name, scrapePortAnnotation))
+			continue
+		}
+		if path == "" {
+			path = pod.Annotations[scrapePathAnnotation]
+		}
+		if path == "" {
+			path = DEFAULT_SCRAPE_PATH
+		}
+		targets = append(targets, "http://"+net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(port))+path)
+	}
+	return targets, nil
+}
+
+func isPodReady(pod corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// getPodScrapePort returns the prometheus.io/port annotation, or the first container port, or 0
+func getPodScrapePort(pod corev1.Pod) int {
+	if port, err := strconv.Atoi(pod.Annotations[scrapePortAnnotation]); err == nil {
+		return port
+	}
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Protocol == "" || port.Protocol == corev1.ProtocolTCP {
+				return int(port.ContainerPort)
+			}
+		}
+	}
+	return 0
+}
+
+// scrape returns the sum of the samples of the metric in a pod's text exposition, a pod not
+// exposing the metric is an error
+func (p *PodScrapeMetricsReader) scrape(ctx context.Context, target string, metricName string) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create get request: %w", err)
+	}
+	// the text format is the one every Prometheus client library can expose
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	req.Header.Set("User-Agent", "Go HTTP Client")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not make request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkResponseStatus(resp); err != nil {
+		return 0, err
+	}
+
+	// one byte more than the limit is read to tell a body at the limit from a larger one
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.maxResponseBytes+1))
+	if err != nil {
+		return 0, fmt.Errorf("could not read response body: %w", err)
+	}
+	if int64(len(body)) > p.maxResponseBytes {
+		return 0, fmt.Errorf("metrics response body is larger than %d bytes", p.maxResponseBytes)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse metrics: %w", err)
+	}
+
+	family, ok := families[metricName]
+	if !ok {
+		return 0, fmt.Errorf("metric %s not found", metricName)
+	}
+	value := 0.0
+	for _, metric := range family.GetMetric() {
+		value += getSampleValue(family.GetType(), metric)
+	}
+	return value, nil
+}
+
+func getSampleValue(metricType dto.MetricType, metric *dto.Metric) float64 {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		return metric.GetCounter().GetValue()
+	case dto.MetricType_GAUGE:
+		return metric.GetGauge().GetValue()
+	default:
+		return metric.GetUntyped().GetValue()
+	}
+}
+
+func reduceScrapedValues(reducer string, values []float64) float64 {
+	result := values[0]
+	for _, value := range values[1:] {
+		switch reducer {
+		case SCRAPE_REDUCER_MAX:
+			result = math.Max(result, value)
+		case SCRAPE_REDUCER_MIN:
+			result = math.Min(result, value)
+		default:
+			result += value
+		}
+	}
+	if reducer == SCRAPE_REDUCER_AVG {
+		result /= float64(len(values))
+	}
+	return result
+}
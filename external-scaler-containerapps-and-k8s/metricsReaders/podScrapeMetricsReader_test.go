@@ -0,0 +1,184 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newFakeScrapeTarget serves a testdata fixture on /metrics, or an error status when fixture
+// is empty, and returns the server's port
+func newFakeScrapeTarget(t *testing.T, fixture string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics" || fixture == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Errorf("Failed to read fixture %s: %v", fixture, err)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, _ := url.Parse(server.URL)
+	return serverURL.Port()
+}
+
+func newFakeScrapePod(name string, port string, ready bool) *corev1.Pod {
+	readyStatus := corev1.ConditionFalse
+	if ready {
+		readyStatus = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      map[string]string{"app": "subscriber"},
+			Annotations: map[string]string{scrapePortAnnotation: port},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "127.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: readyStatus}},
+		},
+	}
+}
+
+func newFakeScrapeClientset(pods ...*corev1.Pod) *fake.Clientset {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "subscriber", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "subscriber"}},
+		},
+	}
+	other := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "default", Labels: map[string]string{"app": "other"}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "127.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(deployment, other)
+	for _, pod := range pods {
+		_ = clientset.Tracker().Add(pod)
+	}
+	return clientset
+}
+
+func TestPodScrapeMetricsReader(t *testing.T) {
+	portA := newFakeScrapeTarget(t, "scrape_pod_metrics.txt")
+	portB := newFakeScrapeTarget(t, "scrape_pod_metrics_labelled.txt")
+	portFailing := newFakeScrapeTarget(t, "")
+
+	pods := []*corev1.Pod{
+		newFakeScrapePod("subscriber-a", portA, true),
+		newFakeScrapePod("subscriber-b", portB, true),
+		// not ready pods are not scraped
+		newFakeScrapePod("subscriber-c", portFailing, false),
+	}
+
+	testCases := []struct {
+		name          string
+		pods          []*corev1.Pod
+		reducer       string
+		rate429Errors bool
+		metricName    string
+		expected      int
+		expectedError string
+	}{
+		{name: "default max reducer", pods: pods, expected: 30},
+		{name: "sum reducer, labelled samples are summed", pods: pods, reducer: SCRAPE_REDUCER_SUM, expected: 40},
+		{name: "min reducer", pods: pods, reducer: SCRAPE_REDUCER_MIN, expected: 10},
+		{name: "avg reducer", pods: pods, reducer: SCRAPE_REDUCER_AVG, rate429Errors: true, expected: 4},
+		{name: "counter exposed by one pod", pods: pods, reducer: SCRAPE_REDUCER_SUM, metricName: "promhttp_metric_handler_requests_total", expected: 12},
+		{
+			name:     "failing pod is skipped",
+			pods:     []*corev1.Pod{newFakeScrapePod("subscriber-a", portA, true), newFakeScrapePod("subscriber-c", portFailing, true)},
+			reducer:  SCRAPE_REDUCER_SUM,
+			expected: 30,
+		},
+		{
+			name:          "all pods failing",
+			pods:          []*corev1.Pod{newFakeScrapePod("subscriber-c", portFailing, true)},
+			expectedError: "could not scrape any of the 1 pods",
+		},
+		{name: "no ready pods", pods: []*corev1.Pod{}, expectedError: "no ready pods of deployment default/subscriber"},
+	}
+
+	for _, tc := range testCases {
+		metricName := tc.metricName
+		if metricName == "" {
+			metricName = "msg_queue_length"
+		}
+		reader, err := NewPodScrapeMetricsReader(PodScrapeMetricsReaderConfig{
+			Namespace:                "default",
+			Deployment:               "subscriber",
+			MsgQueueLengthMetricName: metricName,
+			Rate429ErrorsMetricName:  "rate_429_errors",
+			Reducer:                  tc.reducer,
+			Clientset:                newFakeScrapeClientset(tc.pods...),
+		})
+		if err != nil {
+			t.Fatalf("Failed to create reader: %v (%s)", err, tc.name)
+		}
+
+		var result int
+		if tc.rate429Errors {
+			result, err = reader.GetRate429Errors(context.Background())
+		} else {
+			result, err = reader.GetQueueLength(context.Background())
+		}
+
+		if tc.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected error containing %q, but got %v (%s)", tc.expectedError, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, result, tc.name)
+		}
+	}
+}
+
+func TestPodScrapeMetricsReaderResponseSizeLimit(t *testing.T) {
+	port := newFakeScrapeTarget(t, "scrape_pod_metrics.txt")
+
+	reader, err := NewPodScrapeMetricsReader(PodScrapeMetricsReaderConfig{
+		Namespace:                "default",
+		Deployment:               "subscriber",
+		MsgQueueLengthMetricName: "msg_queue_length",
+		MaxResponseBytes:         64,
+		Clientset:                newFakeScrapeClientset(newFakeScrapePod("subscriber-a", port, true)),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+
+	_, err = reader.GetQueueLength(context.Background())
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("larger than %d bytes", 64)) {
+		t.Errorf("Expected a response size error, but got %v", err)
+	}
+}
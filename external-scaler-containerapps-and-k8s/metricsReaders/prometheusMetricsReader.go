@@ -3,6 +3,7 @@ package metricsReaders
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -12,21 +13,37 @@ import (
 	"github.com/prometheus/common/model"
 )
 
+type PrometheusMetricsReaderConfig struct {
+	PrometheusEndpoint       string
+	MsgQueueLengthMetricName string
+	Rate429ErrorsMetricName  string
+
+	// user defined PromQL queries, rendered before being set here. When set, these are
+	// evaluated instead of the bare metric names
+	QueueLengthQuery string
+	ErrorRateQuery   string
+}
+
 type PrometheusMetricsReader struct {
 	PROMETHEUS_ENDPOINT          string
 	MSG_QUEUE_LENGTH_METRIC_NAME string
 	RATE_429_ERRORS_METRIC_NAME  string
+
+	QUEUE_LENGTH_QUERY string
+	ERROR_RATE_QUERY   string
 }
 
-func NewPrometheusMetricsReader(prometheusEndpoint string, msqQueueLengthMetricName string, rate429ErrorMetricName string) *PrometheusMetricsReader {
+func NewPrometheusMetricsReader(config PrometheusMetricsReaderConfig) *PrometheusMetricsReader {
 	return &PrometheusMetricsReader{
-		PROMETHEUS_ENDPOINT:          prometheusEndpoint,
-		MSG_QUEUE_LENGTH_METRIC_NAME: msqQueueLengthMetricName,
-		RATE_429_ERRORS_METRIC_NAME:  rate429ErrorMetricName,
+		PROMETHEUS_ENDPOINT:          config.PrometheusEndpoint,
+		MSG_QUEUE_LENGTH_METRIC_NAME: config.MsgQueueLengthMetricName,
+		RATE_429_ERRORS_METRIC_NAME:  config.Rate429ErrorsMetricName,
+		QUEUE_LENGTH_QUERY:           config.QueueLengthQuery,
+		ERROR_RATE_QUERY:             config.ErrorRateQuery,
 	}
 }
 
-func (p *PrometheusMetricsReader) GetMetricValue(metricName string) (int, error) {
+func (p *PrometheusMetricsReader) GetMetricValue(ctx context.Context, metricName string) (int, error) {
 	// Create a new Prometheus API client
 	client, err := api.NewClient(api.Config{
 		Address: p.PROMETHEUS_ENDPOINT,
@@ -51,7 +68,7 @@ func (p *PrometheusMetricsReader) GetMetricValue(metricName string) (int, error)
 	// query := fmt.Sprintf(`your_query_expression{metric="%s"}`, metricName)
 
 	// Execute the query
-	res, warnings, err := queryClient.Query(context.Background(), metricName, time.Now())
+	res, warnings, err := queryClient.Query(ctx, metricName, time.Now())
 	// queryClient.Q
 	if err != nil {
 		fmt.Println("Failed to execute query:", err)
@@ -87,11 +104,12 @@ func (p *PrometheusMetricsReader) GetMetricValue(metricName string) (int, error)
 	// fetch .data.result[0].value[1] from results json
 	// result.
 
-	metricVal, err := strconv.Atoi(resStrVal)
+	// user defined queries such as rate() return fractional values
+	metricVal, err := strconv.ParseFloat(resStrVal, 64)
 	if err != nil {
 		return 0, err
 	}
-	return metricVal, nil
+	return int(math.Round(metricVal)), nil
 	// Process the query result
 	// ...
 
@@ -99,12 +117,18 @@ func (p *PrometheusMetricsReader) GetMetricValue(metricName string) (int, error)
 	// fmt.Println(result)
 }
 
-func (p *PrometheusMetricsReader) GetQueueLength() (int, error) {
+func (p *PrometheusMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	if p.QUEUE_LENGTH_QUERY != "" {
+		return p.GetMetricValue(ctx, p.QUEUE_LENGTH_QUERY)
+	}
 	// Execute the query
-	return p.GetMetricValue(p.MSG_QUEUE_LENGTH_METRIC_NAME)
+	return p.GetMetricValue(ctx, p.MSG_QUEUE_LENGTH_METRIC_NAME)
 }
 
-func (p *PrometheusMetricsReader) GetRate429Errors() (int, error) {
+func (p *PrometheusMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	if p.ERROR_RATE_QUERY != "" {
+		return p.GetMetricValue(ctx, p.ERROR_RATE_QUERY)
+	}
 	// Execute the query
-	return p.GetMetricValue(p.RATE_429_ERRORS_METRIC_NAME)
+	return p.GetMetricValue(ctx, p.RATE_429_ERRORS_METRIC_NAME)
 }
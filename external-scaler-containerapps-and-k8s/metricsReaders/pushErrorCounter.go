@@ -0,0 +1,303 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DEFAULT_PUSH_RETENTION    = 10 * time.Minute
+	DEFAULT_PUSH_MAX_COUNTERS = 1000
+	DEFAULT_PUSH_STATUS       = http.StatusTooManyRequests
+	PUSH_ERRORS_PATH          = "/v1/errors"
+
+	// pushed counts are JSON objects of a few fields
+	maxPushRequestBytes = 4 * 1024
+	// a single push can't add more than this, to keep counters from overflowing
+	maxPushCount = 1000000
+	// counters keep at most this many buckets, longer retentions use buckets of several
+	// seconds so that memory doesn't grow with the retention
+	maxPushBuckets = 600
+)
+
+type PushErrorCounterConfig struct {
+	// Retention is how long counts are kept, the longest window readers can count over,
+	// defaults to DEFAULT_PUSH_RETENTION. Retentions over maxPushBuckets seconds are counted in
+	// coarser buckets
+	Retention time.Duration
+	// MaxCounters limits the scaled object and status pairs counted, pushes of further pairs
+	// are rejected. Defaults to DEFAULT_PUSH_MAX_COUNTERS
+	MaxCounters int
+	// Token is required as a bearer token on pushes. Without one the counter is only created
+	// with AllowUnauthenticated, as pushes drive scaling
+	Token string
+	// AllowUnauthenticated accepts pushes without a token when Token is not set, for ports
+	// only reachable by trusted workers
+	AllowUnauthenticated bool
+}
+
+// PushErrorCounter counts the errors workers push per scaled object and status code, in at
+// most maxPushBuckets buckets over the retention, so counts over a window are exact to the
+// second for retentions up to 10 minutes and to the bucket beyond. It is the http.Handler of
+// the push endpoint
+type PushErrorCounter struct {
+	retention            time.Duration
+	bucketSeconds        int64
+	buckets              int
+	maxCounters          int
+	token                string
+	allowUnauthenticated bool
+	now                  func() time.Time
+
+	mu       sync.Mutex
+	counters map[pushCounterKey]*pushCounter
+}
+
+type pushCounterKey struct {
+	scaledObject string
+	status       int
+}
+
+// pushCounter is a ring of per bucket counts, buckets[i] is the unix second divided by the
+// bucket seconds that counts[i] is for
+type pushCounter struct {
+	counts   []int64
+	buckets  []int64
+	lastSeen time.Time
+}
+
+// pushedErrors is the body of a push, e.g. {"scaledObject":"default/worker","status":429,"count":3}
+type pushedErrors struct {
+	ScaledObject string `json:"scaledObject"`
+	Status       int    `json:"status"`
+	Count        *int64 `json:"count"`
+}
+
+func NewPushErrorCounter(config PushErrorCounterConfig) (*PushErrorCounter, error) {
+	if config.Token == "" && !config.AllowUnauthenticated {
+		return nil, fmt.Errorf("a push receiver token is required, unless unauthenticated pushes are allowed")
+	}
+	retention := config.Retention
+	if retention < time.Second {
+		retention = DEFAULT_PUSH_RETENTION
+	}
+	maxCounters := config.MaxCounters
+	if maxCounters <= 0 {
+		maxCounters = DEFAULT_PUSH_MAX_COUNTERS
+	}
+	retentionSeconds := int64(retention / time.Second)
+	bucketSeconds := (retentionSeconds + maxPushBuckets - 1) / maxPushBuckets
+
+	return &PushErrorCounter{
+		retention:            retention,
+		bucketSeconds:        bucketSeconds,
+		buckets:              int((retentionSeconds + bucketSeconds - 1) / bucketSeconds),
+		maxCounters:          maxCounters,
+		token:                config.Token,
+		allowUnauthenticated: config.AllowUnauthenticated,
+		now:                  time.Now,
+		counters:             map[pushCounterKey]*pushCounter{},
+	}, nil
+}
+
+// ServeHTTP adds a pushed count, responding 204 No Content once it is counted
+func (p *PushErrorCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !isAuthorized(r.Header.Get("Authorization"), p.token, p.allowUnauthenticated) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); contentType != "application/json" {
+		http.Error(w, "unsupported content type, expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPushRequestBytes+1))
+	if err != nil {
+		http.Error(w, "could not read request body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxPushRequestBytes {
+		http.Error(w, fmt.Sprintf("request body is larger than %d bytes", maxPushRequestBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var pushed pushedErrors
+	if err := json.Unmarshal(body, &pushed); err != nil {
+		http.Error(w, fmt.Sprintf("could not decode request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	count := int64(1)
+	if pushed.Count != nil {
+		count = *pushed.Count
+	}
+	if err := validatePushedErrors(pushed.ScaledObject, pushed.Status, count); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := p.Add(pushed.ScaledObject, pushed.Status, count); err != nil {
+		slog.Warn(fmt.Sprintf("rejected pushed errors of %s: %v", pushed.ScaledObject, err))
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isAuthorized reports whether the Authorization header value holds the bearer token. Without
+// a token nothing is authorized, unless unauthenticated requests are allowed
+func isAuthorized(authorization string, token string, allowUnauthenticated bool) bool {
+	if token == "" {
+		return allowUnauthenticated
+	}
+	bearer, ok := strings.CutPrefix(authorization, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+}
+
+func validatePushedErrors(scaledObject string, status int, count int64) error {
+	namespace, name, ok := strings.Cut(scaledObject, "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("scaledObject must be namespace/name")
+	}
+	if status < 100 || status > 599 {
+		return fmt.Errorf("status must be a HTTP status code")
+	}
+	if count < 1 || count > maxPushCount {
+		return fmt.Errorf("count must be between 1 and %d", maxPushCount)
+	}
+	return nil
+}
+
+// Add counts errors of the scaled object, namespace/name, and status now. It fails when the
+// pair is new and MaxCounters are already kept
+func (p *PushErrorCounter) Add(scaledObject string, status int, count int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	key := pushCounterKey{scaledObject: scaledObject, status: status}
+	counter, ok := p.counters[key]
+	if !ok {
+		p.evictIdleCounters(now)
+		if len(p.counters) >= p.maxCounters {
+			return fmt.Errorf("the scaler counts at most %d scaled object and status pairs", p.maxCounters)
+		}
+		counter = &pushCounter{counts: make([]int64, p.buckets), buckets: make([]int64, p.buckets)}
+		p.counters[key] = counter
+	}
+
+	bucket := now.Unix() / p.bucketSeconds
+	i := int(bucket % int64(len(counter.counts)))
+	if counter.buckets[i] != bucket {
+		counter.buckets[i], counter.counts[i] = bucket, 0
+	}
+	counter.counts[i] += count
+	counter.lastSeen = now
+	return nil
+}
+
+// evictIdleCounters drops counters without pushes within the retention
+func (p *PushErrorCounter) evictIdleCounters(now time.Time) {
+	for key, counter := range p.counters {
+		if now.Sub(counter.lastSeen) > p.retention {
+			delete(p.counters, key)
+		}
+	}
+}
+
+// countWindow caps the window at the retention, the longest window counts are kept for
+func (p *PushErrorCounter) countWindow(window time.Duration) time.Duration {
+	if window <= 0 || window > p.retention {
+		return p.retention
+	}
+	return window
+}
+
+// Count returns the errors of the scaled object and status pushed within the window, capped at
+// the retention and rounded up to whole buckets
+func (p *PushErrorCounter) Count(scaledObject string, status int, window time.Duration) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	counter, ok := p.counters[pushCounterKey{scaledObject: scaledObject, status: status}]
+	if !ok {
+		return 0
+	}
+	windowSeconds := int64(p.countWindow(window) / time.Second)
+
+	now := p.now().Unix() / p.bucketSeconds
+	from := now - (windowSeconds+p.bucketSeconds-1)/p.bucketSeconds
+	total := int64(0)
+	for i, bucket := range counter.buckets {
+		if bucket > from && bucket <= now {
+			total += counter.counts[i]
+		}
+	}
+	return total
+}
+
+type PushMetricsReaderConfig struct {
+	Counter *PushErrorCounter
+	// ScaledObject is the namespace/name the errors are pushed for
+	ScaledObject string
+	// Status is the pushed status code counted, defaults to DEFAULT_PUSH_STATUS
+	Status int
+	// Window is the sliding window errors are counted over, defaults to the counter's retention
+	Window time.Duration
+}
+
+// PushMetricsReader reads the rate of 429 errors pushed to a PushErrorCounter, per minute over
+// its window like the other error sources, it has no queue length so it is only an error source
+type PushMetricsReader struct {
+	counter      *PushErrorCounter
+	scaledObject string
+	status       int
+	window       time.Duration
+}
+
+func NewPushMetricsReader(config PushMetricsReaderConfig) (*PushMetricsReader, error) {
+	if config.Counter == nil {
+		return nil, fmt.Errorf("the push receiver is not enabled on the scaler")
+	}
+	status := config.Status
+	if status == 0 {
+		status = DEFAULT_PUSH_STATUS
+	}
+	if err := validatePushedErrors(config.ScaledObject, status, 1); err != nil {
+		return nil, err
+	}
+
+	return &PushMetricsReader{
+		counter:      config.Counter,
+		scaledObject: config.ScaledObject,
+		status:       status,
+		window:       config.Window,
+	}, nil
+}
+
+func (p *PushMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	return 0, fmt.Errorf("pushed errors have no queue length")
+}
+
+func (p *PushMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	window := p.counter.countWindow(p.window)
+	count := p.counter.Count(p.scaledObject, p.status, window)
+	slog.Debug(fmt.Sprintf("pushed %d errors of %s over %v: %d", p.status, p.scaledObject, window, count))
+	// normalized to a per minute rate, so that RATE_429_ERROR_THRESHOLD keeps its meaning
+	// whatever the window
+	return int(math.Round(float64(count) / window.Minutes())), nil
+}
@@ -0,0 +1,284 @@
+package metricsReaders
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeClockPushErrorCounter returns a counter whose clock is moved with the returned function,
+// counts are added directly so pushes are not authenticated
+func newFakeClockPushErrorCounter(config PushErrorCounterConfig) (*PushErrorCounter, func(time.Duration)) {
+	config.AllowUnauthenticated = true
+	counter, _ := NewPushErrorCounter(config)
+	now := time.Unix(1700000000, 0)
+	counter.now = func() time.Time { return now }
+	return counter, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestPushErrorCounterSlidingWindow(t *testing.T) {
+	counter, advance := newFakeClockPushErrorCounter(PushErrorCounterConfig{Retention: 5 * time.Minute})
+
+	// calls are made in order, each count is pushed 30 seconds after the previous one
+	testCases := []struct {
+		name     string
+		status   int
+		count    int64
+		window   time.Duration
+		expected int64
+	}{
+		{name: "first push", status: 429, count: 3, window: time.Minute, expected: 3},
+		{name: "second push within the window", status: 429, count: 2, window: time.Minute, expected: 5},
+		{name: "first push slid out of the window", status: 429, count: 1, window: time.Minute, expected: 3},
+		{name: "other status is counted apart", status: 503, count: 4, window: time.Minute, expected: 1},
+		{name: "window over all pushes", status: 429, count: 1, window: 5 * time.Minute, expected: 7},
+		{name: "window longer than the retention", status: 429, count: 1, window: time.Hour, expected: 8},
+	}
+
+	for _, tc := range testCases {
+		advance(30 * time.Second)
+		if err := counter.Add("default/worker", tc.status, tc.count); err != nil {
+			t.Fatalf("Unexpected error %v (%s)", err, tc.name)
+		}
+
+		actual := counter.Count("default/worker", 429, tc.window)
+		if actual != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, actual, tc.name)
+		}
+	}
+
+	// the ring reuses the buckets of seconds older than the retention
+	advance(5 * time.Minute)
+	if actual := counter.Count("default/worker", 429, time.Hour); actual != 0 {
+		t.Errorf("Expected %d, but got %d (%s)", 0, actual, "pushes older than the retention")
+	}
+}
+
+func TestPushErrorCounterCoarseBuckets(t *testing.T) {
+	counter, advance := newFakeClockPushErrorCounter(PushErrorCounterConfig{Retention: 2 * time.Hour})
+
+	// two hours are kept in maxPushBuckets buckets of 12 seconds
+	if counter.bucketSeconds != 12 || counter.buckets != maxPushBuckets {
+		t.Fatalf("Expected %d buckets of %d seconds, but got %d of %d", maxPushBuckets, 12, counter.buckets, counter.bucketSeconds)
+	}
+
+	if err := counter.Add("default/worker", 429, 3); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	advance(time.Hour)
+	if err := counter.Add("default/worker", 429, 2); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		window   time.Duration
+		expected int64
+	}{
+		{name: "last minute", window: time.Minute, expected: 2},
+		{name: "both pushes", window: 2 * time.Hour, expected: 5},
+	}
+
+	for _, tc := range testCases {
+		actual := counter.Count("default/worker", 429, tc.window)
+		if actual != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, actual, tc.name)
+		}
+	}
+
+	// the ring reuses the buckets older than the retention
+	advance(2 * time.Hour)
+	if actual := counter.Count("default/worker", 429, 2*time.Hour); actual != 0 {
+		t.Errorf("Expected %d, but got %d (%s)", 0, actual, "pushes older than the retention")
+	}
+}
+
+func TestPushMetricsReaderPerMinuteRate(t *testing.T) {
+	counter, _ := newFakeClockPushErrorCounter(PushErrorCounterConfig{Retention: 10 * time.Minute})
+	if err := counter.Add("default/worker", 429, 30); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		window   time.Duration
+		expected int
+	}{
+		{name: "one minute window", window: time.Minute, expected: 30},
+		{name: "five minute window", window: 5 * time.Minute, expected: 6},
+		{name: "window longer than the retention", window: time.Hour, expected: 3},
+		{name: "default window is the retention", expected: 3},
+	}
+
+	for _, tc := range testCases {
+		reader, err := NewPushMetricsReader(PushMetricsReaderConfig{Counter: counter, ScaledObject: "default/worker", Window: tc.window})
+		if err != nil {
+			t.Fatalf("Unexpected error %v (%s)", err, tc.name)
+		}
+		actual, err := reader.GetRate429Errors(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error %v (%s)", err, tc.name)
+		}
+		if actual != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, actual, tc.name)
+		}
+	}
+}
+
+func TestPushErrorCounterMaxCounters(t *testing.T) {
+	counter, advance := newFakeClockPushErrorCounter(PushErrorCounterConfig{Retention: time.Minute, MaxCounters: 2})
+
+	if err := counter.Add("default/a", 429, 1); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := counter.Add("default/b", 429, 1); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := counter.Add("default/c", 429, 1); err == nil {
+		t.Errorf("Expected an error pushing a counter over MaxCounters")
+	}
+	// pushes to kept counters still count
+	if err := counter.Add("default/a", 429, 1); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	// idle counters are evicted to make room
+	advance(2 * time.Minute)
+	if err := counter.Add("default/c", 429, 1); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestPushErrorCounterConcurrentPushes(t *testing.T) {
+	counter, err := NewPushErrorCounter(PushErrorCounterConfig{AllowUnauthenticated: true})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	server := httptest.NewServer(counter)
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				resp, err := http.Post(server.URL+PUSH_ERRORS_PATH, "application/json", strings.NewReader(`{"scaledObject":"default/worker","status":429,"count":3}`))
+				if err != nil {
+					t.Errorf("Unexpected error %v", err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if actual := counter.Count("default/worker", 429, time.Minute); actual != 600 {
+		t.Errorf("Expected %d, but got %d", 600, actual)
+	}
+}
+
+func TestPushErrorCounterHTTP(t *testing.T) {
+	counter, err := NewPushErrorCounter(PushErrorCounterConfig{Token: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	server := httptest.NewServer(counter)
+	defer server.Close()
+
+	testCases := []struct {
+		name          string
+		method        string
+		contentType   string
+		authorization string
+		body          string
+		expected      int
+	}{
+		{name: "push", method: http.MethodPost, contentType: "application/json", authorization: "Bearer secret", body: `{"scaledObject":"default/worker","status":429,"count":3}`, expected: http.StatusNoContent},
+		{name: "count defaults to 1", method: http.MethodPost, contentType: "application/json; charset=utf-8", authorization: "Bearer secret", body: `{"scaledObject":"default/worker","status":429}`, expected: http.StatusNoContent},
+		{name: "missing token", method: http.MethodPost, contentType: "application/json", body: `{"scaledObject":"default/worker","status":429}`, expected: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, contentType: "application/json", authorization: "Bearer other", body: `{"scaledObject":"default/worker","status":429}`, expected: http.StatusUnauthorized},
+		{name: "GET", method: http.MethodGet, authorization: "Bearer secret", expected: http.StatusMethodNotAllowed},
+		{name: "form content type", method: http.MethodPost, contentType: "application/x-www-form-urlencoded", authorization: "Bearer secret", body: "status=429", expected: http.StatusUnsupportedMediaType},
+		{name: "invalid JSON", method: http.MethodPost, contentType: "application/json", authorization: "Bearer secret", body: `{"scaledObject":`, expected: http.StatusBadRequest},
+		{name: "scaled object without namespace", method: http.MethodPost, contentType: "application/json", authorization: "Bearer secret", body: `{"scaledObject":"worker","status":429}`, expected: http.StatusBadRequest},
+		{name: "invalid status", method: http.MethodPost, contentType: "application/json", authorization: "Bearer secret", body: `{"scaledObject":"default/worker","status":42}`, expected: http.StatusBadRequest},
+		{name: "zero count", method: http.MethodPost, contentType: "application/json", authorization: "Bearer secret", body: `{"scaledObject":"default/worker","status":429,"count":0}`, expected: http.StatusBadRequest},
+		{name: "body over the limit", method: http.MethodPost, contentType: "application/json", authorization: "Bearer secret", body: `{"scaledObject":"` + strings.Repeat("a", maxPushRequestBytes) + `"}`, expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tc := range testCases {
+		req, err := http.NewRequest(tc.method, server.URL+PUSH_ERRORS_PATH, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("Unexpected error %v (%s)", err, tc.name)
+		}
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error %v (%s)", err, tc.name)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, resp.StatusCode, tc.name)
+		}
+	}
+
+	reader, err := NewPushMetricsReader(PushMetricsReaderConfig{Counter: counter, ScaledObject: "default/worker", Window: time.Minute})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	actual, err := reader.GetRate429Errors(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if actual != 4 {
+		t.Errorf("Expected %d, but got %d", 4, actual)
+	}
+}
+
+func TestPushErrorCounterAuthentication(t *testing.T) {
+	if _, err := NewPushErrorCounter(PushErrorCounterConfig{}); err == nil {
+		t.Errorf("Expected an error creating a counter without a token")
+	}
+
+	testCases := []struct {
+		name          string
+		config        PushErrorCounterConfig
+		authorization string
+		expected      int
+	}{
+		{name: "no token sent", config: PushErrorCounterConfig{Token: "secret"}, expected: http.StatusUnauthorized},
+		{name: "token sent", config: PushErrorCounterConfig{Token: "secret"}, authorization: "Bearer secret", expected: http.StatusNoContent},
+		{name: "token required over the opt out", config: PushErrorCounterConfig{Token: "secret", AllowUnauthenticated: true}, expected: http.StatusUnauthorized},
+		{name: "unauthenticated pushes allowed", config: PushErrorCounterConfig{AllowUnauthenticated: true}, expected: http.StatusNoContent},
+	}
+
+	for _, tc := range testCases {
+		counter, err := NewPushErrorCounter(tc.config)
+		if err != nil {
+			t.Fatalf("Unexpected error %v (%s)", err, tc.name)
+		}
+		req := httptest.NewRequest(http.MethodPost, PUSH_ERRORS_PATH, strings.NewReader(`{"scaledObject":"default/worker","status":429}`))
+		req.Header.Set("Content-Type", "application/json")
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		counter.ServeHTTP(recorder, req)
+
+		if recorder.Code != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, recorder.Code, tc.name)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package metricsReaders
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// https://prometheus.io/docs/concepts/data_model/#metric-names-and-labels
+	prometheusMetricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	// App Insights custom metric names, e.g. those recorded through OpenTelemetry such as
+	// subscriber-app.openai.embeddings.retries
+	appInsightsMetricNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_.\-/:]{0,255}$`)
+	kqlTableNameRegex          = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,255}$`)
+	// https://learn.microsoft.com/azure/azure-resource-manager/management/resource-name-rules#microsoftcognitiveservices
+	azureOpenAIResourceIDRegex     = regexp.MustCompile(`(?i)^/subscriptions/[0-9a-f-]{36}/resourceGroups/[a-zA-Z0-9_.()\-]{1,90}/providers/Microsoft\.CognitiveServices/accounts/[a-zA-Z0-9][a-zA-Z0-9\-]{1,63}$`)
+	azureOpenAIDeploymentNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.\-]{0,63}$`)
+	// unquoted SQL identifiers, optionally schema qualified, e.g. public.outbox
+	sqlIdentifierRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,62}(\.[a-zA-Z_][a-zA-Z0-9_]{0,62})?$`)
+)
+
+// MetadataRejectedError is returned when a scaler metadata value could change the meaning of
+// the query it is used in, or is not allowed by the scaler configuration
+type MetadataRejectedError struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (m *MetadataRejectedError) Error() string {
+	return fmt.Sprintf("metadata %s=%q rejected: %s", m.Key, m.Value, m.Reason)
+}
+
+// Allowlist is a set of names set on the scaler side, an empty Allowlist allows any name
+type Allowlist map[string]bool
+
+// NewAllowlist creates an Allowlist from a comma separated list of names
+func NewAllowlist(commaSeparatedNames string) Allowlist {
+	allowlist := Allowlist{}
+	for _, name := range strings.Split(commaSeparatedNames, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			allowlist[name] = true
+		}
+	}
+	return allowlist
+}
+
+func (a Allowlist) Allows(name string) bool {
+	return len(a) == 0 || a[name]
+}
+
+func (a Allowlist) check(key string, name string) error {
+	if !a.Allows(name) {
+		return &MetadataRejectedError{Key: key, Value: name, Reason: "not in the scaler's allowlist"}
+	}
+	return nil
+}
+
+// ValidatePrometheusMetricName checks that a metadata value is a bare metric name and not a
+// PromQL expression
+func ValidatePrometheusMetricName(key string, name string, allowlist Allowlist) error {
+	if !prometheusMetricNameRegex.MatchString(name) {
+		return &MetadataRejectedError{Key: key, Value: name, Reason: "not a valid Prometheus metric name"}
+	}
+	return allowlist.check(key, name)
+}
+
+// ValidateAppInsightsMetricName checks that a metadata value is a metric name that is safe to
+// use in a KQL string literal
+func ValidateAppInsightsMetricName(key string, name string, allowlist Allowlist) error {
+	if !appInsightsMetricNameRegex.MatchString(name) {
+		return &MetadataRejectedError{Key: key, Value: name, Reason: "not a valid metric name"}
+	}
+	return allowlist.check(key, name)
+}
+
+// ValidateKQLTableName checks that a metadata value is a single Log Analytics table name
+func ValidateKQLTableName(key string, name string, allowlist Allowlist) error {
+	if !kqlTableNameRegex.MatchString(name) {
+		return &MetadataRejectedError{Key: key, Value: name, Reason: "not a valid table name"}
+	}
+	return allowlist.check(key, name)
+}
+
+// QuoteKQLString returns value as a single quoted KQL string literal
+func QuoteKQLString(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// ValidateAzureOpenAIResourceID checks that a metadata value is the resource ID of a Cognitive
+// Services account, as it is used in the Azure Monitor request path
+func ValidateAzureOpenAIResourceID(key string, resourceID string) error {
+	if !azureOpenAIResourceIDRegex.MatchString(resourceID) {
+		return &MetadataRejectedError{Key: key, Value: resourceID, Reason: "not a valid Azure OpenAI resource ID"}
+	}
+	return nil
+}
+
+// ValidateAzureOpenAIDeploymentName checks that a metadata value is a deployment name that is
+// safe to use in an Azure Monitor $filter
+func ValidateAzureOpenAIDeploymentName(key string, name string) error {
+	if !azureOpenAIDeploymentNameRegex.MatchString(name) {
+		return &MetadataRejectedError{Key: key, Value: name, Reason: "not a valid deployment name"}
+	}
+	return nil
+}
+
+// ValidateSQLIdentifier checks that a metadata value is a single unquoted table or column name
+func ValidateSQLIdentifier(key string, name string) error {
+	if !sqlIdentifierRegex.MatchString(name) {
+		return &MetadataRejectedError{Key: key, Value: name, Reason: "not a valid SQL identifier"}
+	}
+	return nil
+}
+
+// ValidateSQLQuery checks that a user defined query is a single SELECT statement. Queries are
+// also run in a read only transaction, this only rejects what is clearly not a count query
+func ValidateSQLQuery(key string, query string) error {
+	statement := strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if strings.Contains(statement, ";") {
+		return &MetadataRejectedError{Key: key, Value: query, Reason: "not a single SQL statement"}
+	}
+	keyword, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(statement)), " ")
+	if keyword != "select" && keyword != "with" {
+		return &MetadataRejectedError{Key: key, Value: query, Reason: "not a SELECT statement"}
+	}
+	return nil
+}
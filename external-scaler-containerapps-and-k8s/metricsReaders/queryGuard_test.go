@@ -0,0 +1,104 @@
+package metricsReaders
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateMetricNames(t *testing.T) {
+	testCases := []struct {
+		name        string
+		validate    func(key string, name string, allowlist Allowlist) error
+		value       string
+		allowlist   Allowlist
+		expectError bool
+	}{
+		{name: "prometheus metric name", validate: ValidatePrometheusMetricName, value: "rate_429_errors"},
+		{name: "promql expression", validate: ValidatePrometheusMetricName, value: `sum(rate_429_errors{job="x"})`, expectError: true},
+		{name: "promql selector", validate: ValidatePrometheusMetricName, value: `rate_429_errors or up`, expectError: true},
+		{name: "otel metric name", validate: ValidateAppInsightsMetricName, value: "subscriber-app.openai.embeddings.retries"},
+		{name: "kql injection", validate: ValidateAppInsightsMetricName, value: "x' or 1==1 | union *", expectError: true},
+		{name: "table name", validate: ValidateKQLTableName, value: "AppMetrics", allowlist: NewAllowlist("AppMetrics, AppRequests")},
+		{name: "table not in allowlist", validate: ValidateKQLTableName, value: "SigninLogs", allowlist: NewAllowlist("AppMetrics"), expectError: true},
+		{name: "table expression", validate: ValidateKQLTableName, value: "union *", expectError: true},
+		{name: "metric not in allowlist", validate: ValidatePrometheusMetricName, value: "node_cpu_seconds_total", allowlist: NewAllowlist("rate_429_errors"), expectError: true},
+	}
+
+	for _, tc := range testCases {
+		err := tc.validate("key", tc.value, tc.allowlist)
+
+		if tc.expectError {
+			var rejected *MetadataRejectedError
+			if !errors.As(err, &rejected) {
+				t.Errorf("Expected a MetadataRejectedError, but got %v (%q)", err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%q)", err, tc.name)
+		}
+	}
+}
+
+func TestValidateAzureOpenAIMetadata(t *testing.T) {
+	testCases := []struct {
+		name        string
+		validate    func(key string, value string) error
+		value       string
+		expectError bool
+	}{
+		{name: "resource id", validate: ValidateAzureOpenAIResourceID, value: "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-aoaiscaler/providers/Microsoft.CognitiveServices/accounts/aoai-scaler"},
+		{name: "other resource type", validate: ValidateAzureOpenAIResourceID, value: "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-aoaiscaler/providers/Microsoft.ServiceBus/namespaces/aoaiscaler", expectError: true},
+		{name: "resource id with query", validate: ValidateAzureOpenAIResourceID, value: "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-aoaiscaler/providers/Microsoft.CognitiveServices/accounts/aoai-scaler?api-version=1", expectError: true},
+		{name: "deployment name", validate: ValidateAzureOpenAIDeploymentName, value: "text-embedding-3-small"},
+		{name: "filter injection", validate: ValidateAzureOpenAIDeploymentName, value: "x' or StatusCode eq '200", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		err := tc.validate("key", tc.value)
+
+		if tc.expectError {
+			var rejected *MetadataRejectedError
+			if !errors.As(err, &rejected) {
+				t.Errorf("Expected a MetadataRejectedError, but got %v (%q)", err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%q)", err, tc.name)
+		}
+	}
+}
+
+func TestValidateSQLMetadata(t *testing.T) {
+	testCases := []struct {
+		name        string
+		validate    func(key string, value string) error
+		value       string
+		expectError bool
+	}{
+		{name: "table", validate: ValidateSQLIdentifier, value: "outbox"},
+		{name: "schema qualified table", validate: ValidateSQLIdentifier, value: "jobs.outbox"},
+		{name: "identifier injection", validate: ValidateSQLIdentifier, value: "outbox; drop table outbox", expectError: true},
+		{name: "quoted identifier", validate: ValidateSQLIdentifier, value: `"Outbox"`, expectError: true},
+		{name: "count query", validate: ValidateSQLQuery, value: "SELECT count(*) FROM outbox WHERE status = $1;"},
+		{name: "common table expression", validate: ValidateSQLQuery, value: "with pending as (select * from outbox where status = $1) select count(*) from pending"},
+		{name: "multiple statements", validate: ValidateSQLQuery, value: "select count(*) from outbox; delete from outbox", expectError: true},
+		{name: "not a select", validate: ValidateSQLQuery, value: "DELETE FROM outbox", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		err := tc.validate("key", tc.value)
+
+		if tc.expectError {
+			var rejected *MetadataRejectedError
+			if !errors.As(err, &rejected) {
+				t.Errorf("Expected a MetadataRejectedError, but got %v (%q)", err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%q)", err, tc.name)
+		}
+	}
+}
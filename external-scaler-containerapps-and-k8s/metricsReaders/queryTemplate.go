@@ -0,0 +1,95 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	QUEUE_LENGTH_QUERY_KEY = "queueLengthQuery"
+	ERROR_RATE_QUERY_KEY   = "errorRateQuery"
+
+	// DEFAULT_CONFIG_MAP_READ_TIMEOUT limits the read of a query ConfigMap, made while the
+	// metric request that configures the scaler waits
+	DEFAULT_CONFIG_MAP_READ_TIMEOUT = 5 * time.Second
+)
+
+// QueryTemplateData holds the variables available to user defined KQL and PromQL queries, e.g.
+// {{.Namespace}}, {{.ScaledObject}} and {{.Window}}
+type QueryTemplateData struct {
+	Namespace    string
+	ScaledObject string
+	Window       string
+}
+
+func NewQueryTemplateData(namespace string, scaledObject string, window time.Duration) (QueryTemplateData, error) {
+	// the values are rendered into query strings, so only accept values that can't
+	// terminate a string literal or add to the query in either KQL or PromQL
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return QueryTemplateData{}, fmt.Errorf("invalid namespace %q for query template: %s", namespace, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(scaledObject); len(errs) > 0 {
+		return QueryTemplateData{}, fmt.Errorf("invalid scaled object name %q for query template: %s", scaledObject, strings.Join(errs, ", "))
+	}
+	if window < time.Minute || window%time.Minute != 0 {
+		return QueryTemplateData{}, fmt.Errorf("query template window must be a whole number of minutes, got %v", window)
+	}
+
+	return QueryTemplateData{
+		Namespace:    namespace,
+		ScaledObject: scaledObject,
+		// whole minutes are valid timespans in both KQL and PromQL
+		Window: fmt.Sprintf("%dm", int(window.Minutes())),
+	}, nil
+}
+
+// RenderQuery renders a user defined query, failing on any variable not in QueryTemplateData
+func RenderQuery(query string, data QueryTemplateData) (string, error) {
+	tmpl, err := template.New("query").Option("missingkey=error").Parse(query)
+	if err != nil {
+		return "", fmt.Errorf("could not parse query template: %w", err)
+	}
+
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("could not render query template: %w", err)
+	}
+
+	return rendered.String(), nil
+}
+
+// GetQueriesFromConfigMap reads the queueLengthQuery and errorRateQuery keys from a ConfigMap
+// of the namespace, keys not present in the ConfigMap are returned empty. The name must be a
+// plain ConfigMap name, so that the read stays within the namespace
+func GetQueriesFromConfigMap(ctx context.Context, namespace string, configMapName string) (queueLengthQuery string, errorRateQuery string, err error) {
+	if errs := validation.IsDNS1123Subdomain(configMapName); len(errs) > 0 {
+		return "", "", &MetadataRejectedError{Key: "queryConfigMap", Value: configMapName, Reason: strings.Join(errs, ", ")}
+	}
+
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get in cluster config: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create clientset: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, DEFAULT_CONFIG_MAP_READ_TIMEOUT)
+	defer cancel()
+
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, configMapName, metav1.GetOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get query config map %s/%s: %w", namespace, configMapName, err)
+	}
+
+	return configMap.Data[QUEUE_LENGTH_QUERY_KEY], configMap.Data[ERROR_RATE_QUERY_KEY], nil
+}
@@ -0,0 +1,98 @@
+package metricsReaders
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRenderQuery(t *testing.T) {
+	data, err := NewQueryTemplateData("orders", "orders-worker", 3*time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		query       string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "promql",
+			query:    `sum(increase(http_requests_total{namespace="{{.Namespace}}",scaledobject="{{.ScaledObject}}",code="429"}[{{.Window}}]))`,
+			expected: `sum(increase(http_requests_total{namespace="orders",scaledobject="orders-worker",code="429"}[3m]))`,
+		},
+		{
+			name:     "kql",
+			query:    "AppMetrics | where AppRoleName == '{{.ScaledObject}}' | where TimeGenerated > ago({{.Window}}) | summarize sum(ItemCount)",
+			expected: "AppMetrics | where AppRoleName == 'orders-worker' | where TimeGenerated > ago(3m) | summarize sum(ItemCount)",
+		},
+		{
+			name:        "unknown variable",
+			query:       "AppMetrics | where Name == '{{.MetricName}}'",
+			expectError: true,
+		},
+		{
+			name:        "invalid template",
+			query:       "AppMetrics | where Name == '{{.Namespace'",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		result, err := RenderQuery(tc.query, data)
+
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("Expected an error, but got %q (%q)", result, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%q)", err, tc.name)
+		}
+		if result != tc.expected {
+			t.Errorf("Expected %q, but got %q (%q)", tc.expected, result, tc.name)
+		}
+	}
+}
+
+func TestNewQueryTemplateDataRejectsUnsafeValues(t *testing.T) {
+	testCases := []struct {
+		name         string
+		namespace    string
+		scaledObject string
+		window       time.Duration
+	}{
+		{name: "quote in namespace", namespace: "orders' or 1==1", scaledObject: "orders-worker", window: time.Minute},
+		{name: "brace in scaled object", namespace: "orders", scaledObject: `orders"}`, window: time.Minute},
+		{name: "fractional window", namespace: "orders", scaledObject: "orders-worker", window: 90 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		if _, err := NewQueryTemplateData(tc.namespace, tc.scaledObject, tc.window); err == nil {
+			t.Errorf("Expected an error (%q)", tc.name)
+		}
+	}
+}
+
+func TestGetQueriesFromConfigMapRejectsNamesOutsideTheNamespace(t *testing.T) {
+	testCases := []struct {
+		name          string
+		configMapName string
+	}{
+		{name: "other namespace", configMapName: "kube-system/queries"},
+		{name: "path traversal", configMapName: "../../namespaces/kube-system/configmaps/queries"},
+		{name: "upper case", configMapName: "Queries"},
+	}
+
+	for _, tc := range testCases {
+		_, _, err := GetQueriesFromConfigMap(context.Background(), "orders", tc.configMapName)
+		var metadataRejectedError *MetadataRejectedError
+		if !errors.As(err, &metadataRejectedError) {
+			t.Errorf("Expected a rejected metadata error, but got %v (%s)", err, tc.name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const DEFAULT_RABBITMQ_VHOST = "/"
+
+type RabbitMQMetricsReaderConfig struct {
+	// ManagementEndpoint is the management plugin's HTTP endpoint, e.g. http://rabbitmq:15672.
+	// Credentials in the endpoint's user info are used when Username is not set
+	ManagementEndpoint string
+	VHost              string
+	QueueName          string
+	Username           string
+	Password           string
+	// IncludeUnacknowledged adds the messages delivered to consumers but not yet acknowledged
+	// to the ready messages
+	IncludeUnacknowledged bool
+	// HTTPClient makes the management API requests, defaults to a new http.Client
+	HTTPClient *http.Client
+}
+
+type RabbitMQMetricsReader struct {
+	managementEndpoint    string
+	vhost                 string
+	queueName             string
+	username              string
+	password              string
+	includeUnacknowledged bool
+	httpClient            *http.Client
+}
+
+type rabbitMQQueueResponse struct {
+	MessagesReady          *int64 `json:"messages_ready"`
+	MessagesUnacknowledged *int64 `json:"messages_unacknowledged"`
+}
+
+func NewRabbitMQMetricsReader(config RabbitMQMetricsReaderConfig) (*RabbitMQMetricsReader, error) {
+	// the endpoint can hold credentials, so it is not included in errors
+	endpoint, err := url.Parse(config.ManagementEndpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid RabbitMQ management endpoint, expected a URL such as http://rabbitmq:15672")
+	}
+
+	username, password := config.Username, config.Password
+	if username == "" && endpoint.User != nil {
+		username = endpoint.User.Username()
+		password, _ = endpoint.User.Password()
+	}
+	endpoint.User = nil
+
+	vhost := config.VHost
+	if vhost == "" {
+		vhost = DEFAULT_RABBITMQ_VHOST
+	}
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	return &RabbitMQMetricsReader{
+		managementEndpoint:    strings.TrimSuffix(endpoint.String(), "/"),
+		vhost:                 vhost,
+		queueName:             config.QueueName,
+		username:              username,
+		password:              password,
+		includeUnacknowledged: config.IncludeUnacknowledged,
+		httpClient:            httpClient,
+	}, nil
+}
+
+// GetQueueRequestUri returns the management API URI of the queue, the vhost and queue name are
+// escaped as path segments, so the default vhost / becomes %2F
+func (r *RabbitMQMetricsReader) GetQueueRequestUri() string {
+	return fmt.Sprintf("%s/api/queues/%s/%s", r.managementEndpoint, url.PathEscape(r.vhost), url.PathEscape(r.queueName))
+}
+
+func (r *RabbitMQMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	requestUri := r.GetQueueRequestUri()
+	slog.Debug(fmt.Sprintf("Request URI: %s\n", requestUri))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUri, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create get request: %w", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "Go HTTP Client")
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var queue rabbitMQQueueResponse
+	if err := decodeResponse(resp, &queue); err != nil {
+		return 0, fmt.Errorf("could not get rabbitmq queue %s in vhost %s: %w", r.queueName, r.vhost, err)
+	}
+
+	// message counts are only reported once the queue's stats have been collected
+	if queue.MessagesReady == nil {
+		return 0, fmt.Errorf("rabbitmq queue %s in vhost %s has no messages_ready yet", r.queueName, r.vhost)
+	}
+
+	queueLength := *queue.MessagesReady
+	if r.includeUnacknowledged && queue.MessagesUnacknowledged != nil {
+		queueLength += *queue.MessagesUnacknowledged
+	}
+
+	return int(queueLength), nil
+}
@@ -0,0 +1,122 @@
+package metricsReaders
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFakeRabbitMQServer stands in for the management API, serving testdata fixtures by escaped
+// path and checking requests use basic auth
+func newFakeRabbitMQServer(t *testing.T, fixtures map[string]string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "scaler" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"not_authorized","reason":"Login failed"}`))
+			return
+		}
+		fixture, ok := fixtures[r.URL.EscapedPath()]
+		if !ok {
+			fixture = "rabbitmq_not_found.json"
+			w.WriteHeader(http.StatusNotFound)
+		}
+		body, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Errorf("Failed to read fixture %s: %v", fixture, err)
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestRabbitMQGetQueueLength(t *testing.T) {
+	server := newFakeRabbitMQServer(t, map[string]string{
+		"/api/queues/%2F/embeddings":           "rabbitmq_queue.json",
+		"/api/queues/team%2Fa/embeddings%20v2": "rabbitmq_queue.json",
+	})
+	endpointWithCredentials := strings.Replace(server.URL, "http://", "http://scaler:secret@", 1)
+
+	testCases := []struct {
+		name               string
+		config             RabbitMQMetricsReaderConfig
+		expected           int
+		expectedStatusCode int
+	}{
+		{
+			name:     "ready messages in the default vhost",
+			config:   RabbitMQMetricsReaderConfig{ManagementEndpoint: server.URL, QueueName: "embeddings", Username: "scaler", Password: "secret"},
+			expected: 24,
+		},
+		{
+			name:     "ready and unacknowledged messages",
+			config:   RabbitMQMetricsReaderConfig{ManagementEndpoint: server.URL, QueueName: "embeddings", Username: "scaler", Password: "secret", IncludeUnacknowledged: true},
+			expected: 40,
+		},
+		{
+			name:     "escaped vhost and queue with credentials in the endpoint",
+			config:   RabbitMQMetricsReaderConfig{ManagementEndpoint: endpointWithCredentials + "/", VHost: "team/a", QueueName: "embeddings v2"},
+			expected: 24,
+		},
+		{
+			name:               "wrong credentials",
+			config:             RabbitMQMetricsReaderConfig{ManagementEndpoint: server.URL, QueueName: "embeddings", Username: "scaler", Password: "wrong"},
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:               "queue not found",
+			config:             RabbitMQMetricsReaderConfig{ManagementEndpoint: server.URL, QueueName: "missing", Username: "scaler", Password: "secret"},
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		r, err := NewRabbitMQMetricsReader(tc.config)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.name)
+		}
+
+		queueLength, err := r.GetQueueLength(context.Background())
+		if tc.expectedStatusCode != 0 {
+			var responseError *ResponseError
+			if !errors.As(err, &responseError) || responseError.StatusCode != tc.expectedStatusCode {
+				t.Errorf("Expected a ResponseError with status %d, but got %v (%s)", tc.expectedStatusCode, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if queueLength != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, queueLength, tc.name)
+		}
+	}
+}
+
+func TestSplitMetricsReaderWithoutRate429ErrorsReader(t *testing.T) {
+	server := newFakeRabbitMQServer(t, map[string]string{"/api/queues/%2F/embeddings": "rabbitmq_queue.json"})
+	r, err := NewRabbitMQMetricsReader(RabbitMQMetricsReaderConfig{ManagementEndpoint: server.URL, QueueName: "embeddings", Username: "scaler", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	s := NewSplitMetricsReader(r, nil)
+
+	queueLength, err := s.GetQueueLength(context.Background())
+	if err != nil || queueLength != 24 {
+		t.Errorf("Expected 24, but got %d (%v)", queueLength, err)
+	}
+	rate429Errors, err := s.GetRate429Errors(context.Background())
+	if err != nil || rate429Errors != 0 {
+		t.Errorf("Expected 0, but got %d (%v)", rate429Errors, err)
+	}
+}
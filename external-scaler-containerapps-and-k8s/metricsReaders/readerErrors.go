@@ -0,0 +1,204 @@
+package metricsReaders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/nats-io/nats.go/jetstream"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// ERROR_CLASS_TRANSIENT errors may succeed when retried, the other classes but
+	// ERROR_CLASS_UNKNOWN are permanent until the configuration, credentials or permissions
+	// are fixed
+	ERROR_CLASS_TRANSIENT         = "transient"
+	ERROR_CLASS_NOT_FOUND         = "notFound"
+	ERROR_CLASS_INVALID           = "invalid"
+	ERROR_CLASS_UNAUTHENTICATED   = "unauthenticated"
+	ERROR_CLASS_PERMISSION_DENIED = "permissionDenied"
+	ERROR_CLASS_UNKNOWN           = "unknown"
+
+	DEFAULT_RETRY_MAX_ATTEMPTS = 3
+	DEFAULT_RETRY_BASE_DELAY   = 200 * time.Millisecond
+	DEFAULT_RETRY_MAX_DELAY    = 5 * time.Second
+)
+
+// errorClassRanks orders the classes of joined errors, the most permanent class wins
+var errorClassRanks = map[string]int{
+	ERROR_CLASS_TRANSIENT:         0,
+	ERROR_CLASS_UNKNOWN:           1,
+	ERROR_CLASS_INVALID:           2,
+	ERROR_CLASS_UNAUTHENTICATED:   3,
+	ERROR_CLASS_PERMISSION_DENIED: 4,
+	ERROR_CLASS_NOT_FOUND:         5,
+}
+
+// kafkaTransientErrorCodes are the broker errors of leadership and coordinator moves
+var kafkaTransientErrorCodes = map[int16]bool{5: true, 6: true, 7: true, 14: true, 15: true, 16: true}
+
+// kafkaAuthorizationErrorCodes are the TOPIC_, GROUP_ and CLUSTER_AUTHORIZATION_FAILED errors
+var kafkaAuthorizationErrorCodes = map[int16]bool{29: true, 30: true, 31: true}
+
+// ClassifyError returns the class of a reader error, and how long the backend asked to wait
+// before retrying when it did. Errors joined with errors.Join take the most permanent class
+// of their errors
+func ClassifyError(err error) (string, time.Duration) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		class, retryAfter := ERROR_CLASS_TRANSIENT, time.Duration(0)
+		for _, err := range joined.Unwrap() {
+			errClass, errRetryAfter := ClassifyError(err)
+			if errorClassRanks[errClass] > errorClassRanks[class] {
+				class = errClass
+			}
+			retryAfter = max(retryAfter, errRetryAfter)
+		}
+		return class, retryAfter
+	}
+
+	var responseError *ResponseError
+	var azureResponseError *azcore.ResponseError
+	var kubernetesStatus apierrors.APIStatus
+	var prometheusError *v1.Error
+	var kafkaError *KafkaError
+	var sqsQueueDoesNotExist *types.QueueDoesNotExist
+	var statusCodeError interface{ HTTPStatusCode() int }
+	var metadataRejectedError *MetadataRejectedError
+	var netError net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the caller gave up, there is nothing to retry for
+		return ERROR_CLASS_UNKNOWN, 0
+	case errors.As(err, &metadataRejectedError):
+		return ERROR_CLASS_INVALID, 0
+	case errors.As(err, &responseError):
+		return classifyStatusCode(responseError.StatusCode), responseError.RetryAfter
+	case errors.As(err, &azureResponseError):
+		var retryAfter time.Duration
+		if azureResponseError.RawResponse != nil {
+			retryAfter = parseRetryAfter(azureResponseError.RawResponse.Header)
+		}
+		return classifyStatusCode(azureResponseError.StatusCode), retryAfter
+	case errors.As(err, &kubernetesStatus):
+		var retryAfter time.Duration
+		if seconds, ok := apierrors.SuggestsClientDelay(err); ok {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return classifyStatusCode(int(kubernetesStatus.Status().Code)), retryAfter
+	case errors.As(err, &prometheusError):
+		switch prometheusError.Type {
+		case v1.ErrTimeout, v1.ErrServer:
+			return ERROR_CLASS_TRANSIENT, 0
+		case v1.ErrBadData, v1.ErrClient:
+			return ERROR_CLASS_INVALID, 0
+		}
+		return ERROR_CLASS_UNKNOWN, 0
+	case errors.As(err, &kafkaError):
+		switch {
+		case kafkaTransientErrorCodes[kafkaError.Code]:
+			return ERROR_CLASS_TRANSIENT, 0
+		case kafkaError.Code == 3: // UNKNOWN_TOPIC_OR_PARTITION
+			return ERROR_CLASS_NOT_FOUND, 0
+		case kafkaError.Code == 58: // SASL_AUTHENTICATION_FAILED
+			return ERROR_CLASS_UNAUTHENTICATED, 0
+		case kafkaAuthorizationErrorCodes[kafkaError.Code]:
+			return ERROR_CLASS_PERMISSION_DENIED, 0
+		}
+		return ERROR_CLASS_INVALID, 0
+	case errors.Is(err, jetstream.ErrStreamNotFound), errors.Is(err, jetstream.ErrConsumerNotFound):
+		return ERROR_CLASS_NOT_FOUND, 0
+	case errors.As(err, &sqsQueueDoesNotExist):
+		// SQS answers a missing queue with 400
+		return ERROR_CLASS_NOT_FOUND, 0
+	case errors.As(err, &statusCodeError):
+		// AWS SDK responses
+		return classifyStatusCode(statusCodeError.HTTPStatusCode()), 0
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		// timeouts, DNS failures, refused and dropped connections
+		return ERROR_CLASS_TRANSIENT, 0
+	}
+	return ERROR_CLASS_UNKNOWN, 0
+}
+
+func classifyStatusCode(statusCode int) string {
+	switch {
+	case statusCode == http.StatusNotFound || statusCode == http.StatusGone:
+		return ERROR_CLASS_NOT_FOUND
+	case statusCode == http.StatusUnauthorized:
+		return ERROR_CLASS_UNAUTHENTICATED
+	case statusCode == http.StatusForbidden:
+		return ERROR_CLASS_PERMISSION_DENIED
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooEarly || statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		return ERROR_CLASS_TRANSIENT
+	case statusCode >= 400:
+		return ERROR_CLASS_INVALID
+	}
+	return ERROR_CLASS_UNKNOWN
+}
+
+// RetryPolicy retries the reads of readers failing with transient errors, with jittered
+// exponential backoff
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt, less than 2 disables retries
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Do runs read until it succeeds, fails with an error that is not transient, or MaxAttempts
+// are made. Retries wait as long as the backend asked with Retry-After, or else a random
+// delay of up to BaseDelay doubled for each attempt, capped at MaxDelay. A retry whose wait
+// would outlast ctx's deadline is not made
+func (r RetryPolicy) Do(ctx context.Context, read func(ctx context.Context) (int, error)) (int, error) {
+	for attempt := 1; ; attempt++ {
+		value, err := read(ctx)
+		if err == nil {
+			return value, nil
+		}
+
+		class, retryAfter := ClassifyError(err)
+		if class != ERROR_CLASS_TRANSIENT || attempt >= r.MaxAttempts || ctx.Err() != nil {
+			return 0, err
+		}
+
+		delay := retryAfter
+		if delay == 0 {
+			delay = r.backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return 0, fmt.Errorf("not retried as waiting %v would outlast the deadline: %w", delay.Round(time.Millisecond), err)
+		}
+		slog.Debug(fmt.Sprintf("retrying in %v after attempt %d failed: %v", delay.Round(time.Millisecond), attempt, err))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return 0, err
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a full jitter delay for the retry after attempt
+func (r RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := r.BaseDelay
+	for i := 1; i < attempt && ceiling < r.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	ceiling = min(ceiling, r.MaxDelay)
+	if ceiling <= 0 {
+		return 0
+	}
+	return rand.N(ceiling + 1)
+}
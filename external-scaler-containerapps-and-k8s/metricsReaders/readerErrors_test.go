@@ -0,0 +1,145 @@
+package metricsReaders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestClassifyError(t *testing.T) {
+	deployments := schema.GroupResource{Group: "apps", Resource: "deployments"}
+
+	testCases := []struct {
+		name               string
+		err                error
+		expectedClass      string
+		expectedRetryAfter time.Duration
+	}{
+		{name: "ARM throttling", err: fmt.Errorf("could not get service bus entity: %w", &ResponseError{StatusCode: 429, RetryAfter: 3 * time.Second}), expectedClass: ERROR_CLASS_TRANSIENT, expectedRetryAfter: 3 * time.Second},
+		{name: "service unavailable", err: &ResponseError{StatusCode: 503}, expectedClass: ERROR_CLASS_TRANSIENT},
+		{name: "mistyped queue name", err: &ResponseError{StatusCode: 404, Code: "EntityNotFound"}, expectedClass: ERROR_CLASS_NOT_FOUND},
+		{name: "missing role assignment", err: &ResponseError{StatusCode: 403, Code: "AuthorizationFailed"}, expectedClass: ERROR_CLASS_PERMISSION_DENIED},
+		{name: "expired token", err: &ResponseError{StatusCode: 401, Code: "ExpiredAuthenticationToken"}, expectedClass: ERROR_CLASS_UNAUTHENTICATED},
+		{name: "bad request", err: &ResponseError{StatusCode: 400, Code: "BadRequest"}, expectedClass: ERROR_CLASS_INVALID},
+		{name: "DNS failure", err: fmt.Errorf("could not make request: %w", &net.DNSError{Err: "no such host", Name: "example.servicebus.windows.net"}), expectedClass: ERROR_CLASS_TRANSIENT},
+		{name: "read timeout", err: context.DeadlineExceeded, expectedClass: ERROR_CLASS_TRANSIENT},
+		{name: "canceled", err: context.Canceled, expectedClass: ERROR_CLASS_UNKNOWN},
+		{name: "kubernetes deployment not found", err: apierrors.NewNotFound(deployments, "worker"), expectedClass: ERROR_CLASS_NOT_FOUND},
+		{name: "kubernetes throttling", err: apierrors.NewTooManyRequests("slow down", 2), expectedClass: ERROR_CLASS_TRANSIENT, expectedRetryAfter: 2 * time.Second},
+		{name: "kafka leader moving", err: &KafkaError{Code: 6}, expectedClass: ERROR_CLASS_TRANSIENT},
+		{name: "kafka unknown topic", err: &KafkaError{Code: 3}, expectedClass: ERROR_CLASS_NOT_FOUND},
+		{name: "kafka authentication", err: &KafkaError{Code: 58}, expectedClass: ERROR_CLASS_UNAUTHENTICATED},
+		{name: "kafka group authorization", err: &KafkaError{Code: 30}, expectedClass: ERROR_CLASS_PERMISSION_DENIED},
+		{name: "kafka invalid request", err: &KafkaError{Code: 42}, expectedClass: ERROR_CLASS_INVALID},
+		{name: "jetstream consumer not found", err: fmt.Errorf("could not get jetstream consumer: %w", jetstream.ErrConsumerNotFound), expectedClass: ERROR_CLASS_NOT_FOUND},
+		{name: "rejected metadata", err: &MetadataRejectedError{Key: "rate429ErrorsMetricName", Value: "up", Reason: "not in the scaler's allowlist"}, expectedClass: ERROR_CLASS_INVALID},
+		{name: "unparsable response", err: errors.New("could not decode response body"), expectedClass: ERROR_CLASS_UNKNOWN},
+		{name: "joined errors take the most permanent class", err: errors.Join(&ResponseError{StatusCode: 429, RetryAfter: time.Second}, &ResponseError{StatusCode: 404}), expectedClass: ERROR_CLASS_NOT_FOUND, expectedRetryAfter: time.Second},
+		{name: "joined transient errors", err: errors.Join(context.DeadlineExceeded, &ResponseError{StatusCode: 502}), expectedClass: ERROR_CLASS_TRANSIENT},
+	}
+
+	for _, tc := range testCases {
+		class, retryAfter := ClassifyError(tc.err)
+		if class != tc.expectedClass || retryAfter != tc.expectedRetryAfter {
+			t.Errorf("Expected %s after %v, but got %s after %v (%s)", tc.expectedClass, tc.expectedRetryAfter, class, retryAfter, tc.name)
+		}
+	}
+}
+
+// failingRead fails with errs in turn, and then returns 42
+func failingRead(errs ...error) (func(ctx context.Context) (int, error), *int) {
+	attempts := 0
+	return func(ctx context.Context) (int, error) {
+		attempts++
+		if attempts <= len(errs) {
+			return 0, errs[attempts-1]
+		}
+		return 42, nil
+	}, &attempts
+}
+
+func TestRetryPolicy(t *testing.T) {
+	retryPolicy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	throttled := &ResponseError{StatusCode: 429}
+	notFound := &ResponseError{StatusCode: 404}
+
+	testCases := []struct {
+		name             string
+		errs             []error
+		expectedValue    int
+		expectedErr      error
+		expectedAttempts int
+	}{
+		{name: "success", expectedValue: 42, expectedAttempts: 1},
+		{name: "transient errors are retried", errs: []error{throttled, context.DeadlineExceeded}, expectedValue: 42, expectedAttempts: 3},
+		{name: "retries stop at MaxAttempts", errs: []error{throttled, throttled, throttled}, expectedErr: throttled, expectedAttempts: 3},
+		{name: "permanent errors are not retried", errs: []error{notFound}, expectedErr: notFound, expectedAttempts: 1},
+	}
+
+	for _, tc := range testCases {
+		read, attempts := failingRead(tc.errs...)
+		value, err := retryPolicy.Do(context.Background(), read)
+		if value != tc.expectedValue || !errors.Is(err, tc.expectedErr) || *attempts != tc.expectedAttempts {
+			t.Errorf("Expected %d (%v) after %d attempts, but got %d (%v) after %d attempts (%s)", tc.expectedValue, tc.expectedErr, tc.expectedAttempts, value, err, *attempts, tc.name)
+		}
+	}
+}
+
+func TestRetryPolicyHonorsRetryAfterWithinDeadline(t *testing.T) {
+	retryPolicy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+
+	// the backend's Retry-After is waited for rather than the backoff
+	read, attempts := failingRead(&ResponseError{StatusCode: 429, RetryAfter: 100 * time.Millisecond})
+	start := time.Now()
+	value, err := retryPolicy.Do(context.Background(), read)
+	if value != 42 || err != nil || *attempts != 2 {
+		t.Errorf("Expected 42 after 2 attempts, but got %d (%v) after %d attempts", value, err, *attempts)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected to wait the 100ms Retry-After, but took %v", elapsed)
+	}
+
+	// a Retry-After beyond the deadline is not waited for
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	throttled := &ResponseError{StatusCode: 429, RetryAfter: time.Minute}
+	read, attempts = failingRead(throttled)
+	start = time.Now()
+	_, err = retryPolicy.Do(ctx, read)
+	if !errors.Is(err, throttled) || *attempts != 1 {
+		t.Errorf("Expected the throttled error after 1 attempt, but got %v after %d attempts", err, *attempts)
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected to give up right away, but took %v", elapsed)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	retryPolicy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	testCases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{attempt: 1, expected: 100 * time.Millisecond},
+		{attempt: 3, expected: 400 * time.Millisecond},
+		{attempt: 5, expected: time.Second},
+		{attempt: 70, expected: time.Second},
+	}
+
+	for _, tc := range testCases {
+		for i := 0; i < 100; i++ {
+			if delay := retryPolicy.backoff(tc.attempt); delay < 0 || delay > tc.expected {
+				t.Errorf("Expected a delay of at most %v, but got %v (attempt %d)", tc.expected, delay, tc.attempt)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// REDIS_KEY_TYPE_LIST is a list used as a queue, its length is the queue length
+	REDIS_KEY_TYPE_LIST = "list"
+	// REDIS_KEY_TYPE_STREAM is a stream read by a consumer group, the group's pending
+	// entries plus its lag are the queue length
+	REDIS_KEY_TYPE_STREAM = "stream"
+)
+
+type RedisMetricsReaderConfig struct {
+	// Address is the host:port of the Redis server
+	Address  string
+	Username string
+	Password string
+	DB       int
+	// TLSConfig enables TLS when set
+	TLSConfig *tls.Config
+
+	Key string
+	// KeyType is REDIS_KEY_TYPE_LIST (the default) or REDIS_KEY_TYPE_STREAM
+	KeyType string
+	// ConsumerGroup is required for streams
+	ConsumerGroup string
+}
+
+type RedisMetricsReader struct {
+	client        *redis.Client
+	key           string
+	keyType       string
+	consumerGroup string
+}
+
+func NewRedisMetricsReader(config RedisMetricsReaderConfig) (*RedisMetricsReader, error) {
+	if config.Address == "" || config.Key == "" {
+		return nil, fmt.Errorf("redis address and key are required")
+	}
+
+	keyType := config.KeyType
+	if keyType == "" {
+		keyType = REDIS_KEY_TYPE_LIST
+	}
+	switch keyType {
+	case REDIS_KEY_TYPE_LIST:
+	case REDIS_KEY_TYPE_STREAM:
+		if config.ConsumerGroup == "" {
+			return nil, fmt.Errorf("redis consumer group is required for streams")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported redis key type %q, supported types are %s and %s", keyType, REDIS_KEY_TYPE_LIST, REDIS_KEY_TYPE_STREAM)
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:      config.Address,
+		Username:  config.Username,
+		Password:  config.Password,
+		DB:        config.DB,
+		TLSConfig: config.TLSConfig,
+		// CLIENT SETINFO is not supported by older servers and some proxies
+		DisableIdentity: true,
+	})
+
+	return &RedisMetricsReader{
+		client:        client,
+		key:           config.Key,
+		keyType:       keyType,
+		consumerGroup: config.ConsumerGroup,
+	}, nil
+}
+
+func (r *RedisMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	if r.keyType == REDIS_KEY_TYPE_STREAM {
+		return r.getStreamBacklog(ctx)
+	}
+
+	length, err := r.client.LLen(ctx, r.key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not get length of redis list %s: %w", r.key, err)
+	}
+
+	return int(length), nil
+}
+
+// getStreamBacklog returns the entries delivered to the group but not acknowledged, plus the
+// entries not delivered to it yet
+func (r *RedisMetricsReader) getStreamBacklog(ctx context.Context) (int, error) {
+	groups, err := r.client.XInfoGroups(ctx, r.key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("could not get consumer groups of redis stream %s: %w", r.key, err)
+	}
+
+	for _, group := range groups {
+		if group.Name != r.consumerGroup {
+			continue
+		}
+
+		lag := group.Lag
+		if lag < 0 {
+			// Redis cannot tell the lag after entries were deleted from the middle of the
+			// stream, the stream length is used as an upper bound until the group catches up
+			length, err := r.client.XLen(ctx, r.key).Result()
+			if err != nil {
+				return 0, fmt.Errorf("could not get length of redis stream %s: %w", r.key, err)
+			}
+			slog.Warn(fmt.Sprintf("lag of redis consumer group %s on stream %s is unknown, using the stream length %d", r.consumerGroup, r.key, length))
+			lag = length
+		}
+
+		slog.Debug(fmt.Sprintf("redis stream %s consumer group %s pending: %d, lag: %d", r.key, r.consumerGroup, group.Pending, lag))
+		return int(group.Pending + lag), nil
+	}
+
+	return 0, fmt.Errorf("redis stream %s has no consumer group %s", r.key, r.consumerGroup)
+}
@@ -0,0 +1,260 @@
+package metricsReaders
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type fakeRedisGroup struct {
+	name    string
+	pending int64
+	// lag is nil when redis cannot tell it
+	lag *int64
+}
+
+type fakeRedisStream struct {
+	length int64
+	groups []fakeRedisGroup
+}
+
+// fakeRedisServer answers the RESP2 commands RedisMetricsReader sends, like a Redis server
+// without HELLO
+type fakeRedisServer struct {
+	listener net.Listener
+	password string
+	lists    map[string]int64
+	streams  map[string]fakeRedisStream
+}
+
+func newFakeRedisServer(t *testing.T, password string, lists map[string]int64, streams map[string]fakeRedisStream) *fakeRedisServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &fakeRedisServer{listener: listener, password: password, lists: lists, streams: streams}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func respBulkString(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func (s *fakeRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	authenticated := s.password == ""
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(args[0])
+		var reply string
+		switch {
+		case command == "HELLO":
+			reply = "-ERR unknown command 'HELLO'\r\n"
+		case command == "AUTH":
+			if args[len(args)-1] == s.password {
+				authenticated = true
+				reply = "+OK\r\n"
+			} else {
+				reply = "-WRONGPASS invalid username-password pair or user is disabled.\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case command == "SELECT":
+			reply = "+OK\r\n"
+		case command == "LLEN":
+			if _, ok := s.streams[args[1]]; ok {
+				reply = "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+			} else {
+				reply = fmt.Sprintf(":%d\r\n", s.lists[args[1]])
+			}
+		case command == "XLEN":
+			reply = fmt.Sprintf(":%d\r\n", s.streams[args[1]].length)
+		case command == "XINFO" && strings.ToUpper(args[1]) == "GROUPS":
+			stream, ok := s.streams[args[2]]
+			if !ok {
+				reply = "-ERR no such key\r\n"
+				break
+			}
+			reply = fmt.Sprintf("*%d\r\n", len(stream.groups))
+			for _, group := range stream.groups {
+				lag := "$-1\r\n"
+				if group.lag != nil {
+					lag = fmt.Sprintf(":%d\r\n", *group.lag)
+				}
+				reply += "*12\r\n" +
+					respBulkString("name") + respBulkString(group.name) +
+					respBulkString("consumers") + ":2\r\n" +
+					respBulkString("pending") + fmt.Sprintf(":%d\r\n", group.pending) +
+					respBulkString("last-delivered-id") + respBulkString("1700000000000-0") +
+					respBulkString("entries-read") + ":100\r\n" +
+					respBulkString("lag") + lag
+			}
+		default:
+			reply = fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+		}
+
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func TestRedisGetQueueLength(t *testing.T) {
+	lag := int64(30)
+	streams := map[string]fakeRedisStream{
+		"embeddings-stream": {length: 500, groups: []fakeRedisGroup{
+			{name: "other-app", pending: 1, lag: &lag},
+			{name: "subscriber-app", pending: 12, lag: &lag},
+		}},
+		"trimmed-stream": {length: 80, groups: []fakeRedisGroup{{name: "subscriber-app", pending: 5}}},
+	}
+	server := newFakeRedisServer(t, "", map[string]int64{"embeddings": 42}, streams)
+	passwordServer := newFakeRedisServer(t, "secret", map[string]int64{"embeddings": 42}, streams)
+
+	testCases := []struct {
+		name          string
+		config        RedisMetricsReaderConfig
+		expected      int
+		expectedError string
+	}{
+		{
+			name:     "list",
+			config:   RedisMetricsReaderConfig{Address: server.listener.Addr().String(), Key: "embeddings"},
+			expected: 42,
+		},
+		{
+			name:     "missing list is empty",
+			config:   RedisMetricsReaderConfig{Address: server.listener.Addr().String(), Key: "missing"},
+			expected: 0,
+		},
+		{
+			name:     "stream pending plus lag",
+			config:   RedisMetricsReaderConfig{Address: server.listener.Addr().String(), Key: "embeddings-stream", KeyType: REDIS_KEY_TYPE_STREAM, ConsumerGroup: "subscriber-app"},
+			expected: 42,
+		},
+		{
+			name:     "stream with unknown lag uses the stream length",
+			config:   RedisMetricsReaderConfig{Address: server.listener.Addr().String(), Key: "trimmed-stream", KeyType: REDIS_KEY_TYPE_STREAM, ConsumerGroup: "subscriber-app"},
+			expected: 85,
+		},
+		{
+			name:          "missing consumer group",
+			config:        RedisMetricsReaderConfig{Address: server.listener.Addr().String(), Key: "embeddings-stream", KeyType: REDIS_KEY_TYPE_STREAM, ConsumerGroup: "missing"},
+			expectedError: "has no consumer group missing",
+		},
+		{
+			name:          "missing stream",
+			config:        RedisMetricsReaderConfig{Address: server.listener.Addr().String(), Key: "missing", KeyType: REDIS_KEY_TYPE_STREAM, ConsumerGroup: "subscriber-app"},
+			expectedError: "no such key",
+		},
+		{
+			name:          "stream read as a list",
+			config:        RedisMetricsReaderConfig{Address: server.listener.Addr().String(), Key: "embeddings-stream"},
+			expectedError: "WRONGTYPE",
+		},
+		{
+			name:     "password",
+			config:   RedisMetricsReaderConfig{Address: passwordServer.listener.Addr().String(), Password: "secret", DB: 2, Key: "embeddings"},
+			expected: 42,
+		},
+		{
+			name:          "wrong password",
+			config:        RedisMetricsReaderConfig{Address: passwordServer.listener.Addr().String(), Password: "wrong", Key: "embeddings"},
+			expectedError: "WRONGPASS",
+		},
+	}
+
+	for _, tc := range testCases {
+		r, err := NewRedisMetricsReader(tc.config)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.name)
+		}
+
+		queueLength, err := r.GetQueueLength(context.Background())
+		if tc.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected error containing %q, but got %v (%s)", tc.expectedError, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if queueLength != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, queueLength, tc.name)
+		}
+	}
+}
+
+func TestNewRedisMetricsReaderValidation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config RedisMetricsReaderConfig
+	}{
+		{name: "no address", config: RedisMetricsReaderConfig{Key: "embeddings"}},
+		{name: "no key", config: RedisMetricsReaderConfig{Address: "redis:6379"}},
+		{name: "stream without consumer group", config: RedisMetricsReaderConfig{Address: "redis:6379", Key: "embeddings", KeyType: REDIS_KEY_TYPE_STREAM}},
+		{name: "unsupported key type", config: RedisMetricsReaderConfig{Address: "redis:6379", Key: "embeddings", KeyType: "zset"}},
+	}
+
+	for _, tc := range testCases {
+		if _, err := NewRedisMetricsReader(tc.config); err == nil {
+			t.Errorf("Expected an error (%s)", tc.name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package metricsReaders
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SERVICE_BUS_BACKLOG_COUNT_ACTIVE    = "active"
+	SERVICE_BUS_BACKLOG_COUNT_SCHEDULED = "scheduled"
+	SERVICE_BUS_BACKLOG_COUNT_TRANSFER  = "transfer"
+
+	// the dead letter count is read right after the queue length, so it reuses counts fetched
+	// this recently instead of making a second request
+	serviceBusCountDetailsReuseWindow = 5 * time.Second
+)
+
+// DEFAULT_SERVICE_BUS_BACKLOG_COUNTS is the backlog formula used when none is configured
+var DEFAULT_SERVICE_BUS_BACKLOG_COUNTS = []string{SERVICE_BUS_BACKLOG_COUNT_ACTIVE}
+
+// ParseServiceBusBacklogCounts parses a comma separated list of the counts summed into the
+// queue length, e.g. "active,scheduled"
+func ParseServiceBusBacklogCounts(commaSeparatedCounts string) ([]string, error) {
+	counts := []string{}
+	seen := map[string]bool{}
+	for _, count := range strings.Split(commaSeparatedCounts, ",") {
+		count = strings.TrimSpace(count)
+		if count == "" {
+			continue
+		}
+		switch count {
+		case SERVICE_BUS_BACKLOG_COUNT_ACTIVE, SERVICE_BUS_BACKLOG_COUNT_SCHEDULED, SERVICE_BUS_BACKLOG_COUNT_TRANSFER:
+		default:
+			return nil, fmt.Errorf("unsupported service bus backlog count %q, supported counts are %s, %s and %s", count, SERVICE_BUS_BACKLOG_COUNT_ACTIVE, SERVICE_BUS_BACKLOG_COUNT_SCHEDULED, SERVICE_BUS_BACKLOG_COUNT_TRANSFER)
+		}
+		if !seen[count] {
+			seen[count] = true
+			counts = append(counts, count)
+		}
+	}
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("at least one service bus backlog count is required")
+	}
+	return counts, nil
+}
+
+// Backlog sums the counts making up the backlog, counts are SERVICE_BUS_BACKLOG_COUNT_ constants
+func (c ServiceBusCountDetails) Backlog(counts []string) int64 {
+	var backlog int64
+	for _, count := range counts {
+		switch count {
+		case SERVICE_BUS_BACKLOG_COUNT_ACTIVE:
+			backlog += c.ActiveMessageCount
+		case SERVICE_BUS_BACKLOG_COUNT_SCHEDULED:
+			backlog += c.ScheduledMessageCount
+		case SERVICE_BUS_BACKLOG_COUNT_TRANSFER:
+			backlog += c.TransferMessageCount
+		}
+	}
+	return backlog
+}
+
+// DeadLetters is the number of messages dead lettered, including those dead lettered while
+// being transferred
+func (c ServiceBusCountDetails) DeadLetters() int64 {
+	return c.DeadLetterMessageCount + c.TransferDeadLetterMessageCount
+}
+
+// recentServiceBusCountDetails remembers the last counts read by a reader
+type recentServiceBusCountDetails struct {
+	mu           sync.Mutex
+	fetchedAt    time.Time
+	countDetails ServiceBusCountDetails
+}
+
+func (r *recentServiceBusCountDetails) set(countDetails ServiceBusCountDetails) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.countDetails = countDetails
+	r.fetchedAt = time.Now()
+}
+
+func (r *recentServiceBusCountDetails) get() (ServiceBusCountDetails, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.fetchedAt) > serviceBusCountDetailsReuseWindow {
+		return ServiceBusCountDetails{}, false
+	}
+	return r.countDetails, true
+}
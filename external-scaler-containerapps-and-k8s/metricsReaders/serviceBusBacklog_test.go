@@ -0,0 +1,126 @@
+package metricsReaders
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/azureCredentials"
+)
+
+func TestParseServiceBusBacklogCounts(t *testing.T) {
+	testCases := []struct {
+		value       string
+		expected    []string
+		expectError bool
+	}{
+		{value: "active", expected: []string{"active"}},
+		{value: "active, scheduled,transfer", expected: []string{"active", "scheduled", "transfer"}},
+		{value: "scheduled,active,scheduled", expected: []string{"scheduled", "active"}},
+		{value: "active,deadletter", expectError: true},
+		{value: " , ", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		counts, err := ParseServiceBusBacklogCounts(tc.value)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("Expected an error for %q", tc.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%q)", err, tc.value)
+			continue
+		}
+		if !reflect.DeepEqual(counts, tc.expected) {
+			t.Errorf("Expected %v, but got %v (%q)", tc.expected, counts, tc.value)
+		}
+	}
+}
+
+func TestServiceBusCountDetailsBacklog(t *testing.T) {
+	countDetails := ServiceBusCountDetails{
+		ActiveMessageCount:             42,
+		DeadLetterMessageCount:         3,
+		ScheduledMessageCount:          12,
+		TransferMessageCount:           5,
+		TransferDeadLetterMessageCount: 1,
+	}
+
+	testCases := []struct {
+		counts   []string
+		expected int64
+	}{
+		{counts: DEFAULT_SERVICE_BUS_BACKLOG_COUNTS, expected: 42},
+		{counts: []string{SERVICE_BUS_BACKLOG_COUNT_ACTIVE, SERVICE_BUS_BACKLOG_COUNT_SCHEDULED}, expected: 54},
+		{counts: []string{SERVICE_BUS_BACKLOG_COUNT_ACTIVE, SERVICE_BUS_BACKLOG_COUNT_SCHEDULED, SERVICE_BUS_BACKLOG_COUNT_TRANSFER}, expected: 59},
+	}
+
+	for _, tc := range testCases {
+		if backlog := countDetails.Backlog(tc.counts); backlog != tc.expected {
+			t.Errorf("Expected %d, but got %d (%v)", tc.expected, backlog, tc.counts)
+		}
+	}
+
+	if deadLetters := countDetails.DeadLetters(); deadLetters != 4 {
+		t.Errorf("Expected 4 dead letters, but got %d", deadLetters)
+	}
+}
+
+func TestGetDeadLetterCountReusesQueueLengthCounts(t *testing.T) {
+	const serviceBusResourceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-aoaiscaler/providers/Microsoft.ServiceBus/namespaces/aoaiscaler"
+
+	armServer := newFakeAzureServer(t, map[string]string{
+		serviceBusResourceID + "/topics/embeddings/subscriptions/subscriber-app": "servicebus_subscription.json",
+	}, "https://management.azure.com")
+
+	var requests atomic.Int32
+	countingTransport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		return http.DefaultTransport.RoundTrip(r)
+	})
+
+	cloud, err := azureCredentials.GetCloud(azureCredentials.AZURE_PUBLIC_CLOUD, azureCredentials.Cloud{ResourceManagerEndpoint: armServer.URL, ResourceManagerAudience: "https://management.azure.com"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	a := NewAzureMetricsReader(AzureMetricsReaderConfig{
+		ServiceBusResourceID:            serviceBusResourceID,
+		ServiceBusQueueOrTopicName:      "embeddings",
+		ServiceBusTopicSubscriptionName: "subscriber-app",
+		ServiceBusBacklogCounts:         []string{SERVICE_BUS_BACKLOG_COUNT_ACTIVE, SERVICE_BUS_BACKLOG_COUNT_SCHEDULED},
+		Credential:                      fakeTokenProvider{},
+		Cloud:                           cloud,
+		HTTPClient:                      &http.Client{Transport: countingTransport},
+	})
+
+	queueLength, err := a.GetQueueLength(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if queueLength != 54 {
+		t.Errorf("Expected queue length 54, but got %d", queueLength)
+	}
+
+	deadLetterCount, err := a.GetDeadLetterCount(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if deadLetterCount != 3 {
+		t.Errorf("Expected 3 dead letters, but got %d", deadLetterCount)
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("Expected 1 request, but got %d", requests.Load())
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -0,0 +1,206 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE = "dataPlane"
+
+	SERVICE_BUS_DATA_PLANE_API_VERSION = "2021-05"
+
+	// SAS tokens are signed per request, valid for this long
+	serviceBusSASTokenValidity = time.Hour
+)
+
+// ServiceBusConnectionString holds the parts of a Service Bus connection string used by the
+// data plane reader, either SharedAccessKeyName and SharedAccessKey, or a SharedAccessSignature
+type ServiceBusConnectionString struct {
+	Endpoint              string
+	SharedAccessKeyName   string
+	SharedAccessKey       string
+	SharedAccessSignature string
+}
+
+// ParseServiceBusConnectionString parses a connection string such as
+// Endpoint=sb://<ns>.servicebus.windows.net/;SharedAccessKeyName=<name>;SharedAccessKey=<key>
+func ParseServiceBusConnectionString(connectionString string) (ServiceBusConnectionString, error) {
+	var c ServiceBusConnectionString
+	for _, part := range strings.Split(connectionString, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		// keys are base64 and can end in =, so only split on the first =
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return ServiceBusConnectionString{}, fmt.Errorf("invalid service bus connection string, %q is not a key=value pair", key)
+		}
+		switch strings.ToLower(key) {
+		case "endpoint":
+			c.Endpoint = value
+		case "sharedaccesskeyname":
+			c.SharedAccessKeyName = value
+		case "sharedaccesskey":
+			c.SharedAccessKey = value
+		case "sharedaccesssignature":
+			c.SharedAccessSignature = value
+		}
+	}
+
+	if c.Endpoint == "" {
+		return ServiceBusConnectionString{}, fmt.Errorf("invalid service bus connection string, Endpoint is required")
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return ServiceBusConnectionString{}, fmt.Errorf("invalid service bus connection string, Endpoint %q is not a URL", c.Endpoint)
+	}
+	// the data plane management endpoint is https, connection strings have the AMQP sb:// scheme
+	if endpoint.Scheme == "sb" {
+		endpoint.Scheme = "https"
+	}
+	endpoint.Path = ""
+	c.Endpoint = endpoint.String()
+
+	if c.SharedAccessSignature == "" && (c.SharedAccessKeyName == "" || c.SharedAccessKey == "") {
+		return ServiceBusConnectionString{}, fmt.Errorf("invalid service bus connection string, SharedAccessKeyName and SharedAccessKey or SharedAccessSignature are required")
+	}
+
+	return c, nil
+}
+
+// sasToken returns the SharedAccessSignature authorization header value for resourceURI, see
+// https://learn.microsoft.com/azure/service-bus-messaging/service-bus-sas#generate-a-shared-access-signature-token
+func (c ServiceBusConnectionString) sasToken(resourceURI string, expiry time.Time) string {
+	if c.SharedAccessSignature != "" {
+		return c.SharedAccessSignature
+	}
+
+	encodedResourceURI := url.QueryEscape(strings.ToLower(resourceURI))
+	se := strconv.FormatInt(expiry.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(c.SharedAccessKey))
+	mac.Write([]byte(encodedResourceURI + "\n" + se))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	return fmt.Sprintf("SharedAccessSignature sr=%s&sig=%s&se=%s&skn=%s", encodedResourceURI, url.QueryEscape(signature), se, url.QueryEscape(c.SharedAccessKeyName))
+}
+
+// serviceBusDataPlaneEntry is the ATOM entry returned for a queue or subscription. Element
+// names are matched without their namespaces
+type serviceBusDataPlaneEntry struct {
+	XMLName xml.Name
+	Content struct {
+		QueueDescription        *serviceBusDataPlaneDescription `xml:"QueueDescription"`
+		SubscriptionDescription *serviceBusDataPlaneDescription `xml:"SubscriptionDescription"`
+	} `xml:"content"`
+}
+
+type serviceBusDataPlaneDescription struct {
+	CountDetails *struct {
+		ActiveMessageCount             int64 `xml:"ActiveMessageCount"`
+		DeadLetterMessageCount         int64 `xml:"DeadLetterMessageCount"`
+		ScheduledMessageCount          int64 `xml:"ScheduledMessageCount"`
+		TransferMessageCount           int64 `xml:"TransferMessageCount"`
+		TransferDeadLetterMessageCount int64 `xml:"TransferDeadLetterMessageCount"`
+	} `xml:"CountDetails"`
+}
+
+// serviceBusDataPlaneError is the body of data plane error responses
+type serviceBusDataPlaneError struct {
+	Code   string `xml:"Code"`
+	Detail string `xml:"Detail"`
+}
+
+// GetServiceBusDataPlaneRequestUri returns the data plane management URI of the queue or topic subscription
+func (a *AzureMetricsReader) GetServiceBusDataPlaneRequestUri() string {
+	if a.serviceBusTopicSubcriptionName == "" {
+		return fmt.Sprintf("%s/%s?api-version=%s", a.serviceBusConnectionString.Endpoint, url.PathEscape(a.servBusQueueOrTopicName), SERVICE_BUS_DATA_PLANE_API_VERSION)
+	}
+	return fmt.Sprintf("%s/%s/Subscriptions/%s?api-version=%s", a.serviceBusConnectionString.Endpoint, url.PathEscape(a.servBusQueueOrTopicName), url.PathEscape(a.serviceBusTopicSubcriptionName), SERVICE_BUS_DATA_PLANE_API_VERSION)
+}
+
+// getServiceBusCountDetailsFromDataPlane gets the counts from the namespace's data plane
+// management endpoint, signing the request with the connection string's SAS key, which needs
+// the Manage right
+func (a *AzureMetricsReader) getServiceBusCountDetailsFromDataPlane(ctx context.Context) (ServiceBusCountDetails, error) {
+	if a.serviceBusConnectionString == nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("a service bus connection string is required for the %s queue length source", SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE)
+	}
+
+	requestUri := a.GetServiceBusDataPlaneRequestUri()
+	slog.Debug(fmt.Sprintf("Request URI: %s\n", requestUri))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUri, nil)
+	if err != nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("could not create get request: %w", err)
+	}
+
+	resourceURI := strings.SplitN(requestUri, "?", 2)[0]
+	req.Header.Set("Accept", "application/atom+xml")
+	req.Header.Set("User-Agent", "Go HTTP Client")
+	req.Header.Set("Authorization", a.serviceBusConnectionString.sasToken(resourceURI, time.Now().Add(serviceBusSASTokenValidity)))
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("could not make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return parseServiceBusDataPlaneResponse(resp)
+}
+
+func parseServiceBusDataPlaneResponse(resp *http.Response) (ServiceBusCountDetails, error) {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		responseError := &ResponseError{StatusCode: resp.StatusCode, RetryAfter: parseRetryAfter(resp.Header)}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		if err == nil {
+			var errorResponse serviceBusDataPlaneError
+			if xml.Unmarshal(body, &errorResponse) == nil && errorResponse.Detail != "" {
+				responseError.Code = errorResponse.Code
+				responseError.Message = errorResponse.Detail
+			} else {
+				responseError.Message = string(body)
+			}
+		}
+		return ServiceBusCountDetails{}, fmt.Errorf("could not get service bus entity: %w", responseError)
+	}
+
+	var entry serviceBusDataPlaneEntry
+	if err := xml.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("could not decode response body: %w", err)
+	}
+
+	// the data plane answers requests for entities that don't exist with an empty feed
+	if entry.XMLName.Local != "entry" {
+		return ServiceBusCountDetails{}, fmt.Errorf("could not get service bus entity: %w", &ResponseError{StatusCode: http.StatusNotFound, Code: "EntityNotFound", Message: "the queue or topic subscription was not found"})
+	}
+
+	description := entry.Content.QueueDescription
+	if description == nil {
+		description = entry.Content.SubscriptionDescription
+	}
+	if description == nil || description.CountDetails == nil {
+		return ServiceBusCountDetails{}, fmt.Errorf("service bus entity response has no CountDetails")
+	}
+
+	return ServiceBusCountDetails{
+		ActiveMessageCount:             description.CountDetails.ActiveMessageCount,
+		DeadLetterMessageCount:         description.CountDetails.DeadLetterMessageCount,
+		ScheduledMessageCount:          description.CountDetails.ScheduledMessageCount,
+		TransferMessageCount:           description.CountDetails.TransferMessageCount,
+		TransferDeadLetterMessageCount: description.CountDetails.TransferDeadLetterMessageCount,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseServiceBusConnectionString(t *testing.T) {
+	testCases := []struct {
+		connectionString string
+		expected         ServiceBusConnectionString
+		expectError      bool
+	}{
+		{
+			connectionString: "Endpoint=sb://aoaiscaler.servicebus.windows.net/;SharedAccessKeyName=RootManageSharedAccessKey;SharedAccessKey=c2VjcmV0LWtleQ==",
+			expected: ServiceBusConnectionString{
+				Endpoint:            "https://aoaiscaler.servicebus.windows.net",
+				SharedAccessKeyName: "RootManageSharedAccessKey",
+				SharedAccessKey:     "c2VjcmV0LWtleQ==",
+			},
+		},
+		{
+			connectionString: "Endpoint=sb://aoaiscaler.servicebus.windows.net/;SharedAccessSignature=SharedAccessSignature sr=x&sig=y&se=1&skn=z",
+			expected: ServiceBusConnectionString{
+				Endpoint:              "https://aoaiscaler.servicebus.windows.net",
+				SharedAccessSignature: "SharedAccessSignature sr=x&sig=y&se=1&skn=z",
+			},
+		},
+		{connectionString: "SharedAccessKeyName=RootManageSharedAccessKey;SharedAccessKey=c2VjcmV0LWtleQ==", expectError: true},
+		{connectionString: "Endpoint=sb://aoaiscaler.servicebus.windows.net/;SharedAccessKeyName=RootManageSharedAccessKey", expectError: true},
+		{connectionString: "Endpoint=sb://aoaiscaler.servicebus.windows.net/;SharedAccessKey", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		c, err := ParseServiceBusConnectionString(tc.connectionString)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("Expected an error for %q", tc.connectionString)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%q)", err, tc.connectionString)
+			continue
+		}
+		if c != tc.expected {
+			t.Errorf("Expected %+v, but got %+v", tc.expected, c)
+		}
+	}
+}
+
+func TestServiceBusSASToken(t *testing.T) {
+	c := ServiceBusConnectionString{
+		Endpoint:            "https://aoaiscaler.servicebus.windows.net",
+		SharedAccessKeyName: "RootManageSharedAccessKey",
+		SharedAccessKey:     "c2VjcmV0LWtleQ==",
+	}
+	expiry := time.Unix(1724940131, 0)
+
+	token := c.sasToken("https://aoaiscaler.servicebus.windows.net/Embeddings/Subscriptions/subscriber-app", expiry)
+
+	values, err := url.ParseQuery(strings.TrimPrefix(token, "SharedAccessSignature "))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if values.Get("sr") != "https://aoaiscaler.servicebus.windows.net/embeddings/subscriptions/subscriber-app" {
+		t.Errorf("Unexpected sr %s", values.Get("sr"))
+	}
+	if values.Get("se") != "1724940131" || values.Get("skn") != "RootManageSharedAccessKey" {
+		t.Errorf("Unexpected se %s or skn %s", values.Get("se"), values.Get("skn"))
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.SharedAccessKey))
+	mac.Write([]byte(url.QueryEscape(values.Get("sr")) + "\n" + values.Get("se")))
+	if expected := base64.StdEncoding.EncodeToString(mac.Sum(nil)); values.Get("sig") != expected {
+		t.Errorf("Expected signature %s, but got %s", expected, values.Get("sig"))
+	}
+}
+
+func TestGetServiceBusCountDetailsFromDataPlane(t *testing.T) {
+	testCases := []struct {
+		name               string
+		statusCode         int
+		fixture            string
+		expected           ServiceBusCountDetails
+		expectedStatusCode int
+	}{
+		{
+			name:       "subscription",
+			statusCode: http.StatusOK,
+			fixture:    "servicebus_dataplane_subscription.xml",
+			expected:   ServiceBusCountDetails{ActiveMessageCount: 42, DeadLetterMessageCount: 3, ScheduledMessageCount: 12},
+		},
+		{
+			name:               "entity not found",
+			statusCode:         http.StatusOK,
+			fixture:            "servicebus_dataplane_not_found.xml",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "missing manage right",
+			statusCode:         http.StatusUnauthorized,
+			fixture:            "servicebus_dataplane_unauthorized.xml",
+			expectedStatusCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		body, err := os.ReadFile(filepath.Join("testdata", tc.fixture))
+		if err != nil {
+			t.Fatalf("Failed to read fixture %s: %v", tc.fixture, err)
+		}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/embeddings/Subscriptions/subscriber-app" {
+				t.Errorf("Unexpected path %s (%s)", r.URL.Path, tc.name)
+			}
+			if !strings.HasPrefix(r.Header.Get("Authorization"), "SharedAccessSignature sr=") {
+				t.Errorf("Expected a SAS token, but got %q (%s)", r.Header.Get("Authorization"), tc.name)
+			}
+			w.Header().Set("Content-Type", "application/atom+xml")
+			w.WriteHeader(tc.statusCode)
+			_, _ = w.Write(body)
+		}))
+		defer server.Close()
+
+		connectionString, err := ParseServiceBusConnectionString("Endpoint=" + server.URL + "/;SharedAccessKeyName=RootManageSharedAccessKey;SharedAccessKey=c2VjcmV0LWtleQ==")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		a := NewAzureMetricsReader(AzureMetricsReaderConfig{
+			ServiceBusQueueOrTopicName:      "embeddings",
+			ServiceBusTopicSubscriptionName: "subscriber-app",
+			ServiceBusQueueLengthSource:     SERVICE_BUS_QUEUE_LENGTH_SOURCE_DATA_PLANE,
+			ServiceBusConnectionString:      &connectionString,
+		})
+
+		countDetails, err := a.GetServiceBusCountDetails(context.Background())
+		if tc.expectedStatusCode != 0 {
+			var responseError *ResponseError
+			if !errors.As(err, &responseError) || responseError.StatusCode != tc.expectedStatusCode {
+				t.Errorf("Expected a ResponseError with status %d, but got %v (%s)", tc.expectedStatusCode, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.name)
+		}
+		if countDetails != tc.expected {
+			t.Errorf("Expected %+v, but got %+v (%s)", tc.expected, countDetails, tc.name)
+		}
+	}
+}
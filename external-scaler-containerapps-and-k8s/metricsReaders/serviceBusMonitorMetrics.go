@@ -0,0 +1,118 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SERVICE_BUS_QUEUE_LENGTH_SOURCE_ARM           = "arm"
+	SERVICE_BUS_QUEUE_LENGTH_SOURCE_AZURE_MONITOR = "azureMonitor"
+
+	// Azure Monitor metrics have a one minute granularity, so readers of the same namespace
+	// share one request for the counts of all its entities for this long
+	serviceBusMonitorMetricsCacheTTL = 30 * time.Second
+	// the period queried for the latest datapoints
+	serviceBusMonitorMetricsTimespan = 5 * time.Minute
+	// maximum number of entities returned per metric
+	serviceBusMonitorMetricsTop = 1000
+)
+
+// serviceBusNamespaceMetrics holds the latest message counts of all entities of a namespace,
+// keyed by lower cased entity name
+type serviceBusNamespaceMetrics struct {
+	mu        sync.Mutex
+	fetchedAt time.Time
+	entities  map[string]ServiceBusCountDetails
+}
+
+var (
+	serviceBusNamespaceMetricsMu    sync.Mutex
+	serviceBusNamespaceMetricsCache = map[string]*serviceBusNamespaceMetrics{}
+)
+
+func getServiceBusNamespaceMetrics(key string) *serviceBusNamespaceMetrics {
+	serviceBusNamespaceMetricsMu.Lock()
+	defer serviceBusNamespaceMetricsMu.Unlock()
+
+	namespaceMetrics, ok := serviceBusNamespaceMetricsCache[key]
+	if !ok {
+		namespaceMetrics = &serviceBusNamespaceMetrics{}
+		serviceBusNamespaceMetricsCache[key] = namespaceMetrics
+	}
+	return namespaceMetrics
+}
+
+// getServiceBusCountDetailsFromAzureMonitor gets the queue or topic counts from the namespace's
+// ActiveMessages, DeadletteredMessages and ScheduledMessages metrics. Azure Monitor has no
+// subscription dimension, so for topics these are the counts across all subscriptions, and
+// there are no transfer counts
+func (a *AzureMetricsReader) getServiceBusCountDetailsFromAzureMonitor(ctx context.Context) (ServiceBusCountDetails, error) {
+	namespaceMetrics := getServiceBusNamespaceMetrics(a.cloud.ResourceManagerEndpoint + a.servicebusResourceID)
+
+	// holding the lock while fetching makes concurrent readers of the namespace wait for, and
+	// then share, a single request
+	namespaceMetrics.mu.Lock()
+	defer namespaceMetrics.mu.Unlock()
+
+	if time.Since(namespaceMetrics.fetchedAt) > serviceBusMonitorMetricsCacheTTL {
+		entities, err := a.getServiceBusNamespaceCountDetails(ctx)
+		if err != nil {
+			return ServiceBusCountDetails{}, err
+		}
+		namespaceMetrics.entities = entities
+		namespaceMetrics.fetchedAt = time.Now()
+	}
+
+	countDetails, ok := namespaceMetrics.entities[strings.ToLower(a.servBusQueueOrTopicName)]
+	if !ok {
+		// entities with no messages for the whole timespan can be missing
+		return ServiceBusCountDetails{}, nil
+	}
+
+	return countDetails, nil
+}
+
+// getServiceBusNamespaceCountDetails gets the counts of all entities of the namespace in one request
+func (a *AzureMetricsReader) getServiceBusNamespaceCountDetails(ctx context.Context) (map[string]ServiceBusCountDetails, error) {
+	query := url.Values{}
+	query.Set("metricnames", "ActiveMessages,DeadletteredMessages,ScheduledMessages")
+	query.Set("aggregation", "Average")
+	query.Set("interval", "PT1M")
+	query.Set("timespan", azureMonitorTimespan(serviceBusMonitorMetricsTimespan, 0))
+	query.Set("$filter", "EntityName eq '*'")
+	query.Set("top", fmt.Sprint(serviceBusMonitorMetricsTop))
+
+	metrics, err := a.GetAzureMonitorMetrics(ctx, a.servicebusResourceID, query)
+	if err != nil {
+		return nil, fmt.Errorf("could not get service bus namespace metrics: %w", err)
+	}
+
+	entities := map[string]ServiceBusCountDetails{}
+	for _, metric := range metrics.Value {
+		for _, timeseries := range metric.Timeseries {
+			entityName := strings.ToLower(timeseries.dimensionValue("EntityName"))
+			value, ok := timeseries.latestAverage()
+			if entityName == "" || !ok {
+				continue
+			}
+
+			countDetails := entities[entityName]
+			switch metric.Name.Value {
+			case "ActiveMessages":
+				countDetails.ActiveMessageCount = int64(value)
+			case "DeadletteredMessages":
+				countDetails.DeadLetterMessageCount = int64(value)
+			case "ScheduledMessages":
+				countDetails.ScheduledMessageCount = int64(value)
+			}
+			entities[entityName] = countDetails
+		}
+	}
+
+	return entities, nil
+}
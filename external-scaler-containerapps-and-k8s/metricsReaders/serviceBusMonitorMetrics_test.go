@@ -0,0 +1,71 @@
+package metricsReaders
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/azureCredentials"
+)
+
+func TestServiceBusCountDetailsFromAzureMonitorAreBatchedPerNamespace(t *testing.T) {
+	const serviceBusResourceID = "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-aoaiscaler/providers/Microsoft.ServiceBus/namespaces/aoaiscaler"
+
+	body, err := os.ReadFile(filepath.Join("testdata", "servicebus_monitor_metrics.json"))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != serviceBusResourceID+"/providers/Microsoft.Insights/metrics" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("$filter") != "EntityName eq '*'" {
+			t.Errorf("Unexpected filter %s", r.URL.Query().Get("$filter"))
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	cloud, err := azureCredentials.GetCloud(azureCredentials.AZURE_PUBLIC_CLOUD, azureCredentials.Cloud{ResourceManagerEndpoint: server.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		entity   string
+		expected ServiceBusCountDetails
+	}{
+		{entity: "embeddings", expected: ServiceBusCountDetails{ActiveMessageCount: 41, DeadLetterMessageCount: 2, ScheduledMessageCount: 12}},
+		{entity: "Orders", expected: ServiceBusCountDetails{ActiveMessageCount: 9}},
+		{entity: "idle", expected: ServiceBusCountDetails{}},
+	}
+
+	for _, tc := range testCases {
+		a := NewAzureMetricsReader(AzureMetricsReaderConfig{
+			ServiceBusResourceID:        serviceBusResourceID,
+			ServiceBusQueueOrTopicName:  tc.entity,
+			ServiceBusQueueLengthSource: SERVICE_BUS_QUEUE_LENGTH_SOURCE_AZURE_MONITOR,
+			Credential:                  fakeTokenProvider{},
+			Cloud:                       cloud,
+		})
+
+		countDetails, err := a.GetServiceBusCountDetails(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.entity)
+		}
+		if countDetails != tc.expected {
+			t.Errorf("Expected %+v, but got %+v (%s)", tc.expected, countDetails, tc.entity)
+		}
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("Expected 1 request for the namespace, but got %d", requests.Load())
+	}
+}
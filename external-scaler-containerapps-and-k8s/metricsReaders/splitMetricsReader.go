@@ -0,0 +1,75 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+)
+
+// QueueLengthReader reads the queue length from a queue backend
+type QueueLengthReader interface {
+	GetQueueLength(ctx context.Context) (int, error)
+}
+
+// Rate429ErrorsReader reads the rate of 429 errors from a metrics backend
+type Rate429ErrorsReader interface {
+	GetRate429Errors(ctx context.Context) (int, error)
+}
+
+// deadLetterCountReader is implemented by queue readers whose queue has a dead letter count
+type deadLetterCountReader interface {
+	GetDeadLetterCount(ctx context.Context) (int, error)
+}
+
+// deadLetterCountSupporter is implemented by queue readers that have a dead letter count for
+// some of their configurations only, e.g. the Azure reader for Service Bus but not storage queues
+type deadLetterCountSupporter interface {
+	SupportsDeadLetterCount() bool
+}
+
+// SplitMetricsReader reads the queue length and the 429 errors from different backends, for
+// queues such as RabbitMQ that have no error metrics of their own
+type SplitMetricsReader struct {
+	queueLengthReader   QueueLengthReader
+	rate429ErrorsReader Rate429ErrorsReader
+}
+
+// NewSplitMetricsReader creates a SplitMetricsReader, with no rate429ErrorsReader the 429 error
+// rate is always 0, so replicas follow the queue length alone
+func NewSplitMetricsReader(queueLengthReader QueueLengthReader, rate429ErrorsReader Rate429ErrorsReader) *SplitMetricsReader {
+	return &SplitMetricsReader{
+		queueLengthReader:   queueLengthReader,
+		rate429ErrorsReader: rate429ErrorsReader,
+	}
+}
+
+func (s *SplitMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	return s.queueLengthReader.GetQueueLength(ctx)
+}
+
+func (s *SplitMetricsReader) GetRate429Errors(ctx context.Context) (int, error) {
+	if s.rate429ErrorsReader == nil {
+		return 0, nil
+	}
+	return s.rate429ErrorsReader.GetRate429Errors(ctx)
+}
+
+// SupportsDeadLetterCount reports whether the queue backend has a dead letter count
+func (s *SplitMetricsReader) SupportsDeadLetterCount() bool {
+	if _, ok := s.queueLengthReader.(deadLetterCountReader); !ok {
+		return false
+	}
+	if supporter, ok := s.queueLengthReader.(deadLetterCountSupporter); ok {
+		return supporter.SupportsDeadLetterCount()
+	}
+	return true
+}
+
+// GetDeadLetterCount gets the dead letter count from the queue backend, the 429 error backend
+// has no queue
+func (s *SplitMetricsReader) GetDeadLetterCount(ctx context.Context) (int, error) {
+	reader, ok := s.queueLengthReader.(deadLetterCountReader)
+	if !ok || !s.SupportsDeadLetterCount() {
+		return 0, fmt.Errorf("the dead letter count is not supported by the queue backend")
+	}
+	return reader.GetDeadLetterCount(ctx)
+}
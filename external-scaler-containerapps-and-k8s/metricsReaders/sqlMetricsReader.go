@@ -0,0 +1,132 @@
+package metricsReaders
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	DEFAULT_SQL_DRIVER_NAME    = "postgres"
+	DEFAULT_SQL_QUERY_TIMEOUT  = 5 * time.Second
+	DEFAULT_SQL_MAX_OPEN_CONNS = 2
+
+	// idle connections are closed after this long, as queries run once per polling interval
+	sqlConnMaxIdleTime = 5 * time.Minute
+)
+
+type SQLMetricsReaderConfig struct {
+	// DriverName is the database/sql driver, registered by the binary, defaults to
+	// DEFAULT_SQL_DRIVER_NAME. Only Postgres drivers are supported, as the query timeout is set
+	// with SET LOCAL statement_timeout
+	DriverName     string
+	DataSourceName string
+	// Query returns the queue length in the first column of its first row, with Args as its
+	// parameters, e.g. SELECT count(*) FROM outbox WHERE status = $1. See SQLCountQuery
+	Query string
+	Args  []any
+	// QueryTimeout cancels the query, and the transaction it runs in, when it takes longer. It
+	// is also set as the transaction's statement_timeout, so the server cancels the query even
+	// when the client's cancel request is lost. Defaults to DEFAULT_SQL_QUERY_TIMEOUT
+	QueryTimeout time.Duration
+	// MaxOpenConns limits the connection pool, defaults to DEFAULT_SQL_MAX_OPEN_CONNS
+	MaxOpenConns int
+}
+
+// SQLMetricsReader reads the queue length with a query against a Postgres database, the only
+// database supported, in a read only transaction
+type SQLMetricsReader struct {
+	db           *sql.DB
+	query        string
+	args         []any
+	queryTimeout time.Duration
+}
+
+// SQLCountQuery returns a query counting the rows of table whose column equals the first
+// parameter. table and column are expected to have been validated with ValidateSQLIdentifier
+func SQLCountQuery(table string, column string) string {
+	return fmt.Sprintf("SELECT count(*) FROM %s WHERE %s = $1", table, column)
+}
+
+func NewSQLMetricsReader(config SQLMetricsReaderConfig) (*SQLMetricsReader, error) {
+	if config.DataSourceName == "" || config.Query == "" {
+		return nil, fmt.Errorf("sql data source name and query are required")
+	}
+	if err := ValidateSQLQuery("query", config.Query); err != nil {
+		return nil, err
+	}
+
+	driverName := config.DriverName
+	if driverName == "" {
+		driverName = DEFAULT_SQL_DRIVER_NAME
+	}
+	queryTimeout := config.QueryTimeout
+	if queryTimeout <= 0 {
+		queryTimeout = DEFAULT_SQL_QUERY_TIMEOUT
+	}
+	maxOpenConns := config.MaxOpenConns
+	if maxOpenConns <= 0 {
+		maxOpenConns = DEFAULT_SQL_MAX_OPEN_CONNS
+	}
+
+	// connections are made on first use, the data source name holds credentials so it is
+	// not included in errors
+	db, err := sql.Open(driverName, config.DataSourceName)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s database: invalid data source name", driverName)
+	}
+	db.SetMaxOpenConns(maxOpenConns)
+	db.SetMaxIdleConns(maxOpenConns)
+	db.SetConnMaxIdleTime(sqlConnMaxIdleTime)
+
+	return &SQLMetricsReader{
+		db:           db,
+		query:        config.Query,
+		args:         config.Args,
+		queryTimeout: queryTimeout,
+	}, nil
+}
+
+// Close closes the connection pool
+func (s *SQLMetricsReader) Close() error {
+	return s.db.Close()
+}
+
+// GetQueueLength runs the query in a read only transaction, so user defined queries can't
+// change the database, with the query timeout as its statement_timeout
+func (s *SQLMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, fmt.Errorf("could not begin read only transaction: %w", err)
+	}
+	// nothing is written, so the transaction is always rolled back
+	defer tx.Rollback()
+
+	// SET LOCAL only lasts until the end of the transaction, so pooled connections keep their
+	// own statement_timeout
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", s.queryTimeout.Milliseconds())); err != nil {
+		return 0, fmt.Errorf("could not set the statement timeout: %w", err)
+	}
+
+	var queueLength sql.NullInt64
+	err = tx.QueryRowContext(ctx, s.query, s.args...).Scan(&queueLength)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return 0, fmt.Errorf("sql queue length query timed out after %v: %w", s.queryTimeout, context.DeadlineExceeded)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("sql queue length query returned no rows")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not run sql queue length query: %w", err)
+	}
+
+	// queries such as sum() return NULL when no rows match
+	slog.Debug(fmt.Sprintf("sql queue length: %d (null: %t)", queueLength.Int64, !queueLength.Valid))
+	return int(queueLength.Int64), nil
+}
@@ -0,0 +1,218 @@
+package metricsReaders
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQLDriver answers every query with a single row holding result, or blocks until the
+// query's context is done when block is set. Statements, queries, their arguments and
+// transaction options are recorded per data source name
+type fakeSQLDriver struct {
+	mu      sync.Mutex
+	sources map[string]*fakeSQLSource
+}
+
+type fakeSQLSource struct {
+	result   driver.Value
+	err      error
+	block    bool
+	execs    []string
+	queries  []string
+	args     [][]driver.NamedValue
+	readOnly []bool
+}
+
+var sqlTestDriver = &fakeSQLDriver{sources: map[string]*fakeSQLSource{}}
+
+func init() {
+	sql.Register("fakesql", sqlTestDriver)
+}
+
+func (d *fakeSQLDriver) addSource(name string, source *fakeSQLSource) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sources[name] = source
+}
+
+func (d *fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	source, ok := d.sources[name]
+	if !ok {
+		return nil, errors.New("unknown data source")
+	}
+	return &fakeSQLConn{driver: d, source: source}, nil
+}
+
+type fakeSQLConn struct {
+	driver *fakeSQLDriver
+	source *fakeSQLSource
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (c *fakeSQLConn) Close() error { return nil }
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("begin without options is not supported")
+}
+
+func (c *fakeSQLConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	c.source.readOnly = append(c.source.readOnly, opts.ReadOnly)
+	return c, nil
+}
+
+func (c *fakeSQLConn) Commit() error   { return nil }
+func (c *fakeSQLConn) Rollback() error { return nil }
+
+func (c *fakeSQLConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	c.source.execs = append(c.source.execs, query)
+	return driver.ResultNoRows, nil
+}
+
+func (c *fakeSQLConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.driver.mu.Lock()
+	c.source.queries = append(c.source.queries, query)
+	c.source.args = append(c.source.args, args)
+	c.driver.mu.Unlock()
+
+	if c.source.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if c.source.err != nil {
+		return nil, c.source.err
+	}
+	return &fakeSQLRows{value: c.source.result}, nil
+}
+
+type fakeSQLRows struct {
+	value driver.Value
+	done  bool
+}
+
+func (r *fakeSQLRows) Columns() []string { return []string{"count"} }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.value
+	return nil
+}
+
+func TestSQLMetricsReader(t *testing.T) {
+	testCases := []struct {
+		name                string
+		source              *fakeSQLSource
+		query               string
+		args                []any
+		queryTimeout        time.Duration
+		expectedQueueLength int
+		expectedError       string
+		expectedExecs       []string
+	}{
+		{
+			name:                "count of pending rows",
+			source:              &fakeSQLSource{result: int64(42)},
+			query:               SQLCountQuery("jobs.outbox", "status"),
+			args:                []any{"pending"},
+			expectedQueueLength: 42,
+			expectedExecs:       []string{"SET LOCAL statement_timeout = 5000"},
+		},
+		{
+			name:                "null result",
+			source:              &fakeSQLSource{result: nil},
+			query:               "SELECT sum(batch_size) FROM outbox WHERE status = $1",
+			args:                []any{"pending"},
+			expectedQueueLength: 0,
+		},
+		{
+			name:          "query error",
+			source:        &fakeSQLSource{err: errors.New(`relation "outbox" does not exist`)},
+			query:         SQLCountQuery("outbox", "status"),
+			args:          []any{"pending"},
+			expectedError: `relation "outbox" does not exist`,
+		},
+		{
+			name:          "statement timeout",
+			source:        &fakeSQLSource{block: true},
+			query:         SQLCountQuery("outbox", "status"),
+			args:          []any{"pending"},
+			queryTimeout:  50 * time.Millisecond,
+			expectedError: "timed out after 50ms",
+			expectedExecs: []string{"SET LOCAL statement_timeout = 50"},
+		},
+	}
+
+	for _, tc := range testCases {
+		sqlTestDriver.addSource(tc.name, tc.source)
+		reader, err := NewSQLMetricsReader(SQLMetricsReaderConfig{
+			DriverName:     "fakesql",
+			DataSourceName: tc.name,
+			Query:          tc.query,
+			Args:           tc.args,
+			QueryTimeout:   tc.queryTimeout,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create reader: %v (%s)", err, tc.name)
+		}
+
+		queueLength, err := reader.GetQueueLength(context.Background())
+		reader.Close()
+
+		if tc.expectedExecs != nil && strings.Join(tc.source.execs, ";") != strings.Join(tc.expectedExecs, ";") {
+			t.Errorf("Expected statements %v, but got %v (%s)", tc.expectedExecs, tc.source.execs, tc.name)
+		}
+		if tc.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected error containing %q, but got %v (%s)", tc.expectedError, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if queueLength != tc.expectedQueueLength {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expectedQueueLength, queueLength, tc.name)
+		}
+		if len(tc.source.queries) != 1 || tc.source.queries[0] != tc.query {
+			t.Errorf("Expected query %q, but got %v (%s)", tc.query, tc.source.queries, tc.name)
+		}
+		if len(tc.source.args) != 1 || len(tc.source.args[0]) != 1 || tc.source.args[0][0].Value != tc.args[0] {
+			t.Errorf("Expected args %v, but got %v (%s)", tc.args, tc.source.args, tc.name)
+		}
+		if len(tc.source.readOnly) != 1 || !tc.source.readOnly[0] {
+			t.Errorf("Expected a read only transaction, but got %v (%s)", tc.source.readOnly, tc.name)
+		}
+	}
+}
+
+func TestNewSQLMetricsReaderRejectsStatements(t *testing.T) {
+	_, err := NewSQLMetricsReader(SQLMetricsReaderConfig{
+		DriverName:     "fakesql",
+		DataSourceName: "unused",
+		Query:          "DELETE FROM outbox WHERE status = $1",
+	})
+	var rejected *MetadataRejectedError
+	if !errors.As(err, &rejected) {
+		t.Errorf("Expected a MetadataRejectedError, but got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package metricsReaders
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+type SQSMetricsReaderConfig struct {
+	// QueueURL is the queue's URL, e.g. https://sqs.us-east-1.amazonaws.com/123456789012/embeddings
+	QueueURL string
+	// Region defaults to the region in QueueURL
+	Region string
+	// Endpoint overrides the SQS endpoint, for SQS compatible services and VPC endpoints
+	Endpoint string
+
+	// AccessKeyID, SecretAccessKey and SessionToken are static credentials. When AccessKeyID is
+	// not set, credentials come from the SDK's default chain: environment variables, shared
+	// config, web identity (IRSA) and instance or task roles
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// IncludeInFlight adds the messages received by consumers but not yet deleted
+	IncludeInFlight bool
+	// IncludeDelayed adds the messages not yet visible because of a delivery delay
+	IncludeDelayed bool
+
+	// HTTPClient makes the SQS requests, defaults to the SDK's client
+	HTTPClient *http.Client
+}
+
+type SQSMetricsReader struct {
+	client         *sqs.Client
+	queueURL       string
+	attributeNames []types.QueueAttributeName
+}
+
+// sqsRegionFromQueueURL returns the region of an AWS queue URL such as
+// https://sqs.us-east-1.amazonaws.com/123456789012/embeddings, or "" for other URLs
+func sqsRegionFromQueueURL(queueURL *url.URL) string {
+	labels := strings.Split(queueURL.Hostname(), ".")
+	if len(labels) >= 4 && labels[0] == "sqs" && labels[2] == "amazonaws" {
+		return labels[1]
+	}
+	return ""
+}
+
+func NewSQSMetricsReader(config SQSMetricsReaderConfig) (*SQSMetricsReader, error) {
+	queueURL, err := url.Parse(config.QueueURL)
+	if err != nil || queueURL.Host == "" {
+		return nil, fmt.Errorf("invalid SQS queue URL %q, expected a URL such as https://sqs.us-east-1.amazonaws.com/123456789012/embeddings", config.QueueURL)
+	}
+
+	region := config.Region
+	if region == "" {
+		region = sqsRegionFromQueueURL(queueURL)
+	}
+	if region == "" {
+		return nil, fmt.Errorf("SQS region is required when it is not part of the queue URL")
+	}
+
+	loadOptions := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	if config.AccessKeyID != "" {
+		loadOptions = append(loadOptions, awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(config.AccessKeyID, config.SecretAccessKey, config.SessionToken)))
+	}
+	if config.HTTPClient != nil {
+		loadOptions = append(loadOptions, awsconfig.WithHTTPClient(config.HTTPClient))
+	}
+	awsConfig, err := awsconfig.LoadDefaultConfig(context.Background(), loadOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not load AWS config: %w", err)
+	}
+
+	client := sqs.NewFromConfig(awsConfig, func(o *sqs.Options) {
+		// reads are retried by the scaler's RetryPolicy, the SDK's retries would multiply its
+		// attempts
+		o.RetryMaxAttempts = 1
+		if config.Endpoint != "" {
+			o.BaseEndpoint = aws.String(config.Endpoint)
+		}
+	})
+
+	attributeNames := []types.QueueAttributeName{types.QueueAttributeNameApproximateNumberOfMessages}
+	if config.IncludeInFlight {
+		attributeNames = append(attributeNames, types.QueueAttributeNameApproximateNumberOfMessagesNotVisible)
+	}
+	if config.IncludeDelayed {
+		attributeNames = append(attributeNames, types.QueueAttributeNameApproximateNumberOfMessagesDelayed)
+	}
+
+	return &SQSMetricsReader{
+		client:         client,
+		queueURL:       config.QueueURL,
+		attributeNames: attributeNames,
+	}, nil
+}
+
+func (s *SQSMetricsReader) GetQueueLength(ctx context.Context) (int, error) {
+	output, err := s.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(s.queueURL),
+		AttributeNames: s.attributeNames,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("could not get attributes of SQS queue %s: %w", s.queueURL, err)
+	}
+
+	queueLength := 0
+	for _, attributeName := range s.attributeNames {
+		value, ok := output.Attributes[string(attributeName)]
+		if !ok {
+			return 0, fmt.Errorf("SQS queue %s has no %s attribute", s.queueURL, attributeName)
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q for SQS queue %s: %w", attributeName, value, s.queueURL, err)
+		}
+		slog.Debug(fmt.Sprintf("SQS queue %s %s: %d", s.queueURL, attributeName, count))
+		queueLength += count
+	}
+
+	return queueLength, nil
+}
@@ -0,0 +1,212 @@
+package metricsReaders
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const sqsTestQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/embeddings"
+
+// verifySQSSignature signs a copy of the request with the expected credentials and compares
+// the Authorization headers
+func verifySQSSignature(r *http.Request, body []byte) bool {
+	authorization := r.Header.Get("Authorization")
+	_, signedHeaders, ok := strings.Cut(authorization, "SignedHeaders=")
+	if !ok {
+		return false
+	}
+	signedHeaders, _, _ = strings.Cut(signedHeaders, ",")
+	signingTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	signed, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), bytes.NewReader(body))
+	for _, header := range strings.Split(signedHeaders, ";") {
+		if header != "host" {
+			signed.Header.Set(header, r.Header.Get(header))
+		}
+	}
+	payloadHash := sha256.Sum256(body)
+	credentials := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: r.Header.Get("X-Amz-Security-Token")}
+	if err := v4.NewSigner().SignHTTP(context.Background(), credentials, signed, hex.EncodeToString(payloadHash[:]), "sqs", "us-east-1", signingTime); err != nil {
+		return false
+	}
+
+	return signed.Header.Get("Authorization") == authorization
+}
+
+// newFakeSQSServer stands in for SQS's JSON protocol, answering GetQueueAttributes for
+// sqsTestQueueURL with testdata fixtures
+func newFakeSQSServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		if target := r.Header.Get("X-Amz-Target"); target != "AmazonSQS.GetQueueAttributes" {
+			t.Errorf("Unexpected target %s", target)
+		}
+		var input struct {
+			QueueUrl       string
+			AttributeNames []string
+		}
+		if err := json.Unmarshal(body, &input); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+
+		fixture := "sqs_queue_attributes.json"
+		switch {
+		case !verifySQSSignature(r, body):
+			fixture = "sqs_signature_does_not_match.json"
+			w.WriteHeader(http.StatusForbidden)
+		case input.QueueUrl != sqsTestQueueURL:
+			fixture = "sqs_queue_does_not_exist.json"
+			w.Header().Set("X-Amzn-Query-Error", "AWS.SimpleQueueService.NonExistentQueue;Sender")
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+		response, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Errorf("Failed to read fixture %s: %v", fixture, err)
+		}
+		if fixture == "sqs_queue_attributes.json" {
+			// return only the requested attributes, like SQS
+			var attributes struct{ Attributes map[string]string }
+			_ = json.Unmarshal(response, &attributes)
+			requested := map[string]string{}
+			for _, name := range input.AttributeNames {
+				requested[name] = attributes.Attributes[name]
+			}
+			response, _ = json.Marshal(map[string]any{"Attributes": requested})
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		_, _ = w.Write(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestSQSGetQueueLength(t *testing.T) {
+	// keep the developer's AWS config out of the test
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	server := newFakeSQSServer(t)
+
+	testCases := []struct {
+		name          string
+		config        SQSMetricsReaderConfig
+		expected      int
+		expectedError string
+		expectedClass string
+	}{
+		{
+			name:     "visible messages",
+			config:   SQSMetricsReaderConfig{QueueURL: sqsTestQueueURL},
+			expected: 120,
+		},
+		{
+			name:     "visible, in flight and delayed messages",
+			config:   SQSMetricsReaderConfig{QueueURL: sqsTestQueueURL, IncludeInFlight: true, IncludeDelayed: true},
+			expected: 140,
+		},
+		{
+			name:     "session token",
+			config:   SQSMetricsReaderConfig{QueueURL: sqsTestQueueURL, SessionToken: "token", IncludeInFlight: true},
+			expected: 136,
+		},
+		{
+			name:          "queue does not exist",
+			config:        SQSMetricsReaderConfig{QueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/missing"},
+			expectedError: "NonExistentQueue",
+			expectedClass: ERROR_CLASS_NOT_FOUND,
+		},
+		{
+			name:          "wrong secret",
+			config:        SQSMetricsReaderConfig{QueueURL: sqsTestQueueURL, SecretAccessKey: "wrong"},
+			expectedError: "SignatureDoesNotMatch",
+			expectedClass: ERROR_CLASS_PERMISSION_DENIED,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.config.Endpoint = server.URL
+		tc.config.AccessKeyID = "AKIDEXAMPLE"
+		if tc.config.SecretAccessKey == "" {
+			tc.config.SecretAccessKey = "secret"
+		}
+
+		s, err := NewSQSMetricsReader(tc.config)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v (%s)", err, tc.name)
+		}
+
+		queueLength, err := s.GetQueueLength(context.Background())
+		if tc.expectedError != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+				t.Errorf("Expected error containing %q, but got %v (%s)", tc.expectedError, err, tc.name)
+			}
+			if class, _ := ClassifyError(err); class != tc.expectedClass {
+				t.Errorf("Expected %s, but got %s (%s)", tc.expectedClass, class, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if queueLength != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, queueLength, tc.name)
+		}
+	}
+}
+
+func TestNewSQSMetricsReaderRegion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		config   SQSMetricsReaderConfig
+		expected string
+	}{
+		{name: "region from the queue URL", config: SQSMetricsReaderConfig{QueueURL: sqsTestQueueURL}, expected: "us-east-1"},
+		{name: "configured region", config: SQSMetricsReaderConfig{QueueURL: "http://localhost:4566/000000000000/embeddings", Region: "eu-west-1"}, expected: "eu-west-1"},
+		{name: "no region", config: SQSMetricsReaderConfig{QueueURL: "http://localhost:4566/000000000000/embeddings"}},
+		{name: "invalid queue URL", config: SQSMetricsReaderConfig{QueueURL: "embeddings"}},
+	}
+
+	for _, tc := range testCases {
+		tc.config.AccessKeyID = "AKIDEXAMPLE"
+		s, err := NewSQSMetricsReader(tc.config)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error (%s)", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if attempts := s.client.Options().RetryMaxAttempts; attempts != 1 {
+			t.Errorf("Expected %d SDK attempt, but got %d (%s)", 1, attempts, tc.name)
+		}
+		if region := s.client.Options().Region; region != tc.expected {
+			t.Errorf("Expected %s, but got %s (%s)", tc.expected, region, tc.name)
+		}
+	}
+}
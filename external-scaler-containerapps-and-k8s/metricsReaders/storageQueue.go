@@ -0,0 +1,216 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+const (
+	// STORAGE_QUEUE_API_VERSION is the x-ms-version of queue requests, bearer tokens need
+	// 2017-11-09 or later
+	STORAGE_QUEUE_API_VERSION = "2020-10-02"
+
+	storageQueueMessageCountHeader = "x-ms-approximate-messages-count"
+)
+
+// storageQueueError is the body of storage error responses
+type storageQueueError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// getStorageQueueEndpoint returns the queue service endpoint, the configured one, e.g. a local
+// emulator's http://127.0.0.1:10001/devstoreaccount1, or the account's endpoint in the cloud
+func (a *AzureMetricsReader) getStorageQueueEndpoint() (string, error) {
+	if a.storageQueueEndpoint != "" {
+		return strings.TrimSuffix(a.storageQueueEndpoint, "/"), nil
+	}
+	if a.storageAccountName == "" || a.cloud.StorageEndpointSuffix == "" {
+		return "", fmt.Errorf("the storage account name and the cloud's storage endpoint suffix, or the storage queue endpoint, are required")
+	}
+	return fmt.Sprintf("https://%s.queue.%s", a.storageAccountName, a.cloud.StorageEndpointSuffix), nil
+}
+
+// getStorageAccountName returns the configured account name, or the one in the endpoint: the
+// first path segment of emulator endpoints, or the first label of <account>.queue.<suffix> hosts
+func (a *AzureMetricsReader) getStorageAccountName() (string, error) {
+	if a.storageAccountName != "" {
+		return a.storageAccountName, nil
+	}
+	endpoint, err := a.getStorageQueueEndpoint()
+	if err != nil {
+		return "", err
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid storage queue endpoint: %w", err)
+	}
+	if account, _, _ := strings.Cut(strings.TrimPrefix(endpointURL.Path, "/"), "/"); account != "" {
+		return account, nil
+	}
+	account, _, _ := strings.Cut(endpointURL.Hostname(), ".")
+	return account, nil
+}
+
+// GetStorageQueueRequestUri returns the URI of the queue's Get Queue Metadata operation
+func (a *AzureMetricsReader) GetStorageQueueRequestUri() (string, error) {
+	endpoint, err := a.getStorageQueueEndpoint()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s?comp=metadata", endpoint, url.PathEscape(a.storageQueueName)), nil
+}
+
+// storageSharedKeyAuthorization returns the SharedKey authorization header value for req, see
+// https://learn.microsoft.com/rest/api/storageservices/authorize-with-shared-key
+func storageSharedKeyAuthorization(req *http.Request, accountName string, accountKey string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(accountKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid storage account key: %w", err)
+	}
+
+	var headerNames []string
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			headerNames = append(headerNames, lower)
+		}
+	}
+	sort.Strings(headerNames)
+	var canonicalizedHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalizedHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+
+	canonicalizedResource := "/" + accountName + req.URL.EscapedPath()
+	query := req.URL.Query()
+	var queryNames []string
+	for name := range query {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		values := query[name]
+		sort.Strings(values)
+		canonicalizedResource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+
+	// VERB, Content-Encoding, Content-Language, Content-Length, Content-MD5, Content-Type, Date,
+	// If-Modified-Since, If-Match, If-None-Match, If-Unmodified-Since and Range, all empty for
+	// a GET without a body, as x-ms-date is set
+	stringToSign := req.Method + strings.Repeat("\n", 12) + canonicalizedHeaders.String() + canonicalizedResource
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	return fmt.Sprintf("SharedKey %s:%s", accountName, base64.StdEncoding.EncodeToString(mac.Sum(nil))), nil
+}
+
+// authorizeStorageQueueRequest authorizes req with the account key, the SAS token, or else a
+// token for the storage audience from the reader's Azure credential
+func (a *AzureMetricsReader) authorizeStorageQueueRequest(req *http.Request) error {
+	switch {
+	case a.storageAccountKey != "":
+		accountName, err := a.getStorageAccountName()
+		if err != nil {
+			return err
+		}
+		authorization, err := storageSharedKeyAuthorization(req, accountName, a.storageAccountKey)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", authorization)
+
+	case a.storageSASToken != "":
+		sas, err := url.ParseQuery(strings.TrimPrefix(a.storageSASToken, "?"))
+		if err != nil {
+			return fmt.Errorf("invalid storage SAS token: %w", err)
+		}
+		query := req.URL.Query()
+		for name, values := range sas {
+			query[name] = values
+		}
+		req.URL.RawQuery = query.Encode()
+
+	default:
+		cred, err := a.getCredential()
+		if err != nil {
+			return fmt.Errorf("failed to get Azure credential: %w", err)
+		}
+		tok, err := cred.GetToken(req.Context(), policy.TokenRequestOptions{Scopes: []string{a.cloud.StorageScope()}})
+		if err != nil {
+			return fmt.Errorf("failed to get bearer token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tok.Token)
+	}
+
+	return nil
+}
+
+// getStorageQueueLength gets the approximate message count of the storage queue from the
+// queue's metadata
+func (a *AzureMetricsReader) getStorageQueueLength(ctx context.Context) (int, error) {
+	requestUri, err := a.GetStorageQueueRequestUri()
+	if err != nil {
+		return 0, err
+	}
+	slog.Debug(fmt.Sprintf("Request URI: %s\n", requestUri))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", requestUri, nil)
+	if err != nil {
+		return 0, fmt.Errorf("could not create get request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", "Go HTTP Client")
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", STORAGE_QUEUE_API_VERSION)
+	if err := a.authorizeStorageQueueRequest(req); err != nil {
+		return 0, err
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("could not make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return parseStorageQueueMetadataResponse(resp)
+}
+
+func parseStorageQueueMetadataResponse(resp *http.Response) (int, error) {
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		responseError := &ResponseError{StatusCode: resp.StatusCode, Code: resp.Header.Get("x-ms-error-code"), RetryAfter: parseRetryAfter(resp.Header)}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		if err == nil {
+			var errorResponse storageQueueError
+			if xml.Unmarshal(body, &errorResponse) == nil && errorResponse.Code != "" {
+				responseError.Code = errorResponse.Code
+				// the message's second line is the request ID and time
+				responseError.Message, _, _ = strings.Cut(errorResponse.Message, "\n")
+			} else {
+				responseError.Message = string(body)
+			}
+		}
+		return 0, fmt.Errorf("could not get storage queue metadata: %w", responseError)
+	}
+
+	count := resp.Header.Get(storageQueueMessageCountHeader)
+	queueLength, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s header %q: %w", storageQueueMessageCountHeader, count, err)
+	}
+
+	return queueLength, nil
+}
@@ -0,0 +1,150 @@
+package metricsReaders
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/manisbindra/kedaQueueLengthAndErrorRateExternalScaler/azureCredentials"
+)
+
+// the storage emulator's well known account key
+const storageEmulatorAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+// newFakeStorageQueueServer stands in for the queue service of accountName, whose queues are
+// at queuePath, answering Get Queue Metadata for requests authorized with a storage token,
+// the account key or a SAS with signature "fake-signature"
+func newFakeStorageQueueServer(t *testing.T, accountName string, queuePath string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-ms-version") != STORAGE_QUEUE_API_VERSION || r.URL.Query().Get("comp") != "metadata" {
+			t.Errorf("Unexpected request %s with version %s", r.URL, r.Header.Get("x-ms-version"))
+		}
+
+		key, _ := base64.StdEncoding.DecodeString(storageEmulatorAccountKey)
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("GET\n\n\n\n\n\n\n\n\n\n\n\n" +
+			"x-ms-date:" + r.Header.Get("x-ms-date") + "\nx-ms-version:" + STORAGE_QUEUE_API_VERSION + "\n" +
+			"/" + accountName + r.URL.Path + "\ncomp:metadata"))
+		sharedKey := "SharedKey " + accountName + ":" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+		var fixture string
+		switch {
+		case r.Header.Get("Authorization") != "Bearer https://storage.azure.com/.default" &&
+			r.Header.Get("Authorization") != sharedKey &&
+			r.URL.Query().Get("sig") != "fake-signature":
+			fixture = "storage_authentication_failed.xml"
+			w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+			w.WriteHeader(http.StatusForbidden)
+		case r.URL.Path != queuePath:
+			fixture = "storage_queue_not_found.xml"
+			w.Header().Set("x-ms-error-code", "QueueNotFound")
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("x-ms-approximate-messages-count", "37")
+			return
+		}
+
+		body, err := os.ReadFile(filepath.Join("testdata", fixture))
+		if err != nil {
+			t.Errorf("Failed to read fixture %s: %v", fixture, err)
+		}
+		_, _ = w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestStorageQueueGetQueueLength(t *testing.T) {
+	server := newFakeStorageQueueServer(t, "scalerstorage", "/embeddings")
+	emulator := newFakeStorageQueueServer(t, "devstoreaccount1", "/devstoreaccount1/embeddings")
+
+	testCases := []struct {
+		name               string
+		config             AzureMetricsReaderConfig
+		expected           int
+		expectedStatusCode int
+		expectedCode       string
+	}{
+		{
+			name:     "managed identity",
+			config:   AzureMetricsReaderConfig{StorageQueueEndpoint: server.URL, StorageQueueName: "embeddings"},
+			expected: 37,
+		},
+		{
+			name:     "account key",
+			config:   AzureMetricsReaderConfig{StorageQueueEndpoint: server.URL + "/", StorageAccountName: "scalerstorage", StorageQueueName: "embeddings", StorageAccountKey: storageEmulatorAccountKey},
+			expected: 37,
+		},
+		{
+			name:     "SAS token",
+			config:   AzureMetricsReaderConfig{StorageQueueEndpoint: server.URL, StorageQueueName: "embeddings", StorageSASToken: "?sv=2020-10-02&ss=q&srt=o&sp=r&se=2030-01-01T00:00:00Z&sig=fake-signature"},
+			expected: 37,
+		},
+		{
+			name:     "emulator account from the endpoint",
+			config:   AzureMetricsReaderConfig{StorageQueueEndpoint: emulator.URL + "/devstoreaccount1", StorageQueueName: "embeddings", StorageAccountKey: storageEmulatorAccountKey},
+			expected: 37,
+		},
+		{
+			name:               "wrong account key",
+			config:             AzureMetricsReaderConfig{StorageQueueEndpoint: server.URL, StorageAccountName: "scalerstorage", StorageQueueName: "embeddings", StorageAccountKey: base64.StdEncoding.EncodeToString([]byte("wrong"))},
+			expectedStatusCode: http.StatusForbidden,
+			expectedCode:       "AuthenticationFailed",
+		},
+		{
+			name:               "queue not found",
+			config:             AzureMetricsReaderConfig{StorageQueueEndpoint: server.URL, StorageQueueName: "missing"},
+			expectedStatusCode: http.StatusNotFound,
+			expectedCode:       "QueueNotFound",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.config.Credential = fakeTokenProvider{}
+		a := NewAzureMetricsReader(tc.config)
+
+		queueLength, err := a.GetQueueLength(context.Background())
+		if tc.expectedStatusCode != 0 {
+			var responseError *ResponseError
+			if !errors.As(err, &responseError) || responseError.StatusCode != tc.expectedStatusCode || responseError.Code != tc.expectedCode {
+				t.Errorf("Expected a ResponseError with status %d and code %s, but got %v (%s)", tc.expectedStatusCode, tc.expectedCode, err, tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: %v (%s)", err, tc.name)
+			continue
+		}
+		if queueLength != tc.expected {
+			t.Errorf("Expected %d, but got %d (%s)", tc.expected, queueLength, tc.name)
+		}
+	}
+}
+
+func TestGetStorageQueueRequestUri(t *testing.T) {
+	china, _ := azureCredentials.GetCloud(azureCredentials.AZURE_CHINA_CLOUD, azureCredentials.Cloud{})
+
+	a := NewAzureMetricsReader(AzureMetricsReaderConfig{StorageAccountName: "scalerstorage", StorageQueueName: "embeddings", Cloud: china})
+	requestUri, err := a.GetStorageQueueRequestUri()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requestUri != "https://scalerstorage.queue.core.chinacloudapi.cn/embeddings?comp=metadata" {
+		t.Errorf("Unexpected request URI %s", requestUri)
+	}
+
+	a = NewAzureMetricsReader(AzureMetricsReaderConfig{StorageQueueName: "embeddings"})
+	if _, err := a.GetStorageQueueRequestUri(); err == nil {
+		t.Errorf("Expected an error without an account name or endpoint")
+	}
+}
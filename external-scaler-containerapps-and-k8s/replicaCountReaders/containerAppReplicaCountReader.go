@@ -4,7 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/appcontainers/armappcontainers/v3"
 )
 
@@ -12,28 +15,31 @@ type ContainerAppReplicaCountReader struct {
 	SubscriptionID string
 	ResourceGroup  string
 	ContainerApp   string
+	Credential     azcore.TokenCredential
+	// ClientOptions sets the cloud and transport of the ARM client
+	ClientOptions *arm.ClientOptions
 }
 
-func NewContainerAppReplicaCountReader(subscriptionId, resourceGroup, containerApp string) *ContainerAppReplicaCountReader {
+// NewContainerAppReplicaCountReader creates a reader using ARM in cloudConfiguration, through
+// transport when it is not nil
+func NewContainerAppReplicaCountReader(subscriptionId, resourceGroup, containerApp string, credential azcore.TokenCredential, cloudConfiguration cloud.Configuration, transport policy.Transporter) *ContainerAppReplicaCountReader {
 	return &ContainerAppReplicaCountReader{
 		SubscriptionID: subscriptionId,
 		ResourceGroup:  resourceGroup,
 		ContainerApp:   containerApp,
+		Credential:     credential,
+		ClientOptions: &arm.ClientOptions{
+			ClientOptions: policy.ClientOptions{
+				Cloud:     cloudConfiguration,
+				Transport: transport,
+			},
+		},
 	}
 }
 
-func (c *ContainerAppReplicaCountReader) GetInstanceCount() (int, error) {
+func (c *ContainerAppReplicaCountReader) GetInstanceCount(ctx context.Context) (int, error) {
 
-	// Get the Azure Api token
-	cred, err := azidentity.NewDefaultAzureCredential(nil)
-	if err != nil {
-		// return wrapped error
-		return 0, fmt.Errorf("failed to get Azure credential: %w", err)
-	}
-
-	ctx := context.Background()
-
-	clientFactory, err := armappcontainers.NewClientFactory(c.SubscriptionID, cred, nil)
+	clientFactory, err := armappcontainers.NewClientFactory(c.SubscriptionID, c.Credential, c.ClientOptions)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create app containers client factory: %w", err)
 	}
@@ -43,6 +49,9 @@ func (c *ContainerAppReplicaCountReader) GetInstanceCount() (int, error) {
 		return 0, fmt.Errorf("failed to get container app: %w", err)
 	}
 
+	if contApp.ContainerApp.Properties == nil || contApp.ContainerApp.Properties.LatestReadyRevisionName == nil {
+		return 0, fmt.Errorf("container app %s has no ready revision", c.ContainerApp)
+	}
 	latestRevisionName := contApp.ContainerApp.Properties.LatestReadyRevisionName
 
 	revision, err := clientFactory.NewContainerAppsRevisionsClient().GetRevision(ctx, c.ResourceGroup, c.ContainerApp, *latestRevisionName, nil)
@@ -50,6 +59,10 @@ func (c *ContainerAppReplicaCountReader) GetInstanceCount() (int, error) {
 		return 0, fmt.Errorf("failed to get revision: %w", err)
 	}
 
+	if revision.Properties == nil || revision.Properties.Replicas == nil {
+		return 0, fmt.Errorf("revision %s has no replica count", *latestRevisionName)
+	}
+
 	return int(*revision.Properties.Replicas), nil
 
 }
@@ -18,7 +18,7 @@ func NewK8sDeploymentReplicaCountReader() *K8sDeploymentReplicaCountReader {
 	return &K8sDeploymentReplicaCountReader{}
 }
 
-func (k *K8sDeploymentReplicaCountReader) GetInstanceCount() (int, error) {
+func (k *K8sDeploymentReplicaCountReader) GetInstanceCount(ctx context.Context) (int, error) {
 	// Get the kubeconfig file path
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -31,13 +31,13 @@ func (k *K8sDeploymentReplicaCountReader) GetInstanceCount() (int, error) {
 	}
 
 	if err != nil {
-		return 0, fmt.Errorf("failed to create clientset: %v", err)
+		return 0, fmt.Errorf("failed to create clientset: %w", err)
 	}
 
 	// Get the deployment
-	deployment, err := clientset.AppsV1().Deployments(k.DeploymentNamespace).Get(context.Background(), k.DeploymentName, metav1.GetOptions{})
+	deployment, err := clientset.AppsV1().Deployments(k.DeploymentNamespace).Get(ctx, k.DeploymentName, metav1.GetOptions{})
 	if err != nil {
-		return 0, fmt.Errorf("failed to get deployment: %v", err)
+		return 0, fmt.Errorf("failed to get deployment: %w", err)
 	}
 
 	// Return the number of replicas